@@ -128,3 +128,11 @@ func Benchmark64kChunksStream(b *testing.B) {
 	data := randomData(155, size)
 	benchmarkStream(b, size, data, With64kChunks(), WithStreamMode())
 }
+
+func BenchmarkSketch(b *testing.B) {
+	chunk := randomData(1, 64*1024)
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		NewSketch(chunk)
+	}
+}
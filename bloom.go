@@ -0,0 +1,284 @@
+package fastcdc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+var ErrInvalidBloomFilter = errors.New("invalid bloom filter")
+
+// bloomMagic start every persisted bloom filter.
+var bloomMagic = []byte("FCBF")
+
+const (
+	// maxBloomHashes is the maximum number of hash functions, enough
+	// for a false positive rate far below 1e-18.
+	maxBloomHashes = 64
+	// bloomReadWords is the number of words allocated at once while reading
+	// a filter, so a corrupted size can't trigger a huge allocation.
+	bloomReadWords = 1 << 16
+)
+
+// BloomFilter is a memory-bounded probabilistic set of digests. A digest which is not in
+// the filter is always reported as such, while a digest reported in the filter may be
+// a false positive. The false positive rate stay close to the target as long as the
+// number of digests added does not exceed the expected count. BloomFilter is safe for
+// concurrent use.
+type BloomFilter struct {
+	mu    sync.RWMutex
+	bits  []uint64
+	m     uint64
+	k     uint64
+	count uint64
+}
+
+// NewBloomFilter return a bloom filter sized to hold the expected number of digests with
+// the given false positive rate. For example, 1 billion digests with a 1% false positive
+// rate requires about 1.1 GiB.
+func NewBloomFilter(expected uint, fpRate float64) *BloomFilter {
+	if expected == 0 {
+		expected = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	// Optimal number of bits and hash functions
+	// https://en.wikipedia.org/wiki/Bloom_filter#Optimal_number_of_hash_functions
+	m := uint64(math.Ceil(-float64(expected) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Round(float64(m) / float64(expected) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > maxBloomHashes {
+		k = maxBloomHashes
+	}
+	return &BloomFilter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add add the digest to the filter.
+func (b *BloomFilter) Add(digest Digest) {
+	h1, h2 := bloomHashes(digest)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+// Test report whether the digest may be in the filter. If
+// Test return false, the digest is definitely not in the filter.
+func (b *BloomFilter) Test(digest Digest) bool {
+	h1, h2 := bloomHashes(digest)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count return the number of digests added to the filter.
+func (b *BloomFilter) Count() uint {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return uint(b.count)
+}
+
+// bloomHashes derive the two hashes used for the double hashing from the digest,
+// which is already uniformly distributed.
+func bloomHashes(digest Digest) (uint64, uint64) {
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+	return h1, h2
+}
+
+// WriteTo write the filter to w. The layout is the magic "FCBF", the number of hash
+// functions, the number of bits, the number of digests added, the bits and a crc32-c
+// of all the preceding bytes, all integers are 64 bits little endian.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var n int64
+	write := func(p []byte) error {
+		c, err := bw.Write(p)
+		n += int64(c)
+		return err
+	}
+
+	buf := make([]byte, 8)
+	if err := write(bloomMagic); err != nil {
+		return n, err
+	}
+	for _, v := range []uint64{b.k, b.m, b.count} {
+		binary.LittleEndian.PutUint64(buf, v)
+		if err := write(buf); err != nil {
+			return n, err
+		}
+	}
+	for _, word := range b.bits {
+		binary.LittleEndian.PutUint64(buf, word)
+		if err := write(buf); err != nil {
+			return n, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+
+	binary.LittleEndian.PutUint32(buf, crc.Sum32())
+	c, err := w.Write(buf[:4])
+	return n + int64(c), err
+}
+
+// ReadBloomFilter read a filter written by WriteTo.
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	crc := crc32.New(crcTable)
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+
+	header := make([]byte, len(bloomMagic)+24)
+	if _, err := io.ReadFull(tr, header); err != nil {
+		return nil, truncatedBloomFilter(err)
+	}
+	if string(header[:len(bloomMagic)]) != string(bloomMagic) {
+		return nil, fmt.Errorf("bad magic: %w", ErrInvalidBloomFilter)
+	}
+	header = header[len(bloomMagic):]
+	b := &BloomFilter{
+		k:     binary.LittleEndian.Uint64(header[0:8]),
+		m:     binary.LittleEndian.Uint64(header[8:16]),
+		count: binary.LittleEndian.Uint64(header[16:24]),
+	}
+	if b.k == 0 || b.k > maxBloomHashes || b.m == 0 || b.m%64 != 0 || b.m/64 > math.MaxInt32 {
+		return nil, fmt.Errorf("bad parameters: %w", ErrInvalidBloomFilter)
+	}
+
+	// The bits are allocated as they are read, a filter
+	// shorter than its header claims is reported as truncated.
+	words := int(b.m / 64)
+	b.bits = make([]uint64, 0, minInt(words, bloomReadWords))
+	buf := make([]byte, 8)
+	for len(b.bits) < words {
+		if _, err := io.ReadFull(tr, buf); err != nil {
+			return nil, truncatedBloomFilter(err)
+		}
+		b.bits = append(b.bits, binary.LittleEndian.Uint64(buf))
+	}
+
+	want := crc.Sum32()
+	if _, err := io.ReadFull(br, buf[:4]); err != nil {
+		return nil, truncatedBloomFilter(err)
+	}
+	if binary.LittleEndian.Uint32(buf[:4]) != want {
+		return nil, fmt.Errorf("checksum mismatch: %w", ErrInvalidBloomFilter)
+	}
+	return b, nil
+}
+
+// Save atomically write the filter to the file at path.
+func (b *BloomFilter) Save(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := b.WriteTo(w)
+		return err
+	})
+}
+
+// LoadBloomFilter read a filter saved at path. The file must
+// contain exactly the filter.
+func LoadBloomFilter(path string) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ReadBloomFilter(file)
+	if err != nil {
+		return nil, err
+	}
+	if size := int64(len(bloomMagic)) + 24 + int64(b.m/8) + 4; info.Size() != size {
+		return nil, fmt.Errorf("file size %d, want %d: %w", info.Size(), size, ErrInvalidBloomFilter)
+	}
+	return b, nil
+}
+
+func minInt(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func truncatedBloomFilter(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated filter: %w", ErrInvalidBloomFilter)
+	}
+	return err
+}
+
+// IndexedStore put a bloom filter in front of a chunk store. Has only reach the store
+// when the filter report that the chunk may be stored, so most new chunks never hit the
+// slower exact lookup. The filter must contain every chunk of the store, use Index to
+// add the chunks stored before the filter was created.
+type IndexedStore struct {
+	ChunkStore
+	filter *BloomFilter
+}
+
+// NewIndexedStore return a store using the filter as pre-check.
+func NewIndexedStore(store ChunkStore, filter *BloomFilter) *IndexedStore {
+	return &IndexedStore{
+		ChunkStore: store,
+		filter:     filter,
+	}
+}
+
+// Filter return the bloom filter of the store.
+func (s *IndexedStore) Filter() *BloomFilter {
+	return s.filter
+}
+
+// Index add the digest to the filter without storing the chunk.
+func (s *IndexedStore) Index(digest Digest) {
+	s.filter.Add(digest)
+}
+
+// Put add the digest to the filter and store the chunk.
+func (s *IndexedStore) Put(digest Digest, chunk []byte) error {
+	// Add the digest first, a chunk in the filter
+	// but not in the store is only a false positive.
+	s.filter.Add(digest)
+	return s.ChunkStore.Put(digest, chunk)
+}
+
+// Has report whether the chunk is stored. The store is only
+// queried if the filter report that the chunk may be stored.
+func (s *IndexedStore) Has(digest Digest) (bool, error) {
+	if !s.filter.Test(digest) {
+		return false, nil
+	}
+	return s.ChunkStore.Has(digest)
+}
@@ -0,0 +1,172 @@
+package fastcdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func digestOf(i int) Digest {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(i))
+	return Sum(buf)
+}
+
+func TestBloomFilter(t *testing.T) {
+	const expected = 100_000
+	const fpRate = 0.01
+
+	filter := NewBloomFilter(expected, fpRate)
+	for i := 0; i < expected; i++ {
+		filter.Add(digestOf(i))
+	}
+
+	for i := 0; i < expected; i++ {
+		if !filter.Test(digestOf(i)) {
+			t.Fatalf("false negative for digest %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := expected; i < 2*expected; i++ {
+		if filter.Test(digestOf(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / expected; rate > 2*fpRate {
+		t.Errorf("false positive rate: want <= %f, got = %f", 2*fpRate, rate)
+	}
+	if filter.Count() != expected {
+		t.Errorf("count: want = %d, got = %d", expected, filter.Count())
+	}
+}
+
+func TestBloomFilterPersistence(t *testing.T) {
+	filter := NewBloomFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Add(digestOf(i))
+	}
+
+	path := filepath.Join(t.TempDir(), "index.bloom")
+	if err := filter.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBloomFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != filter.Count() || loaded.k != filter.k || loaded.m != filter.m {
+		t.Errorf("want = %d/%d/%d, got = %d/%d/%d", filter.Count(), filter.k, filter.m, loaded.Count(), loaded.k, loaded.m)
+	}
+	for i := 0; i < 1000; i++ {
+		if !loaded.Test(digestOf(i)) {
+			t.Fatalf("false negative for digest %d", i)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := filter.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	header := func(k, m uint64) []byte {
+		header := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(header[4:], k)
+		binary.LittleEndian.PutUint64(header[12:], m)
+		return header
+	}
+
+	for name, data := range map[string][]byte{
+		"truncated": data[:len(data)-1],
+		"corrupted": corrupted,
+		"magic":     append([]byte("XXXX"), data[4:]...),
+		"huge m":    header(filter.k, 1<<62),
+		"larger m":  header(filter.k, filter.m+64),
+		"huge k":    header(1<<40, filter.m),
+		"zero k":    header(0, filter.m),
+		"odd m":     header(filter.k, filter.m+1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadBloomFilter(bytes.NewReader(data)); !errors.Is(err, ErrInvalidBloomFilter) {
+				t.Errorf("want = %s, got = %s", ErrInvalidBloomFilter, err)
+			}
+		})
+	}
+
+	// Trailing bytes in a saved filter are rejected
+	if err := ioutil.WriteFile(path, append(data, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBloomFilter(path); !errors.Is(err, ErrInvalidBloomFilter) {
+		t.Errorf("want = %s, got = %s", ErrInvalidBloomFilter, err)
+	}
+
+	// A tiny false positive rate still produce a readable filter
+	buf.Reset()
+	if _, err := NewBloomFilter(10, 1e-300).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBloomFilter(buf); err != nil {
+		t.Error(err)
+	}
+}
+
+// hasCountingStore count the lookups reaching the underlying store.
+type hasCountingStore struct {
+	ChunkStore
+	mu  sync.Mutex
+	has int
+}
+
+func (s *hasCountingStore) Has(digest Digest) (bool, error) {
+	s.mu.Lock()
+	s.has++
+	s.mu.Unlock()
+	return s.ChunkStore.Has(digest)
+}
+
+func TestIndexedStore(t *testing.T) {
+	backend := &hasCountingStore{ChunkStore: NewMemoryStore()}
+	store := NewIndexedStore(backend, NewBloomFilter(1000, 0.01))
+
+	for i := 0; i < 1000; i++ {
+		d := digestOf(i)
+		if err := store.Put(d, d[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		ok, err := store.Has(digestOf(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("digest %d not found", i)
+		}
+	}
+	if backend.has != 1000 {
+		t.Errorf("lookups: want = 1000, got = %d", backend.has)
+	}
+
+	backend.has = 0
+	for i := 1000; i < 2000; i++ {
+		ok, err := store.Has(digestOf(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("digest %d found", i)
+		}
+	}
+	// Only the false positives reach the store
+	if backend.has > 50 {
+		t.Errorf("lookups: want <= 50, got = %d", backend.has)
+	}
+}
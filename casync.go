@@ -0,0 +1,259 @@
+package fastcdc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tigerwill90/fastcdc/internal/zstd"
+)
+
+// ErrUnsupportedCompression is returned when a casync chunk requires a zstd dictionary.
+var ErrUnsupportedCompression = errors.New("unsupported compression")
+
+// CasyncAlgorithm is the algorithm recorded in the manifests read from casync indexes,
+// which don't record the chunking algorithm.
+const CasyncAlgorithm = "casync"
+
+// casync format constants, from casync caformat.h.
+const (
+	caFormatIndex           = 0x96824d9c7b129ff9
+	caFormatTable           = 0xe75b9e112f17417d
+	caFormatTableTailMarker = 0x4b4f050e5549ecd1
+	caFormatSHA512256       = 0x2000000000000000
+	caFormatExcludeNoDump   = 0x8000000000000000
+
+	caIndexHeaderSize = 48
+	caTableHeaderSize = 16
+	caTableItemSize   = 40
+	caTableTailSize   = 40
+)
+
+// WriteCasyncIndex write the manifest to w as a casync index (.caibx or .caidx), readable
+// by casync and desync. The chunks must cover the file without gaps nor overlaps. Chunks are
+// identified by their SHA-256 digest, so casync and desync must be configured to use SHA-256.
+func WriteCasyncIndex(w io.Writer, manifest *Manifest) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, caIndexHeaderSize)
+	buf = appendUint64LE(buf, caIndexHeaderSize)
+	buf = appendUint64LE(buf, caFormatIndex)
+	buf = appendUint64LE(buf, caFormatExcludeNoDump)
+	buf = appendUint64LE(buf, uint64(manifest.MinSize))
+	buf = appendUint64LE(buf, uint64(manifest.AvgSize))
+	buf = appendUint64LE(buf, uint64(manifest.MaxSize))
+	// The table size is unknown when the table header is written
+	buf = appendUint64LE(buf, ^uint64(0))
+	buf = appendUint64LE(buf, caFormatTable)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	var end uint
+	for i, chunk := range manifest.Chunks {
+		if chunk.Offset != end {
+			return fmt.Errorf("chunk %d at offset %d does not follow the previous chunk: %w", i, chunk.Offset, ErrInvalidManifest)
+		}
+		end += chunk.Length
+		buf = appendUint64LE(buf[:0], uint64(end))
+		buf = append(buf, chunk.Digest[:]...)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if end != manifest.Size {
+		return fmt.Errorf("chunks end at %d instead of %d: %w", end, manifest.Size, ErrInvalidManifest)
+	}
+
+	tableSize := caTableHeaderSize + caTableItemSize*len(manifest.Chunks) + caTableTailSize
+	buf = appendUint64LE(buf[:0], 0)
+	buf = appendUint64LE(buf, 0)
+	buf = appendUint64LE(buf, caIndexHeaderSize)
+	buf = appendUint64LE(buf, uint64(tableSize))
+	buf = appendUint64LE(buf, caFormatTableTailMarker)
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadCasyncIndex read a casync index (.caibx or .caidx) and return its manifest. The
+// manifest algorithm is CasyncAlgorithm. Only indexes of SHA-256 chunks are supported,
+// other indexes are reported with ErrIncompatibleManifest.
+func ReadCasyncIndex(r io.Reader) (*Manifest, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, caIndexHeaderSize+caTableHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	le := binary.LittleEndian
+	if le.Uint64(header) != caIndexHeaderSize || le.Uint64(header[8:]) != caFormatIndex {
+		return nil, fmt.Errorf("missing casync index header: %w", ErrInvalidManifest)
+	}
+	if le.Uint64(header[16:])&caFormatSHA512256 != 0 {
+		return nil, fmt.Errorf("SHA512/256 chunks digests: %w", ErrIncompatibleManifest)
+	}
+	if le.Uint64(header[48:]) != ^uint64(0) || le.Uint64(header[56:]) != caFormatTable {
+		return nil, fmt.Errorf("missing casync table header: %w", ErrInvalidManifest)
+	}
+
+	manifest := &Manifest{
+		ManifestHeader: ManifestHeader{
+			Version:   ManifestVersion,
+			Algorithm: CasyncAlgorithm,
+			MinSize:   uint(le.Uint64(header[24:])),
+			AvgSize:   uint(le.Uint64(header[32:])),
+			MaxSize:   uint(le.Uint64(header[40:])),
+		},
+		Chunks: make([]ManifestChunk, 0),
+	}
+
+	item := make([]byte, caTableItemSize)
+	for {
+		if _, err := io.ReadFull(br, item); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		end := le.Uint64(item)
+		if end == 0 {
+			break
+		}
+		if end <= uint64(manifest.Size) {
+			return nil, fmt.Errorf("casync table offsets are not increasing: %w", ErrInvalidManifest)
+		}
+		chunk := ManifestChunk{Offset: manifest.Size, Length: uint(end) - manifest.Size}
+		copy(chunk.Digest[:], item[8:])
+		manifest.Chunks = append(manifest.Chunks, chunk)
+		manifest.Size = uint(end)
+	}
+
+	// The first zero of the tail was read as the offset of an item
+	tableSize := caTableHeaderSize + caTableItemSize*len(manifest.Chunks) + caTableTailSize
+	if le.Uint64(item[8:]) != 0 ||
+		le.Uint64(item[16:]) != caIndexHeaderSize ||
+		le.Uint64(item[24:]) != uint64(tableSize) ||
+		le.Uint64(item[32:]) != caFormatTableTailMarker {
+		return nil, fmt.Errorf("invalid casync table tail: %w", ErrInvalidManifest)
+	}
+	return manifest, nil
+}
+
+// casyncChunkExt is the extension of compressed chunks in a casync store.
+const casyncChunkExt = ".cacnk"
+
+// CasyncStore is a ChunkStore using the layout of casync and desync local stores: each chunk
+// is stored zstd compressed in a file named after its digest with a .cacnk extension, in
+// fan-out directories named after the first 4 hex digits of the digest.
+//
+// Chunks compressed by casync, desync or any zstd encoder are read, unless they require a
+// zstd dictionary. Chunks are written with a simpler encoder than theirs, which compress less.
+type CasyncStore struct {
+	root string
+}
+
+// NewCasyncStore return a CasyncStore rooted at the given directory. The
+// directory is created if it does not exist.
+func NewCasyncStore(root string) (*CasyncStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &CasyncStore{root: root}, nil
+}
+
+// Put store the chunk in a zstd frame, written atomically.
+func (s *CasyncStore) Put(digest Digest, chunk []byte) error {
+	path := s.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(zstd.Encode(chunk))
+		return err
+	})
+}
+
+// Get return the decompressed chunk.
+func (s *CasyncStore) Get(digest Digest) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+		}
+		return nil, err
+	}
+	chunk, err := zstd.Decode(data, int(MaximumMax))
+	if errors.Is(err, zstd.ErrUnsupported) {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrUnsupportedCompression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
+
+// Has report whether the chunk with the given digest is stored.
+func (s *CasyncStore) Has(digest Digest) (bool, error) {
+	_, err := os.Stat(s.path(digest))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Delete remove the chunk with the given digest.
+func (s *CasyncStore) Delete(digest Digest) error {
+	err := os.Remove(s.path(digest))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return err
+}
+
+// Walk call fn with the digest of every stored chunk, in increasing digest order.
+func (s *CasyncStore) Walk(fn func(digest Digest) error) error {
+	dirs, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 4 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.root, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), casyncChunkExt) {
+				continue
+			}
+			digest, err := ParseDigest(strings.TrimSuffix(file.Name(), casyncChunkExt))
+			if err != nil {
+				continue
+			}
+			if err := fn(digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *CasyncStore) path(digest Digest) string {
+	name := digest.String()
+	return filepath.Join(s.root, name[:4], name+casyncChunkExt)
+}
+
+func appendUint64LE(buf []byte, v uint64) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
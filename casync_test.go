@@ -0,0 +1,164 @@
+package fastcdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCasyncIndex(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, nil)
+
+	buf := new(bytes.Buffer)
+	if err := WriteCasyncIndex(buf, manifest); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Index header, table header, 6 items and table tail
+	if len(data) != 48+16+6*40+40 {
+		t.Errorf("index size: want = %d, got = %d", 48+16+6*40+40, len(data))
+	}
+	le := binary.LittleEndian
+	if le.Uint64(data[8:]) != caFormatIndex || le.Uint64(data[56:]) != caFormatTable {
+		t.Error("invalid headers")
+	}
+	if got := le.Uint64(data[64:]); got != uint64(manifest.Chunks[0].Length) {
+		t.Errorf("first chunk end: want = %d, got = %d", manifest.Chunks[0].Length, got)
+	}
+	if le.Uint64(data[len(data)-8:]) != caFormatTableTailMarker {
+		t.Error("missing tail marker")
+	}
+
+	got, err := ReadCasyncIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Algorithm != CasyncAlgorithm {
+		t.Errorf("algorithm: want = %s, got = %s", CasyncAlgorithm, got.Algorithm)
+	}
+	if !reflect.DeepEqual(manifest.Chunks, got.Chunks) || manifest.Size != got.Size {
+		t.Errorf("want = %+v, got = %+v", manifest, got)
+	}
+	if got.MinSize != manifest.MinSize || got.AvgSize != manifest.AvgSize || got.MaxSize != manifest.MaxSize {
+		t.Errorf("chunks size: want = %+v, got = %+v", manifest.ManifestHeader, got.ManifestHeader)
+	}
+
+	sha512 := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(sha512[16:], caFormatSHA512256|caFormatExcludeNoDump)
+	tail := append([]byte(nil), data...)
+	tail[len(tail)-1] ^= 0xff
+
+	tests := map[string]struct {
+		Data []byte
+		Want error
+	}{
+		"truncated":   {data[:len(data)-50], ErrInvalidManifest},
+		"bad tail":    {tail, ErrInvalidManifest},
+		"sha512/256":  {sha512, ErrIncompatibleManifest},
+		"not casync":  {bytes.Repeat([]byte{1}, 100), ErrInvalidManifest},
+		"empty input": {nil, ErrInvalidManifest},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadCasyncIndex(bytes.NewReader(tc.Data)); !errors.Is(err, tc.Want) {
+				t.Errorf("want = %s, got = %s", tc.Want, err)
+			}
+		})
+	}
+
+	gap := *manifest
+	gap.Chunks = append([]ManifestChunk(nil), manifest.Chunks...)
+	gap.Chunks[2].Offset++
+	if err := WriteCasyncIndex(new(bytes.Buffer), &gap); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("want = %s, got = %s", ErrInvalidManifest, err)
+	}
+}
+
+func TestCasyncStore(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewCasyncStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := [][]byte{randomData(41, 300000), {}, []byte("fastcdc")}
+	for _, chunk := range chunks {
+		digest := Sum(chunk)
+		if err := store.Put(digest, chunk); err != nil {
+			t.Fatal(err)
+		}
+		name := digest.String()
+		if _, err := os.Stat(filepath.Join(root, name[:4], name+".cacnk")); err != nil {
+			t.Errorf("chunk %s not stored in the casync layout: %s", name, err)
+		}
+		got, err := store.Get(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(chunk, got) {
+			t.Errorf("chunk %s mismatch", name)
+		}
+	}
+
+	// Chunks are compressed
+	repeated := bytes.Repeat([]byte("fastcdc "), 10_000)
+	if err := store.Put(Sum(repeated), repeated); err != nil {
+		t.Fatal(err)
+	}
+	chunks = append(chunks, repeated)
+	name := Sum(repeated).String()
+	if info, err := os.Stat(filepath.Join(root, name[:4], name+".cacnk")); err != nil || info.Size() > int64(len(repeated)/10) {
+		t.Errorf("chunk %s not compressed: %v", name, err)
+	}
+
+	// Chunks compressed by the reference zstd encoder, like casync and desync chunks
+	sekien, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name = Sum(sekien).String()
+	if err := os.MkdirAll(filepath.Join(root, name[:4]), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, name[:4], name+".cacnk"), frame, 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunks = append(chunks, sekien)
+	got, err := store.Get(Sum(sekien))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sekien, got) {
+		t.Error("reference chunk mismatch")
+	}
+
+	// Corrupted chunks are reported
+	if err := ioutil.WriteFile(filepath.Join(root, name[:4], name+".cacnk"), frame[:len(frame)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(Sum(sekien)); !errors.Is(err, ErrCorruptedChunk) {
+		t.Errorf("want = %s, got = %v", ErrCorruptedChunk, err)
+	}
+
+	walked := 0
+	if err := store.Walk(func(digest Digest) error { walked++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if walked != len(chunks) {
+		t.Errorf("walked: want = %d, got = %d", len(chunks), walked)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"math"
 )
@@ -18,6 +17,10 @@ const (
 	MaximumMax uint = 1_073_741_824
 )
 
+// cancelCheckInterval is the number of bytes scanned between two checks
+// of the context, a single chunk can be up to MaximumMax bytes long.
+const cancelCheckInterval uint = 65_536
+
 type FastCDC struct {
 	buffer            []byte
 	carry             uint
@@ -29,90 +32,51 @@ type FastCDC struct {
 	maskS             uint
 	maskL             uint
 	previousBytesRead uint
+	end               uint
 	streamMode        bool
+	lowLatency        bool
+	readAhead         bool
+	readAheadBuffers  [][]byte
 	firstCall         bool
 	ctx               context.Context
+	progressFn        ProgressFn
+	progressInterval  uint
+	nextProgress      uint
+	bytesConsumed     uint
+	chunksEmitted     uint
+	config            *Config
 }
 
 var (
 	ErrInvalidChunksSizePoint = errors.New("invalid chunks size")
 	ErrInvalidBufferLength    = errors.New("invalid buffer length")
+	ErrIncompatibleOptions    = errors.New("incompatible options")
 )
 
 // NewChunker return a cancelable blazing fast chunker
 func NewChunker(ctx context.Context, opts ...Option) (*FastCDC, error) {
-	config := defaultConfig()
-
-	for _, opt := range opts {
-		opt(config)
+	config, err := NewConfig(opts...)
+	if err != nil {
+		return nil, err
 	}
+	return config.newChunker(ctx, make([]byte, config.bufferSize)), nil
+}
 
-	if config.bufferSize == 0 {
-		config.bufferSize = 2 * config.maxSize
-	}
-
-	const (
-		errMinMsg = "chunks size must be at least"
-		errMaxMsg = "chunks size must be equal or lesser than"
-	)
-
-	if config.minSize < MinimumMin {
-		return nil, fmt.Errorf("the minimum %s %d: %w", errMinMsg, MinimumMin, ErrInvalidChunksSizePoint)
-	}
-	if config.minSize > MinimumMax {
-		return nil, fmt.Errorf("the minimum %s %d: %w", errMaxMsg, MinimumMax, ErrInvalidChunksSizePoint)
-	}
-	if config.avgSize < AverageMin {
-		return nil, fmt.Errorf("the average %s %d: %w", errMinMsg, AverageMin, ErrInvalidChunksSizePoint)
-	}
-	if config.avgSize > AverageMax {
-		return nil, fmt.Errorf("the average %s %d: %w", errMaxMsg, AverageMax, ErrInvalidChunksSizePoint)
-	}
-	if config.maxSize < MaximumMin {
-		return nil, fmt.Errorf("the maximum %s %d: %w", errMinMsg, MaximumMin, ErrInvalidChunksSizePoint)
+// Release put the internal buffers back in the configuration pool.
+// The chunker must not be used after calling Release.
+func (f *FastCDC) Release() {
+	// Release may be called more than once
+	if f.buffer == nil {
+		return
 	}
-	if config.maxSize > MaximumMax {
-		return nil, fmt.Errorf("the maximum %s %d: %w", errMaxMsg, MaximumMax, ErrInvalidChunksSizePoint)
+	f.config.putBuffer(f.buffer)
+	// The read-ahead buffers are dropped when the split is canceled
+	// since they may still be in use by the read-ahead goroutine.
+	for _, buf := range f.readAheadBuffers {
+		f.config.putBuffer(buf)
 	}
-	if config.bufferSize < config.maxSize {
-		return nil, fmt.Errorf("the buffer size must be greater or equal than the maximum cutting point (%d): %w", config.maxSize, ErrInvalidBufferLength)
-	}
-	if config.minSize >= config.avgSize {
-		return nil, fmt.Errorf("the minimum chunks size must be smaller than the average: %w", ErrInvalidChunksSizePoint)
-	}
-	if config.maxSize <= config.avgSize {
-		return nil, fmt.Errorf("the maximum chunks size must be bigger than the average: %w", ErrInvalidChunksSizePoint)
-	}
-	if config.maxSize-config.minSize <= config.avgSize {
-		return nil, fmt.Errorf("maximum - minimum chunks size must be bigger than the average chunk size: %w", ErrInvalidChunksSizePoint)
-	}
-
-	var bufferSize uint
-	if remaining := config.bufferSize % config.maxSize; remaining == 0 {
-		bufferSize = config.bufferSize
-	} else {
-		// Correct the buffer size to be a multiple of max size.
-		// This guarantees that the chunks will always have the
-		// same size regardless of the size of the buffer.
-		bufferSize = config.bufferSize + config.maxSize - remaining
-	}
-
-	bits := logarithm2(config.avgSize)
-	// Mask use 1 bits normalization.
-	// https://github.com/ronomon/deduplication#content-dependent-chunking
-	maskS := mask(bits + 1)
-	maskL := mask(bits - 1)
-
-	return &FastCDC{
-		buffer:     make([]byte, bufferSize),
-		minSize:    config.minSize,
-		avgSize:    config.avgSize,
-		maxSize:    config.maxSize,
-		streamMode: config.stream,
-		maskS:      maskS,
-		maskL:      maskL,
-		ctx:        ctx,
-	}, nil
+	f.buffer = nil
+	f.readAheadBuffers = nil
 }
 
 // ChunkFn is called by the split function when a chunk is found.
@@ -120,6 +84,10 @@ func NewChunker(ctx context.Context, opts ...Option) (*FastCDC, error) {
 // later use.
 type ChunkFn func(offset, length uint, chunk []byte) error
 
+// ProgressFn is called periodically during the split with the number of
+// bytes consumed from the readers and the number of chunks emitted so far.
+type ProgressFn func(bytesConsumed, chunksEmitted uint)
+
 // Split take the current reader and try to find chunk of the defined average size. When a chunk is
 // found, Split call the callback function with the offset, length and chunk. Split reuse it's
 // internal buffer, thereby the chunk is only valid within the callback. For later use, you most perform a copy value
@@ -144,7 +112,11 @@ func (f *FastCDC) Finalize(fn ChunkFn) error {
 		f.carry = 0
 		f.realOffset = 0
 		f.previousBytesRead = 0
+		f.end = 0
 		f.firstCall = false
+		f.bytesConsumed = 0
+		f.chunksEmitted = 0
+		f.nextProgress = f.progressInterval
 	}()
 
 	select {
@@ -153,6 +125,18 @@ func (f *FastCDC) Finalize(fn ChunkFn) error {
 	default:
 	}
 
+	// In low latency mode, every chunk with a decidable boundary
+	// has already been emitted, only the tail remains.
+	if f.lowLatency {
+		if length := f.end - f.offset + f.carry; length > 0 {
+			if err := f.emit(fn, f.realOffset, length, f.buffer[f.offset-f.carry:f.end]); err != nil {
+				return err
+			}
+		}
+		f.progress(true)
+		return nil
+	}
+
 	reader := bytes.NewReader(nil)
 	// chunk the remaining part
 	if f.streamMode {
@@ -161,12 +145,39 @@ func (f *FastCDC) Finalize(fn ChunkFn) error {
 		}
 	}
 	if f.carry > 0 {
-		return fn(f.realOffset, f.carry, f.buffer[0:f.carry])
+		if err := f.emit(fn, f.realOffset, f.carry, f.buffer[0:f.carry]); err != nil {
+			return err
+		}
 	}
+	f.progress(true)
 	return nil
 }
 
-func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) error {
+func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) (err error) {
+	if f.readAhead && eof == nil {
+		if f.readAheadBuffers == nil {
+			f.readAheadBuffers = [][]byte{f.config.getBuffer(), f.config.getBuffer()}
+		}
+		r := newReadAhead(f.ctx, data, f.readAheadBuffers)
+		defer func() {
+			// On error, the goroutine may be blocked on a read that never
+			// returns, so don't wait for it and drop the buffers.
+			if err != nil {
+				r.abort()
+				f.readAheadBuffers = nil
+				return
+			}
+			if !r.close() {
+				f.readAheadBuffers = nil
+			}
+		}()
+		data = r
+	}
+
+	if f.lowLatency {
+		return f.splitLowLatency(data, fn)
+	}
+
 	f.firstCall = true
 	for {
 		select {
@@ -180,6 +191,7 @@ func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) error {
 		if err != nil && err != io.EOF {
 			return err
 		}
+		f.bytesConsumed += uint(bytesRead)
 
 		if err == io.EOF && f.previousBytesRead == 0 {
 			return nil
@@ -193,6 +205,7 @@ func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) error {
 			remaining := uint(len(f.buffer)) - f.carry - f.previousBytesRead - uint(bytesRead)
 			if remaining != 0 && eof != io.EOF {
 				f.previousBytesRead += uint(bytesRead)
+				f.progress(false)
 				return nil
 			}
 		}
@@ -203,13 +216,24 @@ func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) error {
 
 		// Find chunk for the current buffer
 		for f.offset < bytesReadWithCarry {
-			breakpoint := f.breakpoint(f.buffer[f.offset:bytesReadWithCarry])
+			// Honor the cancellation between each chunk, a single
+			// buffer can be very large.
+			select {
+			case <-f.ctx.Done():
+				return f.ctx.Err()
+			default:
+			}
+
+			breakpoint, err := f.breakpoint(f.buffer[f.offset:bytesReadWithCarry])
+			if err != nil {
+				return err
+			}
 			if breakpoint != 0 {
 				endOffset := breakpoint + f.offset
 				// If there is a carry, the length need to be calculate form the beginning of the buffer
 				chunkLength := endOffset - (f.offset - f.carry)
 				chunk := f.buffer[f.offset-f.carry : endOffset]
-				if err := fn(f.realOffset, chunkLength, chunk); err != nil {
+				if err := f.emit(fn, f.realOffset, chunkLength, chunk); err != nil {
 					return err
 				}
 				f.realOffset += breakpoint + f.carry
@@ -226,12 +250,108 @@ func (f *FastCDC) split(data io.Reader, fn ChunkFn, eof error) error {
 				break
 			}
 		}
+		f.progress(false)
+	}
+}
+
+// splitLowLatency is the low latency counterpart of split. Instead of waiting for
+// the internal buffer to be completely filled, it emits a chunk as soon as its boundary
+// is decided, that is when a cut-point is found or when max size bytes past the chunk
+// start are available. The scan always stop at the same buffer boundaries than the
+// regular stream mode, which guarantees that both modes produce the same chunks.
+func (f *FastCDC) splitLowLatency(data io.Reader, fn ChunkFn) error {
+	f.firstCall = true
+	for {
+		select {
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		default:
+		}
+
+		// Append data after the bytes that are still waiting for a decision
+		bytesRead, err := data.Read(f.buffer[f.end:])
+		if err != nil && err != io.EOF {
+			return err
+		}
+		f.end += uint(bytesRead)
+		f.bytesConsumed += uint(bytesRead)
+
+		// Emit every chunk we are able to decide with the current data. A breakpoint
+		// found in a partially filled buffer is also a breakpoint of the filled buffer,
+		// since the hash and the chunking judgement only depend on the bytes before it.
+		for f.offset < f.end {
+			select {
+			case <-f.ctx.Done():
+				return f.ctx.Err()
+			default:
+			}
+
+			breakpoint, err := f.breakpoint(f.buffer[f.offset:f.end])
+			if err != nil {
+				return err
+			}
+			if breakpoint == 0 {
+				break
+			}
+			endOffset := breakpoint + f.offset
+			chunkLength := endOffset - (f.offset - f.carry)
+			chunk := f.buffer[f.offset-f.carry : endOffset]
+			if err := f.emit(fn, f.realOffset, chunkLength, chunk); err != nil {
+				return err
+			}
+			f.realOffset += breakpoint + f.carry
+			f.carry = 0
+			f.offset = endOffset
+		}
+
+		// The buffer is exhausted, move the undecided part to the beginning
+		// of the buffer, exactly like the regular stream mode does.
+		if f.end == uint(len(f.buffer)) {
+			previousCarry := f.carry
+			currentCarry := f.end - f.offset
+			copy(f.buffer[previousCarry:previousCarry+currentCarry], f.buffer[f.offset:f.end])
+			f.carry += currentCarry
+			f.offset = f.carry
+			f.end = f.carry
+		}
+		f.progress(false)
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// emit call the chunk function and keep track of the number of emitted chunks.
+func (f *FastCDC) emit(fn ChunkFn, offset, length uint, chunk []byte) error {
+	if err := fn(offset, length, chunk); err != nil {
+		return err
+	}
+	f.chunksEmitted++
+	return nil
+}
+
+// progress call the progress function each time the consumed bytes
+// cross the configured interval. If force is true, the progress function
+// is called regardless of the interval.
+func (f *FastCDC) progress(force bool) {
+	if f.progressFn == nil {
+		return
+	}
+	if !force && f.bytesConsumed < f.nextProgress {
+		return
+	}
+	f.progressFn(f.bytesConsumed, f.chunksEmitted)
+	if f.progressInterval > 0 {
+		// Skip the intervals crossed by a single read.
+		f.nextProgress = (f.bytesConsumed/f.progressInterval + 1) * f.progressInterval
 	}
 }
 
 // Breakpoint return the next chunk breakpoint on the buffer.
-// If there is no breakpoint found, it return 0.
-func (f *FastCDC) breakpoint(buffer []byte) uint {
+// If there is no breakpoint found, it return 0. The cancellation
+// is checked every cancelCheckInterval scanned bytes.
+func (f *FastCDC) breakpoint(buffer []byte) (uint, error) {
 	// if there is bytes carried from the last breakpoint call,
 	// reduce the expected chunk size to match the required size.
 	minSize := min(f.minSize, f.carry, 1)
@@ -244,7 +364,7 @@ func (f *FastCDC) breakpoint(buffer []byte) uint {
 
 	// Sub-minimum chunk cut-point skipping
 	if bufferLength <= minSize {
-		return 0
+		return 0, nil
 	}
 
 	// Set to min size since we do not want to
@@ -265,11 +385,19 @@ func (f *FastCDC) breakpoint(buffer []byte) uint {
 	// Start by using the "harder" chunking judgement to find
 	// chunks that run smaller than the desired normal size.
 	for breakPoint < normalSize {
-		index := uint(buffer[breakPoint])
-		breakPoint += 1
-		hash = (hash >> 1) + table[index]
-		if hash&f.maskS == 0 {
-			return breakPoint
+		end := scanEnd(breakPoint, normalSize)
+		for breakPoint < end {
+			index := uint(buffer[breakPoint])
+			breakPoint += 1
+			hash = (hash >> 1) + table[index]
+			if hash&f.maskS == 0 {
+				return breakPoint, nil
+			}
+		}
+		if breakPoint < normalSize {
+			if err := f.ctx.Err(); err != nil {
+				return 0, err
+			}
 		}
 	}
 
@@ -277,11 +405,19 @@ func (f *FastCDC) breakpoint(buffer []byte) uint {
 	// that run larger than the desired normal size but never bigger than
 	// the maxSize.
 	for breakPoint < bufferLength {
-		index := uint(buffer[breakPoint])
-		breakPoint += 1
-		hash = (hash >> 1) + table[index]
-		if hash&f.maskL == 0 {
-			return breakPoint
+		end := scanEnd(breakPoint, bufferLength)
+		for breakPoint < end {
+			index := uint(buffer[breakPoint])
+			breakPoint += 1
+			hash = (hash >> 1) + table[index]
+			if hash&f.maskL == 0 {
+				return breakPoint, nil
+			}
+		}
+		if breakPoint < bufferLength {
+			if err := f.ctx.Err(); err != nil {
+				return 0, err
+			}
 		}
 	}
 
@@ -291,12 +427,21 @@ func (f *FastCDC) breakpoint(buffer []byte) uint {
 	// the max size allowed and we should emit.
 	// It's also ensure than the carry is never bigger than the max size.
 	if breakPoint == maxSize {
-		return breakPoint
+		return breakPoint, nil
 	}
 
 	// If the breakPoint is < maxSize, the buffer we got is too small to find a chunk
 	// and we should try with a bigger buffer.
-	return 0
+	return 0, nil
+}
+
+// scanEnd return the end of the next scan segment, at most
+// cancelCheckInterval bytes after start.
+func scanEnd(start, end uint) uint {
+	if end-start > cancelCheckInterval {
+		return start + cancelCheckInterval
+	}
+	return end
 }
 
 // min reduce a cut-point with the carry bytes length.
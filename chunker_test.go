@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"reflect"
@@ -1148,3 +1149,240 @@ func TestSmallInput(t *testing.T) {
 		t.Error("chunk mismatch")
 	}
 }
+
+func TestLowLatencyRandomInputFuzz(t *testing.T) {
+	tests := []struct {
+		Name    string
+		MaxSize int
+		Opt     Option
+	}{
+		{"16kChunks", 32768, With16kChunks()},
+		{"32kChunks", 65_536, With32kChunks()},
+		{"64kChunks", 131_072, With64kChunks()},
+	}
+
+	seed := time.Now().UnixNano()
+	rand.Seed(seed)
+	t.Logf("seed, %d", seed)
+
+	type Chunk struct {
+		Offset uint
+		Length uint
+		Sum    [32]byte
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			max := 1 * 1024 * 1024 // max buffer size
+			min := tc.MaxSize      // min buffer size for the chunk size range, it's set to the max chunks size
+			sMax := 256 * 1024     // max stream buffer size
+			sMin := 1              // min stream buffer size
+
+			// repeat test
+			for i := 0; i < 50; i++ {
+				rd := rand.Intn(4*1024*1024-1000+1) + 1000
+				data := make([]byte, rd)
+				rand.Read(data)
+
+				bufSize := uint(rand.Intn(max-min+1) + min)
+				sBufSize := rand.Intn(sMax-sMin+1) + sMin
+
+				split := func(opts ...Option) []Chunk {
+					chunks := make([]Chunk, 0)
+					chunker, err := NewChunker(context.Background(), append(opts, tc.Opt, WithBufferSize(bufSize))...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					fn := func(offset, length uint, chunk []byte) error {
+						chunks = append(chunks, Chunk{offset, length, sha256.Sum256(chunk)})
+						return nil
+					}
+					for i := 0; i < len(data); i += sBufSize {
+						end := i + sBufSize
+						if end > len(data) {
+							end = len(data)
+						}
+						if err := chunker.Split(bytes.NewReader(data[i:end]), fn); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if err := chunker.Finalize(fn); err != nil {
+						t.Fatal(err)
+					}
+					return chunks
+				}
+
+				want := split(WithStreamMode())
+				got := split(WithLowLatencyMode())
+				if !reflect.DeepEqual(want, got) {
+					t.Errorf("chunks mismatch: buffer length = %d, stream buffer length = %d, file size = %d", bufSize, sBufSize, rd)
+				}
+			}
+		})
+	}
+}
+
+func TestSekienChunksLowLatency(t *testing.T) {
+	data, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Chunk struct {
+		Offset uint
+		Length uint
+	}
+
+	want := []Chunk{
+		{0, 32857},
+		{32857, 16408},
+		{49265, 60201},
+	}
+
+	chunker, err := NewChunker(context.Background(), WithLowLatencyMode(), With32kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := make([]Chunk, 0, 3)
+	fn := func(offset, length uint, chunk []byte) error {
+		if uint(len(chunk)) != length {
+			t.Errorf("length mismatch: want = %d, got = %d", length, len(chunk))
+		}
+		chunks = append(chunks, Chunk{offset, length})
+		return nil
+	}
+
+	// Feed the file by small part. The first chunk must be emitted as soon
+	// as its cut-point is available, long before the buffer is filled.
+	for i := 0; i < len(data); i += 64 {
+		end := i + 64
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := chunker.Split(bytes.NewReader(data[i:end]), fn); err != nil {
+			t.Fatal(err)
+		}
+		if i < 32857 && end >= 32857 && len(chunks) != 1 {
+			t.Fatalf("chunks length after the first cut-point: want = 1, got = %d", len(chunks))
+		}
+	}
+
+	// The boundary of the last chunk can't be decided before Finalize
+	if len(chunks) != 2 {
+		t.Fatalf("chunks length before finalize: want = 2, got = %d", len(chunks))
+	}
+
+	if err := chunker.Finalize(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(want, chunks) {
+		t.Errorf("want = %v, got = %v", want, chunks)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	file, err := os.Open("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	type Progress struct {
+		BytesConsumed uint
+		ChunksEmitted uint
+	}
+
+	reports := make([]Progress, 0)
+	chunker, err := NewChunker(context.Background(), WithStreamMode(), With32kChunks(), WithProgress(32768, func(bytesConsumed, chunksEmitted uint) {
+		reports = append(reports, Progress{bytesConsumed, chunksEmitted})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16384)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if err := chunker.Split(bytes.NewReader(buf[:n]), func(offset, length uint, chunk []byte) error {
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := chunker.Finalize(func(offset, length uint, chunk []byte) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Progress{
+		{32768, 0},
+		{65536, 0},
+		{98304, 0},
+		{109466, 3},
+	}
+	if !reflect.DeepEqual(want, reports) {
+		t.Errorf("want = %v, got = %v", want, reports)
+	}
+}
+
+func TestCanceledContextWithinBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	size := 32 * 1024 * 1024
+	data := randomData(155, size)
+	chunker, err := NewChunker(ctx, WithBufferSize(uint(size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := 0
+	err = chunker.Split(bytes.NewReader(data), func(offset, length uint, chunk []byte) error {
+		chunks++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want = %s, got = %s", context.Canceled, err)
+	}
+	if chunks != 1 {
+		t.Errorf("chunks: want = 1, got = %d", chunks)
+	}
+}
+
+func TestCanceledContextWithinChunk(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chunker, err := NewChunker(ctx, WithChunksSize(1_048_576, 4_194_304, 16_777_216))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zeroes never match the chunking judgement, the scan run up to the max size
+	data := make([]byte, 16_777_216)
+	breakpoint, err := chunker.breakpoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breakpoint != uint(len(data)) {
+		t.Fatalf("breakpoint: want = %d, got = %d", len(data), breakpoint)
+	}
+
+	// The cancellation is honored within the scan of a single chunk
+	cancel()
+	if _, err := chunker.breakpoint(data); !errors.Is(err, context.Canceled) {
+		t.Errorf("want = %s, got = %v", context.Canceled, err)
+	}
+	// A scan shorter than the check interval is not interrupted
+	if _, err := chunker.breakpoint(data[:1_048_576+cancelCheckInterval]); err != nil {
+		t.Errorf("want = nil, got = %s", err)
+	}
+}
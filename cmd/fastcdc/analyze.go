@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+// analyzeBlockSize is the size of the blocks read from the files and
+// fed to every chunker, so each file is only read once.
+const analyzeBlockSize = 1 << 20
+
+// fileReport is the deduplication report of one file.
+type fileReport struct {
+	Path   string `json:"path"`
+	Size   uint   `json:"size"`
+	Chunks uint   `json:"chunks"`
+	// SharedBytes is the number of bytes in chunks found more than
+	// once in the tree, in this file or in another one.
+	SharedBytes uint `json:"shared_bytes"`
+	// UniqueBytes is the number of bytes in chunks found only once.
+	UniqueBytes uint `json:"unique_bytes"`
+
+	chunks []chunkRef
+}
+
+type chunkRef struct {
+	digest fastcdc.Digest
+	length uint
+}
+
+// chunkCount is the number of occurrences of a chunk in the tree.
+type chunkCount struct {
+	refs   uint
+	length uint
+}
+
+// configReport is the deduplication report of the tree for one configuration.
+type configReport struct {
+	Name         string        `json:"name"`
+	MinSize      uint          `json:"min_size"`
+	AvgSize      uint          `json:"avg_size"`
+	MaxSize      uint          `json:"max_size"`
+	Files        int           `json:"files"`
+	TotalBytes   uint          `json:"total_bytes"`
+	UniqueBytes  uint          `json:"unique_bytes"`
+	Ratio        float64       `json:"ratio"`
+	Chunks       uint          `json:"chunks"`
+	UniqueChunks uint          `json:"unique_chunks"`
+	AvgChunkSize float64       `json:"avg_chunk_size"`
+	FileReports  []*fileReport `json:"file_reports"`
+
+	config *fastcdc.Config
+	counts map[fastcdc.Digest]chunkCount
+}
+
+func runAnalyze(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("analyze", stderr)
+	list := fs.String("configs", "16k,32k,64k", "comma separated list of presets and min:avg:max chunks sizes")
+	format := fs.String("format", "text", "output format, one of text, json")
+	top := fs.Int("top", 10, "number of files with the most shared content to print")
+	files := fs.Bool("files", false, "print the report of every file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("expected exactly one directory")
+	}
+	if *format != "text" && *format != "json" {
+		return usageErrorf("unknown format %q, want one of text, json", *format)
+	}
+	if *top < 0 {
+		return usageErrorf("-top must not be negative")
+	}
+
+	configs, err := parseConfigs(*list, fastcdc.WithStreamMode())
+	if err != nil {
+		return err
+	}
+	reports, err := analyze(context.Background(), fs.Arg(0), configs)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+	return printAnalysis(stdout, reports, *top, *files)
+}
+
+// analyze chunk every regular file of the tree with every configuration. The
+// configurations must be in stream mode since the files are fed by blocks.
+func analyze(ctx context.Context, root string, configs []namedConfig) ([]*configReport, error) {
+	reports := make([]*configReport, len(configs))
+	chunkers := make([]*fastcdc.FastCDC, len(configs))
+	for i, c := range configs {
+		reports[i] = &configReport{
+			Name:    c.name,
+			MinSize: c.config.MinSize(),
+			AvgSize: c.config.AvgSize(),
+			MaxSize: c.config.MaxSize(),
+			config:  c.config,
+			counts:  make(map[fastcdc.Digest]chunkCount),
+		}
+		chunkers[i] = c.config.NewChunker(ctx)
+		defer chunkers[i].Release()
+	}
+
+	buf := make([]byte, analyzeBlockSize)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			name = path
+		}
+		return analyzeFile(path, name, buf, chunkers, reports)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, report := range reports {
+		report.finalize()
+	}
+	return reports, nil
+}
+
+// analyzeFile read the file once and feed every block to all the chunkers.
+func analyzeFile(path, name string, buf []byte, chunkers []*fastcdc.FastCDC, reports []*configReport) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	files := make([]*fileReport, len(reports))
+	fns := make([]fastcdc.ChunkFn, len(reports))
+	for i := range reports {
+		report := &fileReport{Path: name}
+		counts := reports[i].counts
+		files[i] = report
+		fns[i] = func(offset, length uint, chunk []byte) error {
+			digest := fastcdc.Sum(chunk)
+			count := counts[digest]
+			count.refs++
+			count.length = length
+			counts[digest] = count
+			report.Size += length
+			report.chunks = append(report.chunks, chunkRef{digest: digest, length: length})
+			return nil
+		}
+	}
+
+	split := false
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 || !split {
+			split = true
+			for i, chunker := range chunkers {
+				if err := chunker.Split(bytes.NewReader(buf[:n]), fns[i]); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for i, chunker := range chunkers {
+		if err := chunker.Finalize(fns[i]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		reports[i].FileReports = append(reports[i].FileReports, files[i])
+	}
+	return nil
+}
+
+// finalize compute the totals and the shared bytes of every file
+// once all the chunks of the tree are known.
+func (r *configReport) finalize() {
+	r.Files = len(r.FileReports)
+	for _, file := range r.FileReports {
+		file.Chunks = uint(len(file.chunks))
+		for _, ref := range file.chunks {
+			if r.counts[ref.digest].refs > 1 {
+				file.SharedBytes += ref.length
+			}
+		}
+		file.UniqueBytes = file.Size - file.SharedBytes
+		file.chunks = nil
+		r.TotalBytes += file.Size
+		r.Chunks += file.Chunks
+	}
+	for _, count := range r.counts {
+		r.UniqueBytes += count.length
+	}
+	r.UniqueChunks = uint(len(r.counts))
+	r.Ratio = 1
+	if r.UniqueBytes > 0 {
+		r.Ratio = float64(r.TotalBytes) / float64(r.UniqueBytes)
+	}
+	if r.Chunks > 0 {
+		r.AvgChunkSize = float64(r.TotalBytes) / float64(r.Chunks)
+	}
+	r.counts = nil
+}
+
+// topShared return at most n files with some shared content, by decreasing shared bytes.
+func (r *configReport) topShared(n int) []*fileReport {
+	files := make([]*fileReport, 0, len(r.FileReports))
+	for _, file := range r.FileReports {
+		if file.SharedBytes > 0 {
+			files = append(files, file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].SharedBytes > files[j].SharedBytes
+	})
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// printAnalysis print the aggregate table of every configuration, followed by the files
+// with the most shared content and, if all is true, by the report of every file.
+func printAnalysis(w io.Writer, reports []*configReport, top int, all bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "config\tmin\tavg\tmax\tfiles\ttotal bytes\tunique bytes\tratio\tchunks\tunique chunks\tavg chunk\t")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.3f\t%d\t%d\t%.0f\t\n",
+			r.Name, r.MinSize, r.AvgSize, r.MaxSize, r.Files, r.TotalBytes, r.UniqueBytes, r.Ratio, r.Chunks, r.UniqueChunks, r.AvgChunkSize)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		files := r.FileReports
+		title := "files"
+		if !all {
+			files = r.topShared(top)
+			title = "top files by shared content"
+		}
+		if len(files) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s (%s):\n", title, r.Name)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "shared bytes\tunique bytes\tsize\tchunks\tshared\tpath")
+		for _, file := range files {
+			shared := 0.0
+			if file.Size > 0 {
+				shared = 100 * float64(file.SharedBytes) / float64(file.Size)
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%.1f%%\t%s\n", file.SharedBytes, file.UniqueBytes, file.Size, file.Chunks, shared, file.Path)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+func TestAnalyze(t *testing.T) {
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bigger than a block to check the chunks are the same as a regular split
+	large := make([]byte, 3*analyzeBlockSize+12345)
+	rand.New(rand.NewSource(42)).Read(large)
+
+	root := t.TempDir()
+	files := map[string][]byte{
+		"sekien.jpg":            data,
+		"copy/sekien.jpg":       data,
+		"copy/empty":            nil,
+		"large.bin":             large,
+		"copy/large-edited.bin": append([]byte("prefix"), large...),
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configs, err := parseConfigs("16k,4096:8192:16384", fastcdc.WithStreamMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := analyze(context.Background(), root, configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("reports: want = 2, got = %d", len(reports))
+	}
+
+	for i, report := range reports {
+		regular, err := fastcdc.NewConfig(fastcdc.WithChunksSize(report.MinSize, report.AvgSize, report.MaxSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		byPath := make(map[string]*fileReport)
+		for _, file := range report.FileReports {
+			byPath[filepath.ToSlash(file.Path)] = file
+		}
+		if report.Files != len(files) || len(byPath) != len(files) {
+			t.Fatalf("reports[%d] files: want = %d, got = %d", i, len(files), report.Files)
+		}
+
+		var total uint
+		for name, content := range files {
+			file := byPath[name]
+			total += uint(len(content))
+			if file.Size != uint(len(content)) {
+				t.Errorf("%s size: want = %d, got = %d", name, len(content), file.Size)
+			}
+			var chunks uint
+			if len(content) > 0 {
+				chunker := regular.NewChunker(context.Background())
+				fn := func(offset, length uint, chunk []byte) error {
+					chunks++
+					return nil
+				}
+				if err := chunker.Split(bytes.NewReader(content), fn); err != nil {
+					t.Fatal(err)
+				}
+				if err := chunker.Finalize(fn); err != nil {
+					t.Fatal(err)
+				}
+				chunker.Release()
+			}
+			if file.Chunks != chunks {
+				t.Errorf("%s chunks: want = %d, got = %d", name, chunks, file.Chunks)
+			}
+		}
+
+		if report.TotalBytes != total {
+			t.Errorf("total bytes: want = %d, got = %d", total, report.TotalBytes)
+		}
+		for _, name := range []string{"sekien.jpg", "copy/sekien.jpg"} {
+			if file := byPath[name]; file.SharedBytes != file.Size || file.UniqueBytes != 0 {
+				t.Errorf("%s: want all bytes shared, got = %+v", name, file)
+			}
+		}
+		// Only the chunks around the prefix differ
+		edited := byPath["copy/large-edited.bin"]
+		if edited.UniqueBytes == 0 || edited.UniqueBytes > 2*report.MaxSize {
+			t.Errorf("edited unique bytes: want <= %d, got = %d", 2*report.MaxSize, edited.UniqueBytes)
+		}
+		wantUnique := uint(len(data)) + uint(len(large)) + edited.UniqueBytes + byPath["large.bin"].UniqueBytes
+		if report.UniqueBytes > wantUnique || report.UniqueBytes < uint(len(data)+len(large)) {
+			t.Errorf("unique bytes: want about %d, got = %d", wantUnique, report.UniqueBytes)
+		}
+		if report.Ratio < 1.9 {
+			t.Errorf("ratio: want >= 1.9, got = %f", report.Ratio)
+		}
+
+		top := report.topShared(2)
+		if len(top) != 2 || top[0].SharedBytes < top[1].SharedBytes || !strings.HasSuffix(top[0].Path, ".bin") {
+			t.Errorf("unexpected top files: %+v", top)
+		}
+	}
+}
+
+func TestAnalyzeCommand(t *testing.T) {
+	root := t.TempDir()
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("json", func(t *testing.T) {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if code := run([]string{"analyze", "-format", "json", root}, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		var reports []configReport
+		if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 3 {
+			t.Fatalf("reports: want = 3, got = %d", len(reports))
+		}
+		for _, report := range reports {
+			if report.Ratio != 2 {
+				t.Errorf("%s ratio: want = 2, got = %f", report.Name, report.Ratio)
+			}
+			if len(report.FileReports) != 2 {
+				t.Errorf("%s files: want = 2, got = %d", report.Name, len(report.FileReports))
+			}
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if code := run([]string{"analyze", "-configs", "32k", "-files", root}, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		out := stdout.String()
+		for _, want := range []string{"2.000", "files (32k):", "a.jpg", "b.jpg"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("output must contain %q: %s", want, out)
+			}
+		}
+	})
+
+	tests := map[string]struct {
+		Args []string
+		Code int
+	}{
+		"no directory":    {[]string{"analyze"}, 2},
+		"unknown format":  {[]string{"analyze", "-format", "csv", root}, 2},
+		"invalid config":  {[]string{"analyze", "-configs", "16k,1:2", root}, 2},
+		"invalid sizes":   {[]string{"analyze", "-configs", "1:2:3", root}, 2},
+		"empty configs":   {[]string{"analyze", "-configs", ",", root}, 2},
+		"missing dir":     {[]string{"analyze", filepath.Join(root, "missing")}, 1},
+		"negative top":    {[]string{"analyze", "-top", "-1", root}, 2},
+		"too many args":   {[]string{"analyze", root, root}, 2},
+		"unknown command": {[]string{"analyse", root}, 2},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code := run(tc.Args, nil, ioutil.Discard, ioutil.Discard); code != tc.Code {
+				t.Errorf("exit code: want = %d, got = %d", tc.Code, code)
+			}
+		})
+	}
+}
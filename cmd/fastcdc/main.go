@@ -0,0 +1,240 @@
+// Command fastcdc expose the fastcdc chunker on the command line.
+//
+// Usage:
+//
+//	fastcdc <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	analyze  report the deduplication of a directory tree for several chunks sizes
+//	split    print the chunks of files or of the standard input
+//	tune     recommend chunks sizes for sample files
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+// errFlags is returned when the flags can't be parsed, the
+// flag set already reported the error.
+var errFlags = errors.New("invalid flags")
+
+// usageError is returned by the commands when the flags or the arguments are invalid.
+type usageError struct {
+	err error
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, a...)}
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+type command struct {
+	usage string
+	short string
+	run   func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"analyze": {
+		usage: "analyze [flags] dir",
+		short: "report the deduplication of a directory tree for several chunks sizes",
+		run:   runAnalyze,
+	},
+	"split": {
+		usage: "split [flags] [file...]",
+		short: "print the chunks of files or of the standard input",
+		run:   runSplit,
+	},
+	"tune": {
+		usage: "tune [flags] file...",
+		short: "recommend chunks sizes for sample files",
+		run:   runTune,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run execute the command named by the first argument and return the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "fastcdc: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	if err := cmd.run(args[1:], stdin, stdout, stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		if errors.Is(err, errFlags) {
+			return 2
+		}
+		var uerr *usageError
+		if errors.As(err, &uerr) {
+			fmt.Fprintf(stderr, "fastcdc %s: %s\nusage: fastcdc %s\n", args[0], err, cmd.usage)
+			return 2
+		}
+		fmt.Fprintf(stderr, "fastcdc %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: fastcdc <command> [flags] [arguments]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintf(w, "\nRun 'fastcdc <command> -h' for the command flags.\n")
+}
+
+// newFlagSet return a flag set reporting the errors to the caller instead of exiting.
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("fastcdc "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseFlags parse the arguments with the flag set.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errFlags
+	}
+	return nil
+}
+
+// presets map the name of the presets to the library options.
+var presets = map[string]func() fastcdc.Option{
+	"16k": fastcdc.With16kChunks,
+	"32k": fastcdc.With32kChunks,
+	"64k": fastcdc.With64kChunks,
+}
+
+func presetNames() string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// chunkFlags are the flags configuring the chunker, shared by the commands.
+type chunkFlags struct {
+	preset string
+	min    uint
+	avg    uint
+	max    uint
+	buffer uint
+}
+
+func (f *chunkFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.preset, "preset", "64k", "chunks size preset, one of "+presetNames())
+	fs.UintVar(&f.min, "min", 0, "minimum chunk size, overrides the preset along with -avg and -max")
+	fs.UintVar(&f.avg, "avg", 0, "average chunk size")
+	fs.UintVar(&f.max, "max", 0, "maximum chunk size")
+	fs.UintVar(&f.buffer, "buffer", 0, "internal buffer size, default to 2 * max size")
+}
+
+// config validate the flags and return the chunker configuration.
+func (f *chunkFlags) config() (*fastcdc.Config, error) {
+	opts := make([]fastcdc.Option, 0, 2)
+	custom := f.min != 0 || f.avg != 0 || f.max != 0
+	switch {
+	case custom && (f.min == 0 || f.avg == 0 || f.max == 0):
+		return nil, usageErrorf("-min, -avg and -max must be set together")
+	case custom:
+		opts = append(opts, fastcdc.WithChunksSize(f.min, f.avg, f.max))
+	default:
+		preset, ok := presets[f.preset]
+		if !ok {
+			return nil, usageErrorf("unknown preset %q, want one of %s", f.preset, presetNames())
+		}
+		opts = append(opts, preset())
+	}
+	if f.buffer != 0 {
+		opts = append(opts, fastcdc.WithBufferSize(f.buffer))
+	}
+	config, err := fastcdc.NewConfig(opts...)
+	if err != nil {
+		return nil, &usageError{err: err}
+	}
+	return config, nil
+}
+
+// namedConfig is a chunker configuration along with its name on the command line.
+type namedConfig struct {
+	name   string
+	config *fastcdc.Config
+}
+
+// parseConfigs parse a comma separated list of preset names and of
+// min:avg:max custom chunks sizes. The options are applied to every
+// configuration.
+func parseConfigs(list string, opts ...fastcdc.Option) ([]namedConfig, error) {
+	var configs []namedConfig
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var opt fastcdc.Option
+		if preset, ok := presets[name]; ok {
+			opt = preset()
+		} else {
+			sizes := strings.Split(name, ":")
+			if len(sizes) != 3 {
+				return nil, usageErrorf("invalid configuration %q, want one of %s or min:avg:max", name, presetNames())
+			}
+			var values [3]uint
+			for i, size := range sizes {
+				n, err := strconv.ParseUint(size, 10, 0)
+				if err != nil {
+					return nil, usageErrorf("invalid configuration %q: %s", name, err)
+				}
+				values[i] = uint(n)
+			}
+			opt = fastcdc.WithChunksSize(values[0], values[1], values[2])
+		}
+		config, err := fastcdc.NewConfig(append([]fastcdc.Option{opt}, opts...)...)
+		if err != nil {
+			return nil, &usageError{err: fmt.Errorf("%s: %w", name, err)}
+		}
+		configs = append(configs, namedConfig{name: name, config: config})
+	}
+	if len(configs) == 0 {
+		return nil, usageErrorf("no configuration")
+	}
+	return configs, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+// stdinName is the file argument standing for the standard input.
+const stdinName = "-"
+
+// chunkRecord is one line of the split output.
+type chunkRecord struct {
+	File   string `json:"file"`
+	Offset uint   `json:"offset"`
+	Length uint   `json:"length"`
+	Digest string `json:"digest"`
+}
+
+// chunkWriter write the chunk records in one of the output formats.
+type chunkWriter interface {
+	Write(record chunkRecord) error
+	Flush() error
+}
+
+func runSplit(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("split", stderr)
+	var flags chunkFlags
+	flags.register(fs)
+	format := fs.String("format", "text", "output format, one of text, jsonl, csv")
+	out := fs.String("out", "", "write each chunk to this directory, in a file named after its digest")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	config, err := flags.config()
+	if err != nil {
+		return err
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{stdinName}
+	}
+
+	bw := bufio.NewWriter(stdout)
+	var w chunkWriter
+	switch *format {
+	case "text":
+		w = &textChunkWriter{w: bw, name: len(files) > 1}
+	case "jsonl":
+		w = &jsonChunkWriter{w: bw, enc: json.NewEncoder(bw)}
+	case "csv":
+		w = &csvChunkWriter{w: csv.NewWriter(bw), bw: bw}
+	default:
+		return usageErrorf("unknown format %q, want one of text, jsonl, csv", *format)
+	}
+
+	if *out != "" {
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range files {
+		if err := splitFile(config, name, stdin, *out, w); err != nil {
+			w.Flush()
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// splitFile chunk the named file, or stdin if the name is "-", and write a
+// record per chunk. If out is not empty, the chunks are written to out.
+func splitFile(config *fastcdc.Config, name string, stdin io.Reader, out string, w chunkWriter) error {
+	r := stdin
+	if name != stdinName {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	chunker := config.NewChunker(context.Background())
+	defer chunker.Release()
+
+	fn := func(offset, length uint, chunk []byte) error {
+		digest := fastcdc.Sum(chunk)
+		if out != "" {
+			if err := writeChunk(out, digest, chunk); err != nil {
+				return err
+			}
+		}
+		return w.Write(chunkRecord{File: name, Offset: offset, Length: length, Digest: digest.String()})
+	}
+	if err := chunker.Split(r, fn); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := chunker.Finalize(fn); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// writeChunk write the chunk in the directory, unless a chunk with
+// the same digest is already there.
+func writeChunk(dir string, digest fastcdc.Digest, chunk []byte) error {
+	path := filepath.Join(dir, digest.String())
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(chunk); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Sync before renaming, so a crash never leaves a partial chunk under its digest
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type textChunkWriter struct {
+	w    *bufio.Writer
+	name bool
+}
+
+func (t *textChunkWriter) Write(record chunkRecord) error {
+	var err error
+	if t.name {
+		_, err = fmt.Fprintf(t.w, "%s\t%d\t%d\t%s\n", record.File, record.Offset, record.Length, record.Digest)
+	} else {
+		_, err = fmt.Fprintf(t.w, "%d\t%d\t%s\n", record.Offset, record.Length, record.Digest)
+	}
+	return err
+}
+
+func (t *textChunkWriter) Flush() error {
+	return t.w.Flush()
+}
+
+type jsonChunkWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonChunkWriter) Write(record chunkRecord) error {
+	return j.enc.Encode(record)
+}
+
+func (j *jsonChunkWriter) Flush() error {
+	return j.w.Flush()
+}
+
+type csvChunkWriter struct {
+	w      *csv.Writer
+	bw     *bufio.Writer
+	header bool
+}
+
+func (c *csvChunkWriter) Write(record chunkRecord) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write([]string{"file", "offset", "length", "digest"}); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		record.File,
+		strconv.FormatUint(uint64(record.Offset), 10),
+		strconv.FormatUint(uint64(record.Length), 10),
+		record.Digest,
+	})
+}
+
+func (c *csvChunkWriter) Flush() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
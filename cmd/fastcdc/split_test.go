@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+const fixture = "../../fixtures/SekienAkashita.jpg"
+
+// sekienChunks are the offset and length of the fixture chunks with the 16k preset.
+var sekienChunks = [][2]uint{
+	{0, 22366},
+	{22366, 8282},
+	{30648, 16303},
+	{46951, 18696},
+	{65647, 32768},
+	{98415, 11051},
+}
+
+func TestSplit(t *testing.T) {
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]chunkRecord, len(sekienChunks))
+	for i, c := range sekienChunks {
+		want[i] = chunkRecord{File: fixture, Offset: c[0], Length: c[1], Digest: fastcdc.Sum(data[c[0] : c[0]+c[1]]).String()}
+	}
+
+	parseText := func(t *testing.T, out string) []chunkRecord {
+		var records []chunkRecord
+		sc := bufio.NewScanner(strings.NewReader(out))
+		for sc.Scan() {
+			var r chunkRecord
+			if _, err := fmt.Sscanf(sc.Text(), "%d\t%d\t%s", &r.Offset, &r.Length, &r.Digest); err != nil {
+				t.Fatal(err)
+			}
+			r.File = fixture
+			records = append(records, r)
+		}
+		return records
+	}
+	parseJSON := func(t *testing.T, out string) []chunkRecord {
+		var records []chunkRecord
+		dec := json.NewDecoder(strings.NewReader(out))
+		for dec.More() {
+			var r chunkRecord
+			if err := dec.Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+		return records
+	}
+	parseCSV := func(t *testing.T, out string) []chunkRecord {
+		rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 || strings.Join(rows[0], ",") != "file,offset,length,digest" {
+			t.Fatalf("invalid csv header: %v", rows)
+		}
+		var records []chunkRecord
+		for _, row := range rows[1:] {
+			var r chunkRecord
+			r.File, r.Digest = row[0], row[3]
+			fmt.Sscan(row[1], &r.Offset)
+			fmt.Sscan(row[2], &r.Length)
+			records = append(records, r)
+		}
+		return records
+	}
+
+	tests := map[string]struct {
+		Args  []string
+		Stdin bool
+		Parse func(t *testing.T, out string) []chunkRecord
+	}{
+		"text":       {[]string{"-preset", "16k", fixture}, false, parseText},
+		"jsonl":      {[]string{"-preset", "16k", "-format", "jsonl", fixture}, false, parseJSON},
+		"csv":        {[]string{"-preset", "16k", "-format", "csv", fixture}, false, parseCSV},
+		"custom":     {[]string{"-min", "8192", "-avg", "16834", "-max", "32768", "-format", "jsonl", fixture}, false, parseJSON},
+		"stdin":      {[]string{"-preset", "16k", "-format", "text"}, true, parseText},
+		"buffer":     {[]string{"-preset", "16k", "-buffer", "40000", "-format", "csv", fixture}, false, parseCSV},
+		"stdin dash": {[]string{"-preset", "16k", "-"}, true, parseText},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+			var stdin *bytes.Reader
+			if tc.Stdin {
+				stdin = bytes.NewReader(data)
+			}
+			if code := run(append([]string{"split"}, tc.Args...), stdin, stdout, stderr); code != 0 {
+				t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+			}
+			got := tc.Parse(t, stdout.String())
+			if len(got) != len(want) {
+				t.Fatalf("records length: want = %d, got = %d", len(want), len(got))
+			}
+			for i := range got {
+				if got[i] != want[i] && !(tc.Stdin && got[i].Offset == want[i].Offset && got[i].Digest == want[i].Digest) {
+					t.Errorf("records[%d]: want = %+v, got = %+v", i, want[i], got[i])
+				}
+			}
+		})
+	}
+
+	t.Run("many files", func(t *testing.T) {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if code := run([]string{"split", "-preset", "16k", fixture, fixture}, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if len(lines) != 2*len(want) {
+			t.Fatalf("lines: want = %d, got = %d", 2*len(want), len(lines))
+		}
+		if !strings.HasPrefix(lines[0], fixture+"\t0\t") {
+			t.Errorf("want file name prefix, got = %q", lines[0])
+		}
+	})
+
+	t.Run("out", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "chunks")
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if code := run([]string{"split", "-preset", "16k", "-out", dir, fixture}, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(want) {
+			t.Fatalf("files: want = %d, got = %d", len(want), len(files))
+		}
+		for _, r := range want {
+			chunk, err := ioutil.ReadFile(filepath.Join(dir, r.Digest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fastcdc.Sum(chunk).String() != r.Digest {
+				t.Errorf("chunk %s: digest mismatch", r.Digest)
+			}
+		}
+	})
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := map[string]struct {
+		Args []string
+		Code int
+	}{
+		"unknown command": {[]string{"join"}, 2},
+		"no command":      {nil, 2},
+		"unknown preset":  {[]string{"split", "-preset", "8k", fixture}, 2},
+		"unknown format":  {[]string{"split", "-format", "xml", fixture}, 2},
+		"partial sizes":   {[]string{"split", "-min", "8192", fixture}, 2},
+		"invalid sizes":   {[]string{"split", "-min", "1", "-avg", "2", "-max", "3", fixture}, 2},
+		"unknown flag":    {[]string{"split", "-foo", fixture}, 2},
+		"missing file":    {[]string{"split", filepath.Join(os.TempDir(), "fastcdc-missing")}, 1},
+		"help":            {[]string{"split", "-h"}, 0},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code := run(tc.Args, nil, ioutil.Discard, ioutil.Discard); code != tc.Code {
+				t.Errorf("exit code: want = %d, got = %d", tc.Code, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+// maxEditLength is the maximum number of bytes inserted, deleted
+// or overwritten by a synthetic edit.
+const maxEditLength = 64
+
+func runTune(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("tune", stderr)
+	list := fs.String("configs", "", "comma separated list of presets and min:avg:max chunks sizes, default to the library candidates")
+	series := fs.Bool("series", false, "the files are successive versions of the same data instead of independent samples")
+	variants := fs.Int("variants", 4, "number of synthetic edited versions of each sample")
+	edits := fs.Int("edits", 8, "number of random edits in each synthetic version")
+	seed := fs.Int64("seed", 1, "seed of the synthetic edits")
+	weightsFlag := fs.String("weights", "", "comma separated criterion=weight list, the criteria are dedup, chunks, variance and throughput")
+	format := fs.String("format", "text", "output format, one of text, json")
+	top := fs.Int("top", 10, "number of configurations to print, 0 for all")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("expected at least one file")
+	}
+	if *format != "text" && *format != "json" {
+		return usageErrorf("unknown format %q, want one of text, json", *format)
+	}
+	if *variants < 0 || *edits < 0 || *top < 0 {
+		return usageErrorf("-variants, -edits and -top must not be negative")
+	}
+	weights, err := parseWeights(*weightsFlag)
+	if err != nil {
+		return err
+	}
+	var candidates []*fastcdc.Config
+	if *list != "" {
+		configs, err := parseConfigs(*list)
+		if err != nil {
+			return err
+		}
+		for _, c := range configs {
+			candidates = append(candidates, c.config)
+		}
+	}
+
+	files := make([][]byte, fs.NArg())
+	for i, name := range fs.Args() {
+		if files[i], err = ioutil.ReadFile(name); err != nil {
+			return err
+		}
+	}
+	var samples []fastcdc.TuneSample
+	if *series {
+		samples = []fastcdc.TuneSample{{Data: files[0], Edited: files[1:]}}
+	} else {
+		for _, data := range files {
+			samples = append(samples, fastcdc.TuneSample{Data: data})
+		}
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	for i := range samples {
+		for j := 0; j < *variants; j++ {
+			samples[i].Edited = append(samples[i].Edited, editSample(rng, samples[i].Data, *edits))
+		}
+	}
+
+	results, err := fastcdc.Tune(context.Background(), samples, candidates, weights)
+	if err != nil {
+		return err
+	}
+	if *top > 0 && len(results) > *top {
+		results = results[:*top]
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "min\tavg\tmax\tratio\tunique bytes\tchunks\tmean size\tsize cv\tMB/s\tscore\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.3f\t%d\t%d\t%.0f\t%.3f\t%.0f\t%.3f\t\n",
+			r.MinSize, r.AvgSize, r.MaxSize, r.Ratio, r.UniqueBytes, r.Chunks, r.MeanSize, r.SizeCV, r.Throughput/1e6, r.Score)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	best := results[0]
+	_, err = fmt.Fprintf(stdout, "\nrecommended: -min %d -avg %d -max %d\n", best.MinSize, best.AvgSize, best.MaxSize)
+	return err
+}
+
+// parseWeights parse a comma separated criterion=weight list. The
+// criteria not in the list have a zero weight.
+func parseWeights(list string) (fastcdc.TuneWeights, error) {
+	var weights fastcdc.TuneWeights
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.IndexByte(item, '=')
+		if i < 0 {
+			return weights, usageErrorf("invalid weight %q, want criterion=weight", item)
+		}
+		value, err := strconv.ParseFloat(item[i+1:], 64)
+		if err != nil || value < 0 {
+			return weights, usageErrorf("invalid weight %q, want a non negative number", item)
+		}
+		switch item[:i] {
+		case "dedup":
+			weights.Dedup = value
+		case "chunks":
+			weights.Chunks = value
+		case "variance":
+			weights.Variance = value
+		case "throughput":
+			weights.Throughput = value
+		default:
+			return weights, usageErrorf("unknown criterion %q, want one of dedup, chunks, variance, throughput", item[:i])
+		}
+	}
+	return weights, nil
+}
+
+// editSample return a copy of data with n random edits, each inserting,
+// deleting or overwriting up to maxEditLength bytes.
+func editSample(rng *rand.Rand, data []byte, n int) []byte {
+	edited := append([]byte(nil), data...)
+	for i := 0; i < n; i++ {
+		at := rng.Intn(len(edited) + 1)
+		length := 1 + rng.Intn(maxEditLength)
+		switch rng.Intn(3) {
+		case 0:
+			insert := make([]byte, length)
+			rng.Read(insert)
+			edited = append(edited[:at], append(insert, edited[at:]...)...)
+		case 1:
+			if at+length > len(edited) {
+				length = len(edited) - at
+			}
+			edited = append(edited[:at], edited[at+length:]...)
+		default:
+			if at+length > len(edited) {
+				length = len(edited) - at
+			}
+			rng.Read(edited[at : at+length])
+		}
+	}
+	return edited
+}
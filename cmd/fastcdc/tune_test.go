@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tigerwill90/fastcdc"
+)
+
+func TestTune(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		args := []string{"tune", "-configs", "16k,1024:2048:4096", "-weights", "dedup=1", "-format", "json", fixture}
+		if code := run(args, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		var results []fastcdc.TuneResult
+		if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("results: want = 2, got = %d", len(results))
+		}
+		// The small chunks deduplicate the synthetic edits best
+		if results[0].AvgSize != 2048 {
+			t.Errorf("recommended avg size: want = 2048, got = %d", results[0].AvgSize)
+		}
+		if results[0].Ratio <= 1 {
+			t.Errorf("ratio: want > 1, got = %f", results[0].Ratio)
+		}
+	})
+
+	t.Run("series", func(t *testing.T) {
+		data, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := filepath.Join(t.TempDir(), "next.jpg")
+		if err := ioutil.WriteFile(next, append([]byte("prefix"), data...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		args := []string{"tune", "-series", "-variants", "0", "-configs", "16k", "-format", "json", fixture, next}
+		if code := run(args, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		var results []fastcdc.TuneResult
+		if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].TotalBytes != 2*uint(len(data))+6 || results[0].Ratio <= 1 {
+			t.Errorf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if code := run([]string{"tune", "-top", "3", fixture}, nil, stdout, stderr); code != 0 {
+			t.Fatalf("exit code: want = 0, got = %d: %s", code, stderr)
+		}
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		// header, 3 results, blank line and recommendation
+		if len(lines) != 6 || !strings.HasPrefix(lines[5], "recommended: -min ") {
+			t.Errorf("unexpected output: %s", stdout)
+		}
+	})
+
+	tests := map[string]struct {
+		Args []string
+		Code int
+	}{
+		"no file":          {[]string{"tune"}, 2},
+		"missing file":     {[]string{"tune", filepath.Join(t.TempDir(), "missing")}, 1},
+		"unknown format":   {[]string{"tune", "-format", "csv", fixture}, 2},
+		"invalid config":   {[]string{"tune", "-configs", "8k", fixture}, 2},
+		"negative edits":   {[]string{"tune", "-edits", "-1", fixture}, 2},
+		"unknown weight":   {[]string{"tune", "-weights", "speed=1", fixture}, 2},
+		"invalid weight":   {[]string{"tune", "-weights", "dedup=-1", fixture}, 2},
+		"malformed weight": {[]string{"tune", "-weights", "dedup", fixture}, 2},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code := run(tc.Args, nil, ioutil.Discard, ioutil.Discard); code != tc.Code {
+				t.Errorf("exit code: want = %d, got = %d", tc.Code, code)
+			}
+		})
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	got, err := parseWeights("dedup=2, chunks=0.5,throughput=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fastcdc.TuneWeights{Dedup: 2, Chunks: 0.5, Throughput: 1}
+	if got != want {
+		t.Errorf("want = %+v, got = %+v", want, got)
+	}
+	if got, err := parseWeights(""); err != nil || got != (fastcdc.TuneWeights{}) {
+		t.Errorf("want zero weights, got = %+v, %v", got, err)
+	}
+}
+
+func TestEditSample(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	a := editSample(rand.New(rand.NewSource(2)), data, 8)
+	b := editSample(rand.New(rand.NewSource(2)), data, 8)
+	if !bytes.Equal(a, b) {
+		t.Error("edits must be deterministic for a seed")
+	}
+	if bytes.Equal(a, data) {
+		t.Error("sample not edited")
+	}
+	if len(a) < len(data)-8*maxEditLength || len(a) > len(data)+8*maxEditLength {
+		t.Errorf("edited length: want about %d, got = %d", len(data), len(a))
+	}
+	if got := editSample(rand.New(rand.NewSource(3)), data, 0); !bytes.Equal(got, data) {
+		t.Error("sample edited without edits")
+	}
+	// Empty samples can still be edited
+	editSample(rand.New(rand.NewSource(4)), nil, 8)
+}
@@ -0,0 +1,248 @@
+package fastcdc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+var (
+	ErrUnknownCodec   = errors.New("unknown codec")
+	ErrDuplicateCodec = errors.New("duplicate codec id")
+	ErrReservedCodec  = errors.New("reserved codec id")
+)
+
+// Codec ids of the codecs shipped with the package. Ids below 16 are reserved,
+// custom codecs must use an id in the range [16, 255].
+const (
+	CodecRaw   uint8 = 0
+	CodecFlate uint8 = 1
+	CodecZlib  uint8 = 2
+	// reservedCodecs is the number of ids reserved for the package codecs.
+	reservedCodecs uint8 = 16
+)
+
+// Codec compress and decompress chunks. Implementations must be safe for concurrent use.
+type Codec interface {
+	// ID return the id recorded with every chunk compressed by the codec.
+	ID() uint8
+	// Compress return the compressed chunk.
+	Compress(chunk []byte) ([]byte, error)
+	// Decompress return the chunk from its compressed form.
+	Decompress(data []byte) ([]byte, error)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ID() uint8 { return CodecRaw }
+
+func (rawCodec) Compress(chunk []byte) ([]byte, error) { return chunk, nil }
+
+func (rawCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
+
+// FlateCodec compress chunks with the DEFLATE format (RFC 1951).
+type FlateCodec struct {
+	writers sync.Pool
+}
+
+// NewFlateCodec return a flate codec using the given compression level,
+// from flate.HuffmanOnly to flate.BestCompression.
+func NewFlateCodec(level int) (*FlateCodec, error) {
+	if _, err := flate.NewWriter(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &FlateCodec{
+		writers: sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(ioutil.Discard, level)
+			return w
+		}},
+	}, nil
+}
+
+// ID return CodecFlate.
+func (c *FlateCodec) ID() uint8 {
+	return CodecFlate
+}
+
+// Compress return the deflated chunk.
+func (c *FlateCodec) Compress(chunk []byte) ([]byte, error) {
+	w := c.writers.Get().(*flate.Writer)
+	defer c.writers.Put(w)
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	return compressWith(&buf, w, chunk)
+}
+
+// Decompress return the inflated chunk.
+func (c *FlateCodec) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return decompressWith(r, MaximumMax)
+}
+
+// ZlibCodec compress chunks with the zlib format (RFC 1950), which add
+// an adler-32 checksum to the DEFLATE stream.
+type ZlibCodec struct {
+	writers sync.Pool
+}
+
+// NewZlibCodec return a zlib codec using the given compression level,
+// from zlib.HuffmanOnly to zlib.BestCompression.
+func NewZlibCodec(level int) (*ZlibCodec, error) {
+	if _, err := zlib.NewWriterLevel(ioutil.Discard, level); err != nil {
+		return nil, err
+	}
+	return &ZlibCodec{
+		writers: sync.Pool{New: func() interface{} {
+			w, _ := zlib.NewWriterLevel(ioutil.Discard, level)
+			return w
+		}},
+	}, nil
+}
+
+// ID return CodecZlib.
+func (c *ZlibCodec) ID() uint8 {
+	return CodecZlib
+}
+
+// Compress return the compressed chunk.
+func (c *ZlibCodec) Compress(chunk []byte) ([]byte, error) {
+	w := c.writers.Get().(*zlib.Writer)
+	defer c.writers.Put(w)
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	return compressWith(&buf, w, chunk)
+}
+
+// Decompress return the decompressed chunk.
+func (c *ZlibCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return decompressWith(r, MaximumMax)
+}
+
+func compressWith(buf *bytes.Buffer, w io.WriteCloser, chunk []byte) ([]byte, error) {
+	if _, err := w.Write(chunk); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressWith read the decompressed chunk from r, which must not be larger than maxSize.
+func decompressWith(r io.Reader, maxSize uint) ([]byte, error) {
+	chunk, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint(len(chunk)) > maxSize {
+		return nil, fmt.Errorf("decompressed chunk exceed %d bytes: %w", maxSize, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
+
+// CompressedStore compress the chunks on the way into a chunk store and decompress them
+// on the way out. Each stored chunk is prefixed by the id of its codec, chunks that do not
+// shrink are stored raw. Chunks are still keyed by the digest of the uncompressed chunk so
+// deduplication is unaffected.
+type CompressedStore struct {
+	ChunkStore
+	codec  Codec
+	codecs map[uint8]Codec
+}
+
+// NewCompressedStore return a store compressing new chunks with codec, or storing them raw
+// if codec is nil. The flate and zlib codecs are always available to decompress chunks,
+// other codecs used by the stored chunks must be given with decoders. A custom codec using
+// a reserved id is rejected with ErrReservedCodec, it would shadow a package codec.
+func NewCompressedStore(store ChunkStore, codec Codec, decoders ...Codec) (*CompressedStore, error) {
+	flateCodec, _ := NewFlateCodec(flate.DefaultCompression)
+	zlibCodec, _ := NewZlibCodec(zlib.DefaultCompression)
+	codecs := map[uint8]Codec{
+		CodecRaw:   rawCodec{},
+		CodecFlate: flateCodec,
+		CodecZlib:  zlibCodec,
+	}
+
+	custom := make(map[uint8]struct{})
+	for _, c := range append([]Codec{codec}, decoders...) {
+		if c == nil {
+			continue
+		}
+		id := c.ID()
+		if id < reservedCodecs && !builtinCodec(c) {
+			return nil, fmt.Errorf("%d: %w", id, ErrReservedCodec)
+		}
+		if _, ok := custom[id]; ok {
+			return nil, fmt.Errorf("%d: %w", id, ErrDuplicateCodec)
+		}
+		custom[id] = struct{}{}
+		codecs[id] = c
+	}
+	if codec == nil {
+		codec = rawCodec{}
+	}
+
+	return &CompressedStore{
+		ChunkStore: store,
+		codec:      codec,
+		codecs:     codecs,
+	}, nil
+}
+
+// builtinCodec report whether the codec is one of the package
+// codecs, the only ones allowed to use a reserved id.
+func builtinCodec(c Codec) bool {
+	switch c.(type) {
+	case *FlateCodec, *ZlibCodec:
+		return true
+	}
+	return false
+}
+
+// Put compress and store the chunk.
+func (s *CompressedStore) Put(digest Digest, chunk []byte) error {
+	id := s.codec.ID()
+	data, err := s.codec.Compress(chunk)
+	if err != nil {
+		return err
+	}
+	if len(data) >= len(chunk) {
+		id, data = CodecRaw, chunk
+	}
+
+	stored := make([]byte, 0, 1+len(data))
+	stored = append(stored, id)
+	stored = append(stored, data...)
+	return s.ChunkStore.Put(digest, stored)
+}
+
+// Get return the decompressed chunk.
+func (s *CompressedStore) Get(digest Digest) ([]byte, error) {
+	stored, err := s.ChunkStore.Get(digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("%s: missing codec id: %w", digest, ErrCorruptedChunk)
+	}
+	codec, ok := s.codecs[stored[0]]
+	if !ok {
+		return nil, fmt.Errorf("%s: codec %d: %w", digest, stored[0], ErrUnknownCodec)
+	}
+	chunk, err := codec.Decompress(stored[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
@@ -0,0 +1,196 @@
+package fastcdc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io"
+	"testing"
+)
+
+// customCodec is a flate codec registered under a custom id.
+type customCodec struct {
+	*FlateCodec
+}
+
+func newCustomCodec() customCodec {
+	codec, _ := NewFlateCodec(flate.BestCompression)
+	return customCodec{codec}
+}
+
+func (customCodec) ID() uint8 { return 16 }
+
+// idCodec is a custom codec with an arbitrary id.
+type idCodec struct {
+	customCodec
+	id uint8
+}
+
+func (c idCodec) ID() uint8 { return c.id }
+
+func TestCompressedStore(t *testing.T) {
+	flateCodec, err := NewFlateCodec(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zlibCodec, err := NewZlibCodec(zlib.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressible := bytes.Repeat([]byte("fastcdc "), 1024)
+	incompressible := randomData(42, 8192)
+
+	cases := []struct {
+		name  string
+		codec Codec
+		chunk []byte
+		id    uint8
+	}{
+		{name: "flate", codec: flateCodec, chunk: compressible, id: CodecFlate},
+		{name: "zlib", codec: zlibCodec, chunk: compressible, id: CodecZlib},
+		{name: "raw fallback", codec: flateCodec, chunk: incompressible, id: CodecRaw},
+		{name: "no codec", codec: nil, chunk: compressible, id: CodecRaw},
+		{name: "custom", codec: newCustomCodec(), chunk: compressible, id: 16},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := NewMemoryStore()
+			store, err := NewCompressedStore(backend, tc.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := Sum(tc.chunk)
+			if err := store.Put(digest, tc.chunk); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := backend.Get(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored[0] != tc.id {
+				t.Errorf("codec id: want = %d, got = %d", tc.id, stored[0])
+			}
+			if tc.id != CodecRaw && len(stored) > len(tc.chunk) {
+				t.Errorf("chunk not compressed: %d bytes stored", len(stored))
+			}
+
+			got, err := store.Get(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.chunk, got) {
+				t.Error("chunk mismatch")
+			}
+		})
+	}
+}
+
+func TestCompressedStoreDecoders(t *testing.T) {
+	backend := NewMemoryStore()
+	chunk := bytes.Repeat([]byte("fastcdc "), 1024)
+	digest := Sum(chunk)
+
+	writer, err := NewCompressedStore(backend, newCustomCodec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Put(digest, chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks stored with another codec are still readable
+	flateCodec, _ := NewFlateCodec(flate.DefaultCompression)
+	reader, err := NewCompressedStore(backend, flateCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Get(digest); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("want = %s, got = %s", ErrUnknownCodec, err)
+	}
+	reader, err = NewCompressedStore(backend, flateCodec, newCustomCodec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reader.Get(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chunk, got) {
+		t.Error("chunk mismatch")
+	}
+
+	if _, err := NewCompressedStore(backend, newCustomCodec(), newCustomCodec()); !errors.Is(err, ErrDuplicateCodec) {
+		t.Errorf("want = %s, got = %s", ErrDuplicateCodec, err)
+	}
+
+	// Custom codecs can't shadow the package codecs
+	for _, id := range []uint8{CodecRaw, CodecFlate, CodecZlib, 15} {
+		codec := idCodec{newCustomCodec(), id}
+		if _, err := NewCompressedStore(backend, codec); !errors.Is(err, ErrReservedCodec) {
+			t.Errorf("codec %d: want = %s, got = %v", id, ErrReservedCodec, err)
+		}
+		if _, err := NewCompressedStore(backend, nil, codec); !errors.Is(err, ErrReservedCodec) {
+			t.Errorf("decoder %d: want = %s, got = %v", id, ErrReservedCodec, err)
+		}
+	}
+	if _, err := NewCompressedStore(backend, idCodec{newCustomCodec(), 255}); err != nil {
+		t.Error(err)
+	}
+
+	// Corrupted chunks are reported
+	if err := backend.Put(Digest{}, []byte{CodecFlate, 0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Get(Digest{}); !errors.Is(err, ErrCorruptedChunk) {
+		t.Errorf("want = %s, got = %s", ErrCorruptedChunk, err)
+	}
+
+	if _, err := NewFlateCodec(42); err == nil {
+		t.Error("want error for invalid level")
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	chunk := make([]byte, 2000)
+	flateCodec, _ := NewFlateCodec(flate.BestCompression)
+	zlibCodec, _ := NewZlibCodec(zlib.BestCompression)
+
+	tests := map[string]struct {
+		Codec  Codec
+		Reader func(data []byte) (io.Reader, error)
+	}{
+		"flate": {flateCodec, func(data []byte) (io.Reader, error) { return flate.NewReader(bytes.NewReader(data)), nil }},
+		"zlib":  {zlibCodec, func(data []byte) (io.Reader, error) { return zlib.NewReader(bytes.NewReader(data)) }},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := tc.Codec.Compress(chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, maxSize := range []uint{1000, 1999, 2000} {
+				r, err := tc.Reader(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := decompressWith(r, maxSize)
+				if maxSize < uint(len(chunk)) {
+					if !errors.Is(err, ErrCorruptedChunk) {
+						t.Errorf("max size %d: want = %s, got = %v", maxSize, ErrCorruptedChunk, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(chunk, got) {
+					t.Error("chunk mismatch")
+				}
+			}
+		})
+	}
+}
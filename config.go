@@ -0,0 +1,178 @@
+package fastcdc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Config is a validated and immutable chunker configuration. It is safe for concurrent
+// use and is meant to be built once and shared by many chunkers. Each chunker session
+// spawned from the configuration share its parameters and draw its buffers from a
+// shared pool.
+type Config struct {
+	bufferSize       uint
+	minSize          uint
+	avgSize          uint
+	maxSize          uint
+	maskS            uint
+	maskL            uint
+	stream           bool
+	lowLatency       bool
+	readAhead        bool
+	progressFn       ProgressFn
+	progressInterval uint
+	pool             *sync.Pool
+}
+
+// NewConfig validate the options and return an immutable configuration.
+func NewConfig(opts ...Option) (*Config, error) {
+	config := defaultOptions()
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.bufferSize == 0 {
+		config.bufferSize = 2 * config.maxSize
+	}
+
+	const (
+		errMinMsg = "chunks size must be at least"
+		errMaxMsg = "chunks size must be equal or lesser than"
+	)
+
+	if config.minSize < MinimumMin {
+		return nil, fmt.Errorf("the minimum %s %d: %w", errMinMsg, MinimumMin, ErrInvalidChunksSizePoint)
+	}
+	if config.minSize > MinimumMax {
+		return nil, fmt.Errorf("the minimum %s %d: %w", errMaxMsg, MinimumMax, ErrInvalidChunksSizePoint)
+	}
+	if config.avgSize < AverageMin {
+		return nil, fmt.Errorf("the average %s %d: %w", errMinMsg, AverageMin, ErrInvalidChunksSizePoint)
+	}
+	if config.avgSize > AverageMax {
+		return nil, fmt.Errorf("the average %s %d: %w", errMaxMsg, AverageMax, ErrInvalidChunksSizePoint)
+	}
+	if config.maxSize < MaximumMin {
+		return nil, fmt.Errorf("the maximum %s %d: %w", errMinMsg, MaximumMin, ErrInvalidChunksSizePoint)
+	}
+	if config.maxSize > MaximumMax {
+		return nil, fmt.Errorf("the maximum %s %d: %w", errMaxMsg, MaximumMax, ErrInvalidChunksSizePoint)
+	}
+	if config.bufferSize < config.maxSize {
+		return nil, fmt.Errorf("the buffer size must be greater or equal than the maximum cutting point (%d): %w", config.maxSize, ErrInvalidBufferLength)
+	}
+	if config.readAhead && config.lowLatency {
+		// The read-ahead always fill a complete buffer, which would delay every chunk.
+		return nil, fmt.Errorf("the read-ahead can't be used with the low latency mode: %w", ErrIncompatibleOptions)
+	}
+	if config.minSize >= config.avgSize {
+		return nil, fmt.Errorf("the minimum chunks size must be smaller than the average: %w", ErrInvalidChunksSizePoint)
+	}
+	if config.maxSize <= config.avgSize {
+		return nil, fmt.Errorf("the maximum chunks size must be bigger than the average: %w", ErrInvalidChunksSizePoint)
+	}
+	if config.maxSize-config.minSize <= config.avgSize {
+		return nil, fmt.Errorf("maximum - minimum chunks size must be bigger than the average chunk size: %w", ErrInvalidChunksSizePoint)
+	}
+
+	var bufferSize uint
+	if remaining := config.bufferSize % config.maxSize; remaining == 0 {
+		bufferSize = config.bufferSize
+	} else {
+		// Correct the buffer size to be a multiple of max size.
+		// This guarantees that the chunks will always have the
+		// same size regardless of the size of the buffer.
+		bufferSize = config.bufferSize + config.maxSize - remaining
+	}
+
+	bits := logarithm2(config.avgSize)
+	// Mask use 1 bits normalization.
+	// https://github.com/ronomon/deduplication#content-dependent-chunking
+	maskS := mask(bits + 1)
+	maskL := mask(bits - 1)
+
+	return &Config{
+		bufferSize:       bufferSize,
+		minSize:          config.minSize,
+		avgSize:          config.avgSize,
+		maxSize:          config.maxSize,
+		maskS:            maskS,
+		maskL:            maskL,
+		stream:           config.stream || config.lowLatency,
+		lowLatency:       config.lowLatency,
+		readAhead:        config.readAhead,
+		progressFn:       config.progressFn,
+		progressInterval: config.progressInterval,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, bufferSize)
+				return &buf
+			},
+		},
+	}, nil
+}
+
+// NewChunker return a new chunker session using the configuration.
+// The internal buffer is drawn from the configuration pool, call
+// Release to put it back once the chunker is not used anymore.
+// If a progress function is configured, it's shared by all sessions.
+func (c *Config) NewChunker(ctx context.Context) *FastCDC {
+	return c.newChunker(ctx, c.getBuffer())
+}
+
+// MinSize return the minimum chunks size.
+func (c *Config) MinSize() uint {
+	return c.minSize
+}
+
+// AvgSize return the average chunks size.
+func (c *Config) AvgSize() uint {
+	return c.avgSize
+}
+
+// MaxSize return the maximum chunks size.
+func (c *Config) MaxSize() uint {
+	return c.maxSize
+}
+
+// BufferSize return the internal buffer size, after the correction
+// to a multiple of the maximum chunks size.
+func (c *Config) BufferSize() uint {
+	return c.bufferSize
+}
+
+func (c *Config) newChunker(ctx context.Context, buffer []byte) *FastCDC {
+	return &FastCDC{
+		buffer:     buffer,
+		minSize:    c.minSize,
+		avgSize:    c.avgSize,
+		maxSize:    c.maxSize,
+		streamMode: c.stream,
+		lowLatency: c.lowLatency,
+		readAhead:  c.readAhead,
+		maskS:      c.maskS,
+		maskL:      c.maskL,
+		ctx:        ctx,
+		config:     c,
+
+		progressFn:       c.progressFn,
+		progressInterval: c.progressInterval,
+		nextProgress:     c.progressInterval,
+	}
+}
+
+// getBuffer return a buffer of the configured size from the pool. A buffer
+// too short to be used is dropped and a new one is allocated instead.
+func (c *Config) getBuffer() []byte {
+	buf := *c.pool.Get().(*[]byte)
+	if uint(len(buf)) < c.bufferSize {
+		return make([]byte, c.bufferSize)
+	}
+	return buf
+}
+
+func (c *Config) putBuffer(buf []byte) {
+	c.pool.Put(&buf)
+}
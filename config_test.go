@@ -0,0 +1,126 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	config, err := NewConfig(With32kChunks(), WithBufferSize(100_000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.MinSize() != 16384 || config.AvgSize() != 32_768 || config.MaxSize() != 65_536 {
+		t.Errorf("want = 16384/32768/65536, got = %d/%d/%d", config.MinSize(), config.AvgSize(), config.MaxSize())
+	}
+	// The buffer size is corrected to a multiple of the max size
+	if config.BufferSize() != 131_072 {
+		t.Errorf("buffer size: want = 131072, got = %d", config.BufferSize())
+	}
+
+	_, err = NewConfig(WithChunksSize(MinimumMin-1, AverageMin, MaximumMin))
+	if !errors.Is(err, ErrInvalidChunksSizePoint) {
+		t.Errorf("want = %s, got = %s", ErrInvalidChunksSizePoint, err)
+	}
+
+	_, err = NewConfig(WithLowLatencyMode(), WithReadAhead())
+	if !errors.Is(err, ErrIncompatibleOptions) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleOptions, err)
+	}
+}
+
+func TestConfigConcurrentSessions(t *testing.T) {
+	data, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Chunk struct {
+		Offset uint
+		Length uint
+	}
+
+	want := []Chunk{
+		{0, 22366},
+		{22366, 8282},
+		{30648, 16303},
+		{46951, 18696},
+		{65647, 32768},
+		{98415, 11051},
+	}
+
+	config, err := NewConfig(With16kChunks(), WithStreamMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(part int) {
+			defer wg.Done()
+			// Run several sessions per goroutine to reuse the pooled buffers
+			for j := 0; j < 10; j++ {
+				chunker := config.NewChunker(context.Background())
+				chunks := make([]Chunk, 0, 6)
+				fn := func(offset, length uint, chunk []byte) error {
+					chunks = append(chunks, Chunk{offset, length})
+					return nil
+				}
+
+				for k := 0; k < len(data); k += part*1000 + 1000 {
+					end := k + part*1000 + 1000
+					if end > len(data) {
+						end = len(data)
+					}
+					if err := chunker.Split(bytes.NewReader(data[k:end]), fn); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+				if err := chunker.Finalize(fn); err != nil {
+					t.Error(err)
+					return
+				}
+				chunker.Release()
+
+				if !reflect.DeepEqual(want, chunks) {
+					t.Errorf("want = %v, got = %v", want, chunks)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestConfigDoubleRelease(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunker := config.NewChunker(context.Background())
+	chunker.Release()
+	chunker.Release()
+
+	// The later sessions must never get an empty buffer from the pool
+	for i := 0; i < 4; i++ {
+		chunker := config.NewChunker(context.Background())
+		if uint(len(chunker.buffer)) != config.BufferSize() {
+			t.Errorf("buffer length: want = %d, got = %d", config.BufferSize(), len(chunker.buffer))
+		}
+		defer chunker.Release()
+	}
+
+	// A short buffer put in the pool by mistake is dropped
+	config.putBuffer(nil)
+	if buf := config.getBuffer(); uint(len(buf)) != config.BufferSize() {
+		t.Errorf("buffer length: want = %d, got = %d", config.BufferSize(), len(buf))
+	}
+}
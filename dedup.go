@@ -0,0 +1,169 @@
+package fastcdc
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// DigestSet is the set of chunk digests already seen by a Deduplicator.
+type DigestSet interface {
+	// Has report whether the digest is in the set.
+	Has(digest Digest) (bool, error)
+	// Add add the digest to the set and report whether it was already present.
+	Add(digest Digest) (bool, error)
+}
+
+// MemoryDigestSet is an exact in-memory DigestSet. It uses about 64 bytes per digest.
+type MemoryDigestSet struct {
+	mu      sync.Mutex
+	digests map[Digest]struct{}
+}
+
+// NewMemoryDigestSet return an empty MemoryDigestSet.
+func NewMemoryDigestSet() *MemoryDigestSet {
+	return &MemoryDigestSet{
+		digests: make(map[Digest]struct{}),
+	}
+}
+
+// Has report whether the digest is in the set.
+func (s *MemoryDigestSet) Has(digest Digest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.digests[digest]
+	return ok, nil
+}
+
+// Add add the digest to the set and report whether it was already present.
+func (s *MemoryDigestSet) Add(digest Digest) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.digests[digest]; ok {
+		return true, nil
+	}
+	s.digests[digest] = struct{}{}
+	return false, nil
+}
+
+// Len return the number of digests in the set.
+func (s *MemoryDigestSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.digests)
+}
+
+// DigestChunkFn is called with the chunk and its digest. The chunk
+// is only valid in the callback and must be copied for later use.
+type DigestChunkFn func(offset, length uint, digest Digest, chunk []byte) error
+
+// DedupReport is the deduplication report of one or many inputs.
+type DedupReport struct {
+	// TotalBytes is the number of bytes read.
+	TotalBytes uint
+	// UniqueBytes is the number of bytes in the previously unseen chunks.
+	UniqueBytes uint
+	// Chunks is the number of chunks.
+	Chunks uint
+	// DuplicateChunks is the number of chunks already seen.
+	DuplicateChunks uint
+	// Ratio is the cumulative deduplication ratio of all the inputs
+	// consumed so far by the deduplicator, total bytes / unique bytes.
+	Ratio float64
+}
+
+// Deduplicator split many inputs and keep track of the chunks already seen, across
+// all the inputs. Only the previously unseen chunks are forwarded to the callback.
+// Deduplicator is safe for concurrent use.
+type Deduplicator struct {
+	ctx    context.Context
+	config *Config
+	seen   DigestSet
+	mu     sync.Mutex
+	stats  DedupReport
+}
+
+// NewDeduplicator return a deduplicator splitting the inputs with the configuration.
+// If seen is nil, an exact in-memory digest set is used.
+func NewDeduplicator(ctx context.Context, config *Config, seen DigestSet) *Deduplicator {
+	if seen == nil {
+		seen = NewMemoryDigestSet()
+	}
+	return &Deduplicator{
+		ctx:    ctx,
+		config: config,
+		seen:   seen,
+	}
+}
+
+// Dedup split r and call fn with every previously unseen chunk. It return the
+// deduplication report of r, along with the cumulative deduplication ratio.
+// fn may be nil if only the report is needed. A chunk is only marked as seen
+// once fn returns nil, so a chunk that failed is forwarded again later.
+func (d *Deduplicator) Dedup(r io.Reader, fn DigestChunkFn) (DedupReport, error) {
+	var report DedupReport
+	chunker := d.config.NewChunker(d.ctx)
+	defer chunker.Release()
+
+	chunkFn := func(offset, length uint, chunk []byte) error {
+		digest := Sum(chunk)
+		seen, err := d.seen.Has(digest)
+		if err != nil {
+			return err
+		}
+		if !seen {
+			// The digest is only marked as seen once fn succeed, otherwise
+			// the later occurrences of a chunk never stored would be skipped.
+			if fn != nil {
+				if err := fn(offset, length, digest, chunk); err != nil {
+					return err
+				}
+			}
+			// Another input may have added the digest concurrently,
+			// the chunk is then forwarded twice but counted once.
+			if seen, err = d.seen.Add(digest); err != nil {
+				return err
+			}
+		}
+		report.Chunks++
+		report.TotalBytes += length
+		if seen {
+			report.DuplicateChunks++
+			return nil
+		}
+		report.UniqueBytes += length
+		return nil
+	}
+
+	err := chunker.Split(r, chunkFn)
+	if err == nil {
+		err = chunker.Finalize(chunkFn)
+	}
+
+	// Account for the chunks processed before an error too,
+	// since they are now part of the digest set.
+	d.mu.Lock()
+	d.stats.TotalBytes += report.TotalBytes
+	d.stats.UniqueBytes += report.UniqueBytes
+	d.stats.Chunks += report.Chunks
+	d.stats.DuplicateChunks += report.DuplicateChunks
+	d.stats.Ratio = dedupRatio(d.stats.TotalBytes, d.stats.UniqueBytes)
+	report.Ratio = d.stats.Ratio
+	d.mu.Unlock()
+
+	return report, err
+}
+
+// Stats return the cumulative deduplication report of all the inputs.
+func (d *Deduplicator) Stats() DedupReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+func dedupRatio(total, unique uint) float64 {
+	if unique == 0 {
+		return 1
+	}
+	return float64(total) / float64(unique)
+}
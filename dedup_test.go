@@ -0,0 +1,116 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeduplicator(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(155, 4*1024*1024)
+	// Insert a few bytes in the middle of the data
+	edited := append(append(append([]byte(nil), data[:2*1024*1024]...), []byte("fastcdc")...), data[2*1024*1024:]...)
+
+	dedup := NewDeduplicator(context.Background(), config, nil)
+	store := NewMemoryStore()
+	fn := func(offset, length uint, digest Digest, chunk []byte) error {
+		if ok, _ := store.Has(digest); ok {
+			t.Errorf("chunk %s forwarded twice", digest)
+		}
+		return store.Put(digest, chunk)
+	}
+
+	first, err := dedup.Dedup(bytes.NewReader(data), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.TotalBytes != uint(len(data)) || first.UniqueBytes != uint(len(data)) || first.DuplicateChunks != 0 {
+		t.Errorf("first report: %+v", first)
+	}
+	if first.Ratio != 1 {
+		t.Errorf("ratio: want = 1, got = %f", first.Ratio)
+	}
+
+	second, err := dedup.Dedup(bytes.NewReader(data), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.UniqueBytes != 0 || second.DuplicateChunks != first.Chunks {
+		t.Errorf("second report: %+v", second)
+	}
+	if second.Ratio != 2 {
+		t.Errorf("ratio: want = 2, got = %f", second.Ratio)
+	}
+
+	third, err := dedup.Dedup(bytes.NewReader(edited), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the chunks around the edit are new
+	if third.UniqueBytes == 0 || third.UniqueBytes > 3*32768 {
+		t.Errorf("third report: %+v", third)
+	}
+
+	stats := dedup.Stats()
+	if stats.TotalBytes != 2*uint(len(data))+uint(len(edited)) {
+		t.Errorf("total bytes: want = %d, got = %d", 2*len(data)+len(edited), stats.TotalBytes)
+	}
+	if stats.UniqueBytes != first.UniqueBytes+third.UniqueBytes {
+		t.Errorf("unique bytes: want = %d, got = %d", first.UniqueBytes+third.UniqueBytes, stats.UniqueBytes)
+	}
+	if stats.Ratio != third.Ratio {
+		t.Errorf("ratio: want = %f, got = %f", third.Ratio, stats.Ratio)
+	}
+	if uint(store.Len()) != first.Chunks-first.DuplicateChunks+third.Chunks-third.DuplicateChunks {
+		t.Errorf("stored chunks: got = %d", store.Len())
+	}
+}
+
+func TestDeduplicatorFailure(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(156, 1024*1024)
+
+	dedup := NewDeduplicator(context.Background(), config, nil)
+	store := NewMemoryStore()
+	errPut := errors.New("put failed")
+	fail := true
+	fn := func(offset, length uint, digest Digest, chunk []byte) error {
+		// The first chunk fails once, as a store error would
+		if fail {
+			fail = false
+			return errPut
+		}
+		return store.Put(digest, chunk)
+	}
+
+	report, err := dedup.Dedup(bytes.NewReader(data), fn)
+	if !errors.Is(err, errPut) {
+		t.Fatalf("want = %s, got = %v", errPut, err)
+	}
+	if report.Chunks != 0 {
+		t.Errorf("chunks: want = 0, got = %d", report.Chunks)
+	}
+
+	// The failed chunk is not marked as seen and is forwarded again
+	report, err = dedup.Dedup(bytes.NewReader(data), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DuplicateChunks != 0 || report.UniqueBytes != uint(len(data)) {
+		t.Errorf("report: %+v", report)
+	}
+	if uint(store.Len()) != report.Chunks {
+		t.Errorf("stored chunks: want = %d, got = %d", report.Chunks, store.Len())
+	}
+	if stats := dedup.Stats(); stats.TotalBytes != uint(len(data)) || stats.UniqueBytes != uint(len(data)) {
+		t.Errorf("stats: %+v", stats)
+	}
+}
@@ -0,0 +1,156 @@
+package fastcdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+var ErrInvalidDelta = errors.New("invalid delta")
+
+const (
+	// deltaHashWindow is the number of bytes hashed to find matches in the base.
+	deltaHashWindow = 8
+	// deltaMinMatch is the minimum length of a copy, shorter matches
+	// cost more to encode than the literal bytes.
+	deltaMinMatch = 12
+	// maxDeltaTableBits bound the hash table to 4 MiB. Positions of larger bases
+	// share the table slots, the latest position of a hash being kept.
+	maxDeltaTableBits = 20
+)
+
+// Delta layout, all integers are uvarint encoded:
+//
+//	base length | target length
+//	insert: length << 1 | bytes
+//	copy: length << 1 | 1 | offset in the base
+
+// EncodeDelta return the delta rebuilding target from base. Matches are found with a hash
+// table of the positions of the base, and extended in both directions.
+func EncodeDelta(base, target []byte) []byte {
+	delta := make([]byte, 0, len(target)/4+16)
+	delta = appendUvarint(delta, uint(len(base)))
+	delta = appendUvarint(delta, uint(len(target)))
+
+	tableBits := uint(bits.Len(uint(len(base)))) + 1
+	if tableBits < 10 {
+		tableBits = 10
+	} else if tableBits > maxDeltaTableBits {
+		tableBits = maxDeltaTableBits
+	}
+	table := make([]int32, 1<<tableBits)
+	for i := range table {
+		table[i] = -1
+	}
+	hash := func(p []byte) uint64 {
+		return (binary.LittleEndian.Uint64(p) * 0x9e3779b97f4a7c15) >> (64 - tableBits)
+	}
+	for p := 0; p+deltaHashWindow <= len(base); p++ {
+		table[hash(base[p:])] = int32(p)
+	}
+
+	literal := 0
+	for i := 0; i+deltaHashWindow <= len(target); {
+		candidate := int(table[hash(target[i:])])
+		if candidate < 0 {
+			i++
+			continue
+		}
+
+		// Extend the match forward, then backward over the pending literal bytes
+		length := 0
+		for candidate+length < len(base) && i+length < len(target) && base[candidate+length] == target[i+length] {
+			length++
+		}
+		start, offset := i, candidate
+		for start > literal && offset > 0 && base[offset-1] == target[start-1] {
+			start--
+			offset--
+			length++
+		}
+		if length < deltaMinMatch {
+			i++
+			continue
+		}
+
+		if start > literal {
+			delta = appendUvarint(delta, uint(start-literal)<<1)
+			delta = append(delta, target[literal:start]...)
+		}
+		delta = appendUvarint(delta, uint(length)<<1|1)
+		delta = appendUvarint(delta, uint(offset))
+		i = start + length
+		literal = i
+	}
+	if literal < len(target) {
+		delta = appendUvarint(delta, uint(len(target)-literal)<<1)
+		delta = append(delta, target[literal:]...)
+	}
+	return delta
+}
+
+// DecodeDelta return the target rebuilt from base and the delta.
+func DecodeDelta(base, delta []byte) ([]byte, error) {
+	next := func() (uint, error) {
+		v, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return 0, fmt.Errorf("truncated delta: %w", ErrInvalidDelta)
+		}
+		delta = delta[n:]
+		return uint(v), nil
+	}
+
+	baseLength, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if baseLength != uint(len(base)) {
+		return nil, fmt.Errorf("base length %d differ from %d: %w", len(base), baseLength, ErrInvalidDelta)
+	}
+	targetLength, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if targetLength > MaximumMax {
+		return nil, fmt.Errorf("target too large: %w", ErrInvalidDelta)
+	}
+
+	// The target length is untrusted, don't allocate more than the base and the
+	// delta upfront. Larger targets grow as the instructions produce them.
+	capacity := targetLength
+	if n := uint(len(base) + len(delta)); n < capacity {
+		capacity = n
+	}
+	target := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		op, err := next()
+		if err != nil {
+			return nil, err
+		}
+		length := op >> 1
+		if uint(len(target))+length > targetLength {
+			return nil, fmt.Errorf("target overflow: %w", ErrInvalidDelta)
+		}
+		if op&1 == 0 {
+			if length > uint(len(delta)) {
+				return nil, fmt.Errorf("truncated insert: %w", ErrInvalidDelta)
+			}
+			target = append(target, delta[:length]...)
+			delta = delta[length:]
+			continue
+		}
+		offset, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if offset > uint(len(base)) || length > uint(len(base))-offset {
+			return nil, fmt.Errorf("copy out of the base: %w", ErrInvalidDelta)
+		}
+		target = append(target, base[offset:offset+length]...)
+	}
+	if uint(len(target)) != targetLength {
+		return nil, fmt.Errorf("target length %d differ from %d: %w", len(target), targetLength, ErrInvalidDelta)
+	}
+	return target, nil
+}
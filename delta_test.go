@@ -0,0 +1,85 @@
+package fastcdc
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	base := randomData(61, 64*1024)
+	// Larger than the capped hash table
+	large := randomData(60, 4<<maxDeltaTableBits)
+	edit := func(data []byte, at int, insert string, remove int) []byte {
+		out := append([]byte(nil), data[:at]...)
+		out = append(out, insert...)
+		return append(out, data[at+remove:]...)
+	}
+
+	tests := map[string]struct {
+		Base     []byte
+		Target   []byte
+		MaxDelta int
+	}{
+		"identical":     {base, base, 16},
+		"byte changed":  {base, edit(base, 30000, "x", 1), 32},
+		"insertion":     {base, edit(base, 100, "some inserted bytes", 0), 64},
+		"deletion":      {base, edit(base, 5000, "", 1000), 32},
+		"many edits":    {base, edit(edit(edit(base, 60000, "a", 1), 20000, "b", 1), 10, "c", 1), 64},
+		"shifted":       {base, append(randomData(62, 100), base...), 160},
+		"unrelated":     {base, randomData(63, 4096), 4096 + 16},
+		"empty base":    {nil, base[:1000], 1000 + 16},
+		"empty target":  {base, nil, 16},
+		"short target":  {base, base[:5], 16},
+		"self repeated": {base[:100], bytes.Repeat(base[:100], 10), 64},
+		"large base":    {large, edit(large, 3_000_000, "x", 1), 1024},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			delta := EncodeDelta(tc.Base, tc.Target)
+			if len(delta) > tc.MaxDelta {
+				t.Errorf("delta size: want <= %d, got = %d", tc.MaxDelta, len(delta))
+			}
+			got, err := DecodeDelta(tc.Base, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.Target, got) {
+				t.Error("target mismatch")
+			}
+		})
+	}
+
+	delta := EncodeDelta(base, edit(base, 100, "x", 0))
+	invalid := map[string]struct {
+		Base  []byte
+		Delta []byte
+	}{
+		"other base":   {base[:1000], delta},
+		"truncated":    {base, delta[:len(delta)-1]},
+		"empty":        {base, nil},
+		"out of base":  {base, append(appendUvarint(appendUvarint(appendUvarint(nil, uint(len(base))), 10), 10<<1|1), appendUvarint(nil, uint(len(base)))...)},
+		"long literal": {base, append(appendUvarint(appendUvarint(nil, uint(len(base))), 1), 2<<1, 'a', 'b')},
+		"short target": {base, append(appendUvarint(appendUvarint(nil, uint(len(base))), uint(MaximumMax)), 2<<1, 'a', 'b')},
+	}
+	for name, tc := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecodeDelta(tc.Base, tc.Delta); !errors.Is(err, ErrInvalidDelta) {
+				t.Errorf("want = %s, got = %v", ErrInvalidDelta, err)
+			}
+		})
+	}
+
+	// The target length of the header isn't allocated upfront
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := DecodeDelta(base, invalid["short target"].Delta); !errors.Is(err, ErrInvalidDelta) {
+		t.Errorf("want = %s, got = %v", ErrInvalidDelta, err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 2*uint64(len(base)) {
+		t.Errorf("allocated: want <= %d, got = %d", 2*len(base), allocated)
+	}
+}
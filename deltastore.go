@@ -0,0 +1,283 @@
+package fastcdc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxDeltaDepth is the default maximum length of a delta chain.
+const DefaultMaxDeltaDepth uint = 4
+
+// Delta store records.
+const (
+	deltaRecordFull  byte = 0 // 0 | chunk
+	deltaRecordDelta byte = 1 // 1 | base digest | depth (uvarint) | delta
+)
+
+// maxDeltaChain bound the chains followed when reading, in case of corrupted depths.
+const maxDeltaChain = 64
+
+// DeltaStore store chunks as deltas against a similar chunk when it saves space. Similar
+// chunks are found with their resemblance sketch, and a chunk stored as a delta can itself be
+// the base of another delta, up to a maximum chain depth to bound the cost of reading.
+// Chunks are transparently rebuilt on read, and still keyed by their own digest.
+//
+// The base of a delta must stay stored as long as the delta: deleting a base first rewrite
+// its dependent chunks in full, which require the underlying store to implement ChunkReplacer.
+// The sketches and dependencies are kept in memory and rebuilt when the store is opened,
+// which require the underlying store to implement ChunkWalker.
+type DeltaStore struct {
+	store    ChunkStore
+	maxDepth uint
+
+	mu         sync.RWMutex
+	index      *SimilarityIndex
+	sketches   map[Digest]Sketch
+	dependents map[Digest]map[Digest]struct{}
+}
+
+// NewDeltaStore return a DeltaStore over store with delta chains of at most maxDepth deltas.
+// If maxDepth is 0, DefaultMaxDeltaDepth is used. The store must implement ChunkWalker, every
+// stored chunk is read to rebuild the in-memory state.
+func NewDeltaStore(store ChunkStore, maxDepth uint) (*DeltaStore, error) {
+	walker, ok := store.(ChunkWalker)
+	if !ok {
+		return nil, ErrUnsupportedStore
+	}
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDeltaDepth
+	}
+	s := &DeltaStore{
+		store:      store,
+		maxDepth:   maxDepth,
+		index:      NewSimilarityIndex(),
+		sketches:   make(map[Digest]Sketch),
+		dependents: make(map[Digest]map[Digest]struct{}),
+	}
+
+	err := walker.Walk(func(digest Digest) error {
+		record, err := store.Get(digest)
+		if err != nil {
+			return err
+		}
+		if base, _, _, err := parseDeltaRecord(digest, record); err != nil {
+			return err
+		} else if base != nil {
+			s.addDependent(*base, digest)
+		}
+		chunk, err := s.get(digest, 0)
+		if err != nil {
+			return err
+		}
+		sketch := NewSketch(chunk)
+		s.sketches[digest] = sketch
+		s.index.Add(digest, sketch)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put store the chunk, as a delta against a similar chunk if it's smaller.
+func (s *DeltaStore) Put(digest Digest, chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ok, err := s.store.Has(digest)
+	if err != nil || ok {
+		return err
+	}
+
+	sketch := NewSketch(chunk)
+	record, base, err := s.encode(digest, chunk, sketch)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(digest, record); err != nil {
+		return err
+	}
+	if base != nil {
+		s.addDependent(*base, digest)
+	}
+	s.sketches[digest] = sketch
+	s.index.Add(digest, sketch)
+	return nil
+}
+
+// encode return the record of the chunk and the digest of its base if stored as a delta.
+func (s *DeltaStore) encode(digest Digest, chunk []byte, sketch Sketch) ([]byte, *Digest, error) {
+	full := append([]byte{deltaRecordFull}, chunk...)
+
+	base, score := s.index.Find(sketch)
+	if score == 0 || base == digest {
+		return full, nil, nil
+	}
+	baseRecord, err := s.store.Get(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, depth, _, err := parseDeltaRecord(base, baseRecord)
+	if err != nil {
+		return nil, nil, err
+	}
+	if depth+1 > s.maxDepth {
+		return full, nil, nil
+	}
+	baseChunk, err := s.get(base, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	delta := EncodeDelta(baseChunk, chunk)
+	record := make([]byte, 0, 1+len(base)+binary.MaxVarintLen64+len(delta))
+	record = append(record, deltaRecordDelta)
+	record = append(record, base[:]...)
+	record = appendUvarint(record, depth+1)
+	record = append(record, delta...)
+	if len(record) >= len(full) {
+		return full, nil, nil
+	}
+	return record, &base, nil
+}
+
+// Get return the chunk, rebuilt from its delta chain if needed.
+func (s *DeltaStore) Get(digest Digest) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunk, err := s.get(digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	if Sum(chunk) != digest {
+		return nil, fmt.Errorf("%s: digest mismatch: %w", digest, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
+
+func (s *DeltaStore) get(digest Digest, chain int) ([]byte, error) {
+	if chain > maxDeltaChain {
+		return nil, fmt.Errorf("%s: delta chain too long: %w", digest, ErrCorruptedChunk)
+	}
+	record, err := s.store.Get(digest)
+	if err != nil {
+		return nil, err
+	}
+	base, _, data, err := parseDeltaRecord(digest, record)
+	if err != nil || base == nil {
+		return data, err
+	}
+	baseChunk, err := s.get(*base, chain+1)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := DecodeDelta(baseChunk, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
+
+// Has report whether the chunk is stored.
+func (s *DeltaStore) Has(digest Digest) (bool, error) {
+	return s.store.Has(digest)
+}
+
+// Delete remove the chunk. The chunks stored as a delta against it are first replaced by
+// their full record, so they survive a failure at any point. If the chunk is the base of
+// other chunks and the underlying store doesn't implement ChunkReplacer, Delete fail
+// with ErrUnsupportedStore.
+func (s *DeltaStore) Delete(digest Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.store.Get(digest)
+	if err != nil {
+		return err
+	}
+	base, _, _, err := parseDeltaRecord(digest, record)
+	if err != nil {
+		return err
+	}
+
+	if len(s.dependents[digest]) > 0 {
+		replacer, ok := s.store.(ChunkReplacer)
+		if !ok {
+			return fmt.Errorf("%s: can't rewrite its dependents: %w", digest, ErrUnsupportedStore)
+		}
+		for dependent := range s.dependents[digest] {
+			chunk, err := s.get(dependent, 0)
+			if err != nil {
+				return err
+			}
+			if err := replacer.Replace(dependent, append([]byte{deltaRecordFull}, chunk...)); err != nil {
+				return err
+			}
+			delete(s.dependents[digest], dependent)
+		}
+	}
+	delete(s.dependents, digest)
+
+	if err := s.store.Delete(digest); err != nil {
+		return err
+	}
+	if base != nil {
+		delete(s.dependents[*base], digest)
+	}
+	if sketch, ok := s.sketches[digest]; ok {
+		s.index.Remove(digest, sketch)
+		delete(s.sketches, digest)
+	}
+	return nil
+}
+
+// Walk call fn with the digest of every stored chunk, in increasing digest order.
+func (s *DeltaStore) Walk(fn func(digest Digest) error) error {
+	return s.store.(ChunkWalker).Walk(fn)
+}
+
+// Depth return the length of the delta chain of the chunk, 0 for a chunk stored in full.
+func (s *DeltaStore) Depth(digest Digest) (uint, error) {
+	record, err := s.store.Get(digest)
+	if err != nil {
+		return 0, err
+	}
+	_, depth, _, err := parseDeltaRecord(digest, record)
+	return depth, err
+}
+
+func (s *DeltaStore) addDependent(base, digest Digest) {
+	dependents, ok := s.dependents[base]
+	if !ok {
+		dependents = make(map[Digest]struct{})
+		s.dependents[base] = dependents
+	}
+	dependents[digest] = struct{}{}
+}
+
+// parseDeltaRecord return the base digest and depth of a delta record along with the delta,
+// or a nil base and the chunk of a full record.
+func parseDeltaRecord(digest Digest, record []byte) (*Digest, uint, []byte, error) {
+	if len(record) == 0 {
+		return nil, 0, nil, fmt.Errorf("%s: empty record: %w", digest, ErrCorruptedChunk)
+	}
+	switch record[0] {
+	case deltaRecordFull:
+		return nil, 0, record[1:], nil
+	case deltaRecordDelta:
+		var base Digest
+		if len(record) < 1+len(base) {
+			return nil, 0, nil, fmt.Errorf("%s: truncated record: %w", digest, ErrCorruptedChunk)
+		}
+		copy(base[:], record[1:])
+		depth, n := binary.Uvarint(record[1+len(base):])
+		if n <= 0 {
+			return nil, 0, nil, fmt.Errorf("%s: truncated record: %w", digest, ErrCorruptedChunk)
+		}
+		return &base, uint(depth), record[1+len(base)+n:], nil
+	default:
+		return nil, 0, nil, fmt.Errorf("%s: unknown record type %d: %w", digest, record[0], ErrCorruptedChunk)
+	}
+}
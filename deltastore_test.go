@@ -0,0 +1,207 @@
+package fastcdc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDeltaStore(t *testing.T) {
+	backend := NewMemoryStore()
+	store, err := NewDeltaStore(backend, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Near identical chunks, like the blocks of VM images
+	base := randomData(64, 64*1024)
+	chunks := make([][]byte, 10)
+	var total int
+	for i := range chunks {
+		chunk := append([]byte(nil), base...)
+		chunk[1000*i+500] ^= 0xff
+		chunks[i] = chunk
+		total += len(chunk)
+		if err := store.Put(Sum(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unrelated := randomData(65, 64*1024)
+	if err := store.Put(Sum(unrelated), unrelated); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := 0
+	for _, chunk := range chunks {
+		record, err := backend.Get(Sum(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored += len(record)
+		depth, err := store.Depth(Sum(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depth > 2 {
+			t.Errorf("depth: want <= 2, got = %d", depth)
+		}
+	}
+	if stored > total/4 {
+		t.Errorf("stored: want <= %d, got = %d", total/4, stored)
+	}
+	if depth, _ := store.Depth(Sum(unrelated)); depth != 0 {
+		t.Errorf("unrelated chunk depth: want = 0, got = %d", depth)
+	}
+
+	check := func(store *DeltaStore, chunks [][]byte) {
+		t.Helper()
+		for _, chunk := range chunks {
+			got, err := store.Get(Sum(chunk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(chunk, got) {
+				t.Errorf("chunk %s mismatch", Sum(chunk))
+			}
+		}
+	}
+	check(store, chunks)
+
+	// The dependents of a deleted base are still readable
+	if err := store.Delete(Sum(chunks[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(Sum(chunks[0])); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+	check(store, chunks[1:])
+
+	// The in-memory state is rebuilt when the store is opened
+	reopened, err := NewDeltaStore(backend, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reopened, chunks[1:])
+	extra := append([]byte(nil), base...)
+	extra[64000] ^= 0xff
+	if err := reopened.Put(Sum(extra), extra); err != nil {
+		t.Fatal(err)
+	}
+	if depth, _ := reopened.Depth(Sum(extra)); depth == 0 {
+		t.Error("similar chunk stored in full after reopening")
+	}
+	for _, chunk := range chunks[1:] {
+		if err := reopened.Delete(Sum(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(reopened, [][]byte{extra, unrelated})
+}
+
+// failingReplacer fail to replace the chunks.
+type failingReplacer struct {
+	*MemoryStore
+}
+
+func (s failingReplacer) Replace(digest Digest, chunk []byte) error {
+	return errors.New("replace failure")
+}
+
+// noReplacer hide the Replace method of the memory store.
+type noReplacer struct {
+	store *MemoryStore
+}
+
+func (s noReplacer) Put(digest Digest, chunk []byte) error   { return s.store.Put(digest, chunk) }
+func (s noReplacer) Get(digest Digest) ([]byte, error)       { return s.store.Get(digest) }
+func (s noReplacer) Has(digest Digest) (bool, error)         { return s.store.Has(digest) }
+func (s noReplacer) Delete(digest Digest) error              { return s.store.Delete(digest) }
+func (s noReplacer) Walk(fn func(digest Digest) error) error { return s.store.Walk(fn) }
+
+func TestDeltaStoreDeleteFailure(t *testing.T) {
+	base := randomData(66, 64*1024)
+	chunk := append([]byte(nil), base...)
+	chunk[100] ^= 0xff
+
+	backends := map[string]struct {
+		Store ChunkStore
+		Err   error
+	}{
+		"failing replace": {failingReplacer{NewMemoryStore()}, nil},
+		"no replace":      {noReplacer{NewMemoryStore()}, ErrUnsupportedStore},
+	}
+	for name, tc := range backends {
+		t.Run(name, func(t *testing.T) {
+			store, err := NewDeltaStore(tc.Store, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range [][]byte{base, chunk} {
+				if err := store.Put(Sum(data), data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if depth, _ := store.Depth(Sum(chunk)); depth != 1 {
+				t.Fatalf("depth: want = 1, got = %d", depth)
+			}
+
+			err = store.Delete(Sum(base))
+			if err == nil || (tc.Err != nil && !errors.Is(err, tc.Err)) {
+				t.Errorf("want = %v, got = %v", tc.Err, err)
+			}
+			// Neither the base nor its dependent is lost
+			for _, data := range [][]byte{base, chunk} {
+				got, err := store.Get(Sum(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, got) {
+					t.Errorf("chunk %s mismatch", Sum(data))
+				}
+			}
+		})
+	}
+}
+
+func TestDeltaStoreReopen(t *testing.T) {
+	base := randomData(67, 64*1024)
+	chunk := append([]byte(nil), base...)
+	chunk[100] ^= 0xff
+
+	backend := NewMemoryStore()
+	store, err := NewDeltaStore(backend, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{base, chunk} {
+		if err := store.Put(Sum(data), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth, _ := store.Depth(Sum(chunk)); depth != 1 {
+		t.Fatalf("depth: want = 1, got = %d", depth)
+	}
+
+	// The dependents are known after reopening, deleting the base keep them readable
+	reopened, err := NewDeltaStore(backend, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Delete(Sum(base)); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*DeltaStore{reopened, store} {
+		got, err := s.Get(Sum(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(chunk, got) {
+			t.Error("dependent chunk mismatch")
+		}
+	}
+
+	// The dependents can't be rebuilt without walking the store
+	if _, err := NewDeltaStore(struct{ ChunkStore }{backend}, 0); !errors.Is(err, ErrUnsupportedStore) {
+		t.Errorf("want = %s, got = %v", ErrUnsupportedStore, err)
+	}
+}
@@ -0,0 +1,96 @@
+package fastcdc
+
+import "fmt"
+
+// ByteRange is a range of bytes in a file.
+type ByteRange struct {
+	Offset uint `json:"offset"`
+	Length uint `json:"length"`
+}
+
+// ManifestDiff is the difference between two versions of a file.
+type ManifestDiff struct {
+	// Shared are the chunks of the new version also found in the old version.
+	Shared []ManifestChunk
+	// Removed are the chunks of the old version not found in the new version.
+	Removed []ManifestChunk
+	// Added are the chunks of the new version not found in the old version.
+	Added []ManifestChunk
+	// Transfer are the ranges of the new version to transfer to rebuild it from the
+	// old version. Adjacent added chunks are merged, and a chunk added more than once
+	// is only transferred once.
+	Transfer []ByteRange
+}
+
+// SharedBytes return the number of bytes of the new version found in the old version.
+func (d *ManifestDiff) SharedBytes() uint {
+	var n uint
+	for _, chunk := range d.Shared {
+		n += chunk.Length
+	}
+	return n
+}
+
+// TransferBytes return the number of bytes to transfer.
+func (d *ManifestDiff) TransferBytes() uint {
+	var n uint
+	for _, r := range d.Transfer {
+		n += r.Length
+	}
+	return n
+}
+
+// DiffManifests return the difference between two manifests of the same file. Both manifests
+// must be produced with the same chunker parameters, otherwise the chunks boundaries differ
+// and DiffManifests fail with ErrIncompatibleManifest.
+func DiffManifests(old, new *Manifest) (*ManifestDiff, error) {
+	if old.Algorithm != new.Algorithm || old.MinSize != new.MinSize || old.AvgSize != new.AvgSize || old.MaxSize != new.MaxSize {
+		return nil, fmt.Errorf(
+			"%s %d/%d/%d differ from %s %d/%d/%d: %w",
+			old.Algorithm, old.MinSize, old.AvgSize, old.MaxSize,
+			new.Algorithm, new.MinSize, new.AvgSize, new.MaxSize,
+			ErrIncompatibleManifest,
+		)
+	}
+
+	oldDigests := make(map[Digest]struct{}, len(old.Chunks))
+	for _, chunk := range old.Chunks {
+		oldDigests[chunk.Digest] = struct{}{}
+	}
+	newDigests := make(map[Digest]struct{}, len(new.Chunks))
+	for _, chunk := range new.Chunks {
+		newDigests[chunk.Digest] = struct{}{}
+	}
+
+	diff := &ManifestDiff{
+		Shared:   make([]ManifestChunk, 0),
+		Removed:  make([]ManifestChunk, 0),
+		Added:    make([]ManifestChunk, 0),
+		Transfer: make([]ByteRange, 0),
+	}
+	for _, chunk := range old.Chunks {
+		if _, ok := newDigests[chunk.Digest]; !ok {
+			diff.Removed = append(diff.Removed, chunk)
+		}
+	}
+
+	transferred := make(map[Digest]struct{})
+	for _, chunk := range new.Chunks {
+		if _, ok := oldDigests[chunk.Digest]; ok {
+			diff.Shared = append(diff.Shared, chunk)
+			continue
+		}
+		diff.Added = append(diff.Added, chunk)
+
+		if _, ok := transferred[chunk.Digest]; ok {
+			continue
+		}
+		transferred[chunk.Digest] = struct{}{}
+		if n := len(diff.Transfer); n > 0 && diff.Transfer[n-1].Offset+diff.Transfer[n-1].Length == chunk.Offset {
+			diff.Transfer[n-1].Length += chunk.Length
+			continue
+		}
+		diff.Transfer = append(diff.Transfer, ByteRange{Offset: chunk.Offset, Length: chunk.Length})
+	}
+	return diff, nil
+}
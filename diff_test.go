@@ -0,0 +1,101 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifests(t *testing.T) {
+	a, b, c, d := Sum([]byte("a")), Sum([]byte("b")), Sum([]byte("c")), Sum([]byte("d"))
+	header := ManifestHeader{Version: ManifestVersion, Algorithm: ManifestAlgorithm, MinSize: 1, AvgSize: 2, MaxSize: 4}
+	manifest := func(chunks ...Digest) *Manifest {
+		m := &Manifest{ManifestHeader: header}
+		for _, digest := range chunks {
+			m.Add(m.Size, 10, digest)
+		}
+		return m
+	}
+
+	tests := []struct {
+		Name     string
+		Old      *Manifest
+		New      *Manifest
+		Shared   int
+		Removed  int
+		Added    int
+		Transfer []ByteRange
+	}{
+		{"identical", manifest(a, b, c), manifest(a, b, c), 3, 0, 0, []ByteRange{}},
+		{"appended", manifest(a, b), manifest(a, b, c, d), 2, 0, 2, []ByteRange{{20, 20}}},
+		{"replaced", manifest(a, b, c), manifest(a, d, c), 2, 1, 1, []ByteRange{{10, 10}}},
+		{"removed", manifest(a, b, c), manifest(a, c), 2, 1, 0, []ByteRange{}},
+		{"repeated", manifest(a), manifest(d, a, d, c), 1, 0, 3, []ByteRange{{0, 10}, {30, 10}}},
+		{"empty", manifest(), manifest(a, b), 0, 0, 2, []ByteRange{{0, 20}}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			diff, err := DiffManifests(tc.Old, tc.New)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diff.Shared) != tc.Shared {
+				t.Errorf("shared: want = %d, got = %d", tc.Shared, len(diff.Shared))
+			}
+			if len(diff.Removed) != tc.Removed {
+				t.Errorf("removed: want = %d, got = %d", tc.Removed, len(diff.Removed))
+			}
+			if len(diff.Added) != tc.Added {
+				t.Errorf("added: want = %d, got = %d", tc.Added, len(diff.Added))
+			}
+			if !reflect.DeepEqual(tc.Transfer, diff.Transfer) {
+				t.Errorf("transfer: want = %v, got = %v", tc.Transfer, diff.Transfer)
+			}
+			if diff.SharedBytes()+diff.TransferBytes() > tc.New.Size {
+				t.Errorf("shared and transferred bytes exceed the file size")
+			}
+		})
+	}
+
+	incompatible := manifest(a)
+	incompatible.AvgSize = 3
+	if _, err := DiffManifests(manifest(a), incompatible); !errors.Is(err, ErrIncompatibleManifest) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleManifest, err)
+	}
+}
+
+func TestDiffManifestsEdit(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := randomData(7, 1024*1024)
+	edited := append(append(append([]byte(nil), old[:500*1024]...), []byte("an edit in the middle")...), old[500*1024:]...)
+
+	oldManifest, err := BuildManifest(context.Background(), config, bytes.NewReader(old), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newManifest, err := BuildManifest(context.Background(), config, bytes.NewReader(edited), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffManifests(oldManifest, newManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the chunks around the edit change
+	if len(diff.Transfer) != 1 {
+		t.Errorf("transfer ranges: want = 1, got = %d", len(diff.Transfer))
+	}
+	if n := diff.TransferBytes(); n > 2*config.MaxSize() {
+		t.Errorf("transfer: want <= %d, got = %d", 2*config.MaxSize(), n)
+	}
+	if diff.SharedBytes()+diff.TransferBytes() != newManifest.Size {
+		t.Errorf("want = %d, got = %d", newManifest.Size, diff.SharedBytes()+diff.TransferBytes())
+	}
+}
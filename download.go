@@ -0,0 +1,178 @@
+package fastcdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+var ErrRangeNotSupported = errors.New("http range requests not supported")
+
+// DownloadStats summarize a ranged download.
+type DownloadStats struct {
+	Chunks       uint // Number of chunks of the file
+	Reused       uint // Number of chunks copied from the seeds
+	ReusedBytes  uint // Number of bytes copied from the seeds
+	Fetched      uint // Number of chunks downloaded
+	FetchedBytes uint // Number of bytes downloaded
+	Requests     uint // Number of range requests
+}
+
+// RangeDownloader download a file described by a manifest from an HTTP source supporting
+// range requests, reusing the chunks found in local seed files, like zsync. Only the missing
+// byte ranges are downloaded, and adjacent ranges are fetched with a single request.
+type RangeDownloader struct {
+	config *Config
+	client *http.Client
+}
+
+// NewRangeDownloader return a downloader splitting the seeds with a chunker session of
+// config. If client is nil, http.DefaultClient is used.
+func NewRangeDownloader(config *Config, client *http.Client) *RangeDownloader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RangeDownloader{
+		config: config,
+		client: client,
+	}
+}
+
+type seedChunk struct {
+	seed   *io.SectionReader
+	offset uint
+}
+
+// Download write to w the file described by the manifest. The seeds are split with the
+// configuration of the downloader, which must match the manifest or Download fail with
+// ErrIncompatibleManifest. Every chunk, from a seed or downloaded from url, is checked
+// against its digest before being written.
+func (d *RangeDownloader) Download(ctx context.Context, manifest *Manifest, url string, w io.Writer, seeds ...*io.SectionReader) (DownloadStats, error) {
+	stats := DownloadStats{Chunks: uint(len(manifest.Chunks))}
+	if err := manifest.Compatible(d.config); err != nil {
+		return stats, err
+	}
+
+	wanted := make(map[Digest]struct{}, len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		wanted[chunk.Digest] = struct{}{}
+	}
+	found := make(map[Digest]seedChunk)
+	for _, seed := range seeds {
+		if err := d.index(ctx, seed, wanted, found); err != nil {
+			return stats, err
+		}
+	}
+
+	for i := 0; i < len(manifest.Chunks); {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		chunk := manifest.Chunks[i]
+		if source, ok := found[chunk.Digest]; ok {
+			data := make([]byte, chunk.Length)
+			if _, err := source.seed.ReadAt(data, int64(source.offset)); err != nil && !(err == io.EOF && uint(len(data)) == chunk.Length) {
+				return stats, err
+			}
+			// A seed modified since it was split is not trusted anymore
+			if Sum(data) == chunk.Digest {
+				if _, err := w.Write(data); err != nil {
+					return stats, err
+				}
+				stats.Reused++
+				stats.ReusedBytes += chunk.Length
+				i++
+				continue
+			}
+			delete(found, chunk.Digest)
+		}
+
+		// Coalesce the following missing chunks into one range
+		j := i + 1
+		for j < len(manifest.Chunks) {
+			next := manifest.Chunks[j]
+			if _, ok := found[next.Digest]; ok || next.Offset != manifest.Chunks[j-1].Offset+manifest.Chunks[j-1].Length {
+				break
+			}
+			j++
+		}
+		if err := d.fetch(ctx, url, manifest.Chunks[i:j], w); err != nil {
+			return stats, err
+		}
+		stats.Requests++
+		for _, chunk := range manifest.Chunks[i:j] {
+			stats.Fetched++
+			stats.FetchedBytes += chunk.Length
+		}
+		i = j
+	}
+	return stats, nil
+}
+
+// index split the seed and record the offset of the wanted chunks.
+func (d *RangeDownloader) index(ctx context.Context, seed *io.SectionReader, wanted map[Digest]struct{}, found map[Digest]seedChunk) error {
+	chunker := d.config.NewChunker(ctx)
+	defer chunker.Release()
+
+	fn := func(offset, length uint, chunk []byte) error {
+		digest := Sum(chunk)
+		if _, ok := wanted[digest]; !ok {
+			return nil
+		}
+		if _, ok := found[digest]; !ok {
+			found[digest] = seedChunk{seed: seed, offset: offset}
+		}
+		return nil
+	}
+	if err := chunker.Split(io.NewSectionReader(seed, 0, seed.Size()), fn); err != nil {
+		return err
+	}
+	return chunker.Finalize(fn)
+}
+
+// fetch download the contiguous chunks with a single range request.
+func (d *RangeDownloader) fetch(ctx context.Context, url string, chunks []ManifestChunk, w io.Writer) error {
+	start := chunks[0].Offset
+	last := chunks[len(chunks)-1]
+	end := last.Offset + last.Length
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// Don't download the whole file to read a range
+		if resp.StatusCode == http.StatusOK {
+			return fmt.Errorf("GET %s: %w", url, ErrRangeNotSupported)
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s: %w", url, resp.Status, body, ErrUnexpectedStatus)
+	}
+	want := fmt.Sprintf("bytes %d-%d/", start, end-1)
+	if got := resp.Header.Get("Content-Range"); len(got) < len(want) || got[:len(want)] != want {
+		return fmt.Errorf("GET %s: want range %q, got %q: %w", url, want, got, ErrUnexpectedStatus)
+	}
+
+	for _, chunk := range chunks {
+		data := make([]byte, chunk.Length)
+		if _, err := io.ReadFull(resp.Body, data); err != nil {
+			return err
+		}
+		if Sum(data) != chunk.Digest {
+			return fmt.Errorf("chunk %s downloaded from %s: %w", chunk.Digest, url, ErrCorruptedChunk)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRangeDownloader(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote file share its beginning with a seed and its end with another seed
+	head, middle, tail := randomData(51, 400*1024), randomData(52, 100*1024), randomData(53, 300*1024)
+	remote := bytes.Join([][]byte{head, middle, tail}, nil)
+	manifest, err := BuildManifest(context.Background(), config, bytes.NewReader(remote), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, r, "remote.bin", time.Time{}, bytes.NewReader(remote))
+	}))
+	defer server.Close()
+
+	seeds := []*io.SectionReader{
+		io.NewSectionReader(bytes.NewReader(head), 0, int64(len(head))),
+		io.NewSectionReader(bytes.NewReader(append(randomData(54, 1000), tail...)), 0, int64(1000+len(tail))),
+	}
+	downloader := NewRangeDownloader(config, server.Client())
+	output := new(bytes.Buffer)
+	stats, err := downloader.Download(context.Background(), manifest, server.URL, output, seeds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(remote, output.Bytes()) {
+		t.Fatal("data mismatch")
+	}
+
+	if stats.Reused+stats.Fetched != stats.Chunks || stats.ReusedBytes+stats.FetchedBytes != manifest.Size {
+		t.Errorf("reused and fetched chunks doesn't add up: %+v", stats)
+	}
+	// Only the chunks around the middle part are fetched, with a single request
+	if stats.Requests != 1 || uint(atomic.LoadInt32(&requests)) != stats.Requests {
+		t.Errorf("requests: want = 1, got = %d (%d served)", stats.Requests, requests)
+	}
+	if stats.FetchedBytes > uint(len(middle))+2*config.MaxSize() {
+		t.Errorf("fetched: want <= %d, got = %d", uint(len(middle))+2*config.MaxSize(), stats.FetchedBytes)
+	}
+
+	// Without seeds the whole file is fetched at once
+	output.Reset()
+	stats, err = downloader.Download(context.Background(), manifest, server.URL, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 1 || stats.FetchedBytes != manifest.Size || !bytes.Equal(remote, output.Bytes()) {
+		t.Errorf("want the whole file in a single request, got = %+v", stats)
+	}
+}
+
+func TestRangeDownloaderErrors(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := randomData(55, 100*1024)
+	manifest, err := BuildManifest(context.Background(), config, bytes.NewReader(remote), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(remote)
+	}))
+	defer noRange.Close()
+	modified := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "remote.bin", time.Time{}, bytes.NewReader(randomData(56, len(remote))))
+	}))
+	defer modified.Close()
+
+	downloader := NewRangeDownloader(config, nil)
+	if _, err := downloader.Download(context.Background(), manifest, noRange.URL, new(bytes.Buffer)); !errors.Is(err, ErrRangeNotSupported) {
+		t.Errorf("want = %s, got = %s", ErrRangeNotSupported, err)
+	}
+	if _, err := downloader.Download(context.Background(), manifest, modified.URL, new(bytes.Buffer)); !errors.Is(err, ErrCorruptedChunk) {
+		t.Errorf("want = %s, got = %s", ErrCorruptedChunk, err)
+	}
+
+	other, err := NewConfig(With32kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRangeDownloader(other, nil).Download(context.Background(), manifest, modified.URL, new(bytes.Buffer)); !errors.Is(err, ErrIncompatibleManifest) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleManifest, err)
+	}
+}
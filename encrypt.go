@@ -0,0 +1,292 @@
+package fastcdc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid secret")
+	ErrInvalidKey    = errors.New("invalid chunk key")
+)
+
+// MinSecretSize is the minimum size of a tenant secret.
+const MinSecretSize = 16
+
+// maxWrappedKeySize bound the size of the wrapped keys read from a manifest.
+const maxWrappedKeySize = 256
+
+// Encryptor implement convergent encryption of chunks: each chunk is encrypted with AES-GCM
+// using a key derived from its digest and the tenant secret. The same chunk always produce
+// the same ciphertext for a tenant, so encrypted chunks are still deduplicated within a tenant.
+//
+// Chunk keys are wrapped with a key encryption key derived from the secret, and bound to
+// their chunk digest, so they can be recorded in manifests. Encryptor is safe for concurrent use.
+type Encryptor struct {
+	chunkKey   []byte
+	storageKey []byte
+	wrapper    cipher.AEAD
+	digests    cipher.AEAD
+}
+
+// NewEncryptor return an encryptor for the tenant secret, which must be at
+// least MinSecretSize bytes long.
+func NewEncryptor(secret []byte) (*Encryptor, error) {
+	if len(secret) < MinSecretSize {
+		return nil, fmt.Errorf("secret must be at least %d bytes: %w", MinSecretSize, ErrInvalidSecret)
+	}
+	wrapper, err := newGCM(deriveKey(secret, []byte("fastcdc key wrapping")))
+	if err != nil {
+		return nil, err
+	}
+	digests, err := newGCM(deriveKey(secret, []byte("fastcdc digest wrapping")))
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{
+		chunkKey:   deriveKey(secret, []byte("fastcdc chunk key")),
+		storageKey: deriveKey(secret, []byte("fastcdc storage key")),
+		wrapper:    wrapper,
+		digests:    digests,
+	}, nil
+}
+
+// ChunkKey return the encryption key of the chunk with the given digest.
+func (e *Encryptor) ChunkKey(digest Digest) []byte {
+	return deriveKey(e.chunkKey, digest[:])
+}
+
+// StorageDigest return the key under which the tenant store the chunk with the given
+// digest. It's derived from the digest and the tenant secret, so tenants sharing a
+// chunk store never collide and can't confirm the content of each other chunks.
+func (e *Encryptor) StorageDigest(digest Digest) Digest {
+	var storage Digest
+	copy(storage[:], deriveKey(e.storageKey, digest[:]))
+	return storage
+}
+
+// Encrypt return the encrypted chunk.
+func (e *Encryptor) Encrypt(digest Digest, chunk []byte) ([]byte, error) {
+	aead, err := newGCM(e.ChunkKey(digest))
+	if err != nil {
+		return nil, err
+	}
+	// A key is only ever used for a single plaintext, so the nonce
+	// can be fixed, which keep the encryption deterministic.
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(nil, nonce, chunk, digest[:]), nil
+}
+
+// Decrypt return the chunk decrypted with the given key, and check its digest.
+func (e *Encryptor) Decrypt(digest Digest, key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrInvalidKey)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	chunk, err := aead.Open(nil, nonce, data, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrCorruptedChunk)
+	}
+	if Sum(chunk) != digest {
+		return nil, fmt.Errorf("%s: digest mismatch: %w", digest, ErrCorruptedChunk)
+	}
+	return chunk, nil
+}
+
+// WrapKey return the key of the chunk encrypted and authenticated with the key encryption key.
+func (e *Encryptor) WrapKey(digest Digest) ([]byte, error) {
+	nonce := make([]byte, e.wrapper.NonceSize(), e.wrapper.NonceSize()+sha256.Size+e.wrapper.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return e.wrapper.Seal(nonce, nonce, e.ChunkKey(digest), digest[:]), nil
+}
+
+// UnwrapKey return the chunk key from its wrapped form. It fail with ErrInvalidKey
+// if the key was not wrapped by a tenant with the same secret for the same chunk.
+func (e *Encryptor) UnwrapKey(digest Digest, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < e.wrapper.NonceSize() {
+		return nil, fmt.Errorf("%s: wrapped key too short: %w", digest, ErrInvalidKey)
+	}
+	nonce, sealed := wrapped[:e.wrapper.NonceSize()], wrapped[e.wrapper.NonceSize():]
+	key, err := e.wrapper.Open(nil, nonce, sealed, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrInvalidKey)
+	}
+	return key, nil
+}
+
+// EncryptedStore encrypt the chunks on the way into a chunk store and decrypt them on the
+// way out. The chunks are stored under their tenant storage digest, not under the digest
+// of the plaintext chunk, so many tenants can share the same chunk store. Each record
+// start with the plaintext digest encrypted for the tenant, followed by the encrypted chunk.
+type EncryptedStore struct {
+	ChunkStore
+	encryptor *Encryptor
+}
+
+// NewEncryptedStore return a store encrypting the chunks with the encryptor.
+func NewEncryptedStore(store ChunkStore, encryptor *Encryptor) *EncryptedStore {
+	return &EncryptedStore{
+		ChunkStore: store,
+		encryptor:  encryptor,
+	}
+}
+
+// Put encrypt and store the chunk.
+func (s *EncryptedStore) Put(digest Digest, chunk []byte) error {
+	storage := s.encryptor.StorageDigest(digest)
+	aead := s.encryptor.digests
+	record := make([]byte, aead.NonceSize(), aead.NonceSize()+len(digest)+aead.Overhead()+len(chunk)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, record); err != nil {
+		return err
+	}
+	record = aead.Seal(record, record, digest[:], storage[:])
+
+	data, err := s.encryptor.Encrypt(digest, chunk)
+	if err != nil {
+		return err
+	}
+	return s.ChunkStore.Put(storage, append(record, data...))
+}
+
+// Get return the decrypted chunk, using the key derived from the tenant secret.
+func (s *EncryptedStore) Get(digest Digest) ([]byte, error) {
+	return s.get(digest, s.encryptor.ChunkKey(digest))
+}
+
+// Has report whether the tenant stored the chunk with the given digest.
+func (s *EncryptedStore) Has(digest Digest) (bool, error) {
+	return s.ChunkStore.Has(s.encryptor.StorageDigest(digest))
+}
+
+// Delete remove the chunk of the tenant with the given digest.
+func (s *EncryptedStore) Delete(digest Digest) error {
+	err := s.ChunkStore.Delete(s.encryptor.StorageDigest(digest))
+	if errors.Is(err, ErrChunkNotFound) {
+		return fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return err
+}
+
+// Walk call fn with the digest of every chunk stored by the tenant, in increasing digest
+// order. Every stored record is read to recover its plaintext digest, the records of the
+// other tenants are skipped. The underlying store must be a ChunkWalker.
+func (s *EncryptedStore) Walk(fn func(digest Digest) error) error {
+	walker, ok := s.ChunkStore.(ChunkWalker)
+	if !ok {
+		return ErrUnsupportedStore
+	}
+	var digests []Digest
+	err := walker.Walk(func(storage Digest) error {
+		record, err := s.ChunkStore.Get(storage)
+		if errors.Is(err, ErrChunkNotFound) {
+			// Deleted during the walk
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		digest, err := s.openDigest(storage, record)
+		if err != nil {
+			return nil
+		}
+		digests = append(digests, digest)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkDigests(digests, fn)
+}
+
+// GetChunk return the decrypted chunk, using the wrapped key recorded in the manifest.
+func (s *EncryptedStore) GetChunk(chunk ManifestChunk) ([]byte, error) {
+	if len(chunk.Key) == 0 {
+		return nil, fmt.Errorf("%s: missing key: %w", chunk.Digest, ErrInvalidKey)
+	}
+	key, err := s.encryptor.UnwrapKey(chunk.Digest, chunk.Key)
+	if err != nil {
+		return nil, err
+	}
+	return s.get(chunk.Digest, key)
+}
+
+func (s *EncryptedStore) get(digest Digest, key []byte) ([]byte, error) {
+	storage := s.encryptor.StorageDigest(digest)
+	record, err := s.ChunkStore.Get(storage)
+	if errors.Is(err, ErrChunkNotFound) {
+		return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.openDigest(storage, record)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", digest, err, ErrCorruptedChunk)
+	}
+	if stored != digest {
+		return nil, fmt.Errorf("%s: digest mismatch: %w", digest, ErrCorruptedChunk)
+	}
+	return s.encryptor.Decrypt(digest, key, record[s.headerSize():])
+}
+
+// openDigest decrypt the plaintext digest at the start of the record stored under storage.
+func (s *EncryptedStore) openDigest(storage Digest, record []byte) (Digest, error) {
+	var digest Digest
+	aead := s.encryptor.digests
+	if len(record) < s.headerSize() {
+		return digest, errors.New("record too short")
+	}
+	nonce, sealed := record[:aead.NonceSize()], record[aead.NonceSize():s.headerSize()]
+	plain, err := aead.Open(nil, nonce, sealed, storage[:])
+	if err != nil {
+		return digest, err
+	}
+	copy(digest[:], plain)
+	return digest, nil
+}
+
+// headerSize return the size of the encrypted digest starting every record.
+func (s *EncryptedStore) headerSize() int {
+	aead := s.encryptor.digests
+	return aead.NonceSize() + len(Digest{}) + aead.Overhead()
+}
+
+// ManifestChunkFn return a ChunkFn encrypting and storing every chunk found by the split
+// function, and adding it to the manifest along with its wrapped key.
+func (s *EncryptedStore) ManifestChunkFn(manifest *Manifest) ChunkFn {
+	return func(offset, length uint, chunk []byte) error {
+		digest := Sum(chunk)
+		if err := s.Put(digest, chunk); err != nil {
+			return err
+		}
+		key, err := s.encryptor.WrapKey(digest)
+		if err != nil {
+			return err
+		}
+		manifest.add(ManifestChunk{Offset: offset, Length: length, Digest: digest, Key: key})
+		return nil
+	}
+}
+
+func deriveKey(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,201 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	alice, err := NewEncryptor([]byte("alice secret 0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewEncryptor([]byte("bob secret 0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryStore()
+	store := NewEncryptedStore(backend, alice)
+	manifest := NewManifest(config)
+	chunker := config.NewChunker(context.Background())
+	fn := store.ManifestChunkFn(manifest)
+	if err := chunker.Split(bytes.NewReader(data), fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := chunker.Finalize(fn); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Size != uint(len(data)) {
+		t.Errorf("size: want = %d, got = %d", len(data), manifest.Size)
+	}
+
+	output := make([]byte, 0, len(data))
+	for _, chunk := range manifest.Chunks {
+		stored, err := backend.Get(alice.StorageDigest(chunk.Digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := data[chunk.Offset : chunk.Offset+chunk.Length]
+		if bytes.Contains(stored, plain[:64]) {
+			t.Errorf("chunk %s stored in plaintext", chunk.Digest)
+		}
+
+		got, err := store.GetChunk(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, got...)
+	}
+	if !bytes.Equal(data, output) {
+		t.Error("data mismatch")
+	}
+
+	// Encryption is deterministic for a tenant, so chunks are deduplicated
+	chunk := data[:manifest.Chunks[0].Length]
+	first, _ := alice.Encrypt(manifest.Chunks[0].Digest, chunk)
+	second, _ := alice.Encrypt(manifest.Chunks[0].Digest, chunk)
+	if !bytes.Equal(first, second) {
+		t.Error("encryption is not deterministic")
+	}
+	other, _ := bob.Encrypt(manifest.Chunks[0].Digest, chunk)
+	if bytes.Equal(first, other) {
+		t.Error("tenants share the same ciphertext")
+	}
+
+	// The manifest can be read back through a ManifestReader
+	reader := NewManifestReader(manifest, store, 2)
+	output, err = ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, output) {
+		t.Error("data mismatch")
+	}
+
+	// Other tenants can neither unwrap the keys nor find the chunks
+	if _, err := NewEncryptedStore(backend, bob).GetChunk(manifest.Chunks[0]); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("want = %s, got = %s", ErrInvalidKey, err)
+	}
+	if _, err := NewEncryptedStore(backend, bob).Get(manifest.Chunks[0].Digest); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+
+	// Keys are bound to their chunk
+	swapped := manifest.Chunks[1]
+	swapped.Key = manifest.Chunks[0].Key
+	if _, err := store.GetChunk(swapped); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("want = %s, got = %s", ErrInvalidKey, err)
+	}
+
+	// Tampered chunks are detected
+	digest := manifest.Chunks[0].Digest
+	// Both the encrypted digest and the encrypted chunk are authenticated
+	for _, i := range []int{0, store.headerSize() + 1} {
+		stored, _ := backend.Get(alice.StorageDigest(digest))
+		tampered := append([]byte(nil), stored...)
+		tampered[i] ^= 0xff
+		backend.Delete(alice.StorageDigest(digest))
+		backend.Put(alice.StorageDigest(digest), tampered)
+		if _, err := store.Get(digest); !errors.Is(err, ErrCorruptedChunk) {
+			t.Errorf("byte %d: want = %s, got = %s", i, ErrCorruptedChunk, err)
+		}
+		backend.Delete(alice.StorageDigest(digest))
+		backend.Put(alice.StorageDigest(digest), stored)
+	}
+
+	if _, err := NewEncryptor([]byte("short")); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("want = %s, got = %s", ErrInvalidSecret, err)
+	}
+}
+
+func TestEncryptedStoreTenants(t *testing.T) {
+	alice, err := NewEncryptor([]byte("alice secret 0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewEncryptor([]byte("bob secret 0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewMemoryStore()
+	aliceStore := NewEncryptedStore(backend, alice)
+	bobStore := NewEncryptedStore(backend, bob)
+
+	shared := randomData(1, 4096)
+	private := randomData(2, 4096)
+	for _, chunk := range [][]byte{shared, private} {
+		if err := aliceStore.Put(Sum(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Storing a chunk already stored by another tenant must not lose it
+	if err := bobStore.Put(Sum(shared), shared); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]*EncryptedStore{"alice": aliceStore, "bob": bobStore} {
+		got, err := store.Get(Sum(shared))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !bytes.Equal(shared, got) {
+			t.Errorf("%s: data mismatch", name)
+		}
+	}
+
+	// A tenant can't confirm the content of the chunks of another tenant
+	for _, digest := range []Digest{Sum(private), Sum(shared)} {
+		if _, err := backend.Get(digest); !errors.Is(err, ErrChunkNotFound) {
+			t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+		}
+	}
+	if ok, err := bobStore.Has(Sum(private)); err != nil || ok {
+		t.Errorf("want = false, got = %t (%v)", ok, err)
+	}
+	if ok, err := aliceStore.Has(Sum(private)); err != nil || !ok {
+		t.Errorf("want = true, got = %t (%v)", ok, err)
+	}
+
+	// Walk only report the chunks of the tenant
+	walk := func(store *EncryptedStore) map[Digest]bool {
+		digests := map[Digest]bool{}
+		if err := store.Walk(func(digest Digest) error {
+			digests[digest] = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return digests
+	}
+	if got := walk(aliceStore); len(got) != 2 || !got[Sum(shared)] || !got[Sum(private)] {
+		t.Errorf("alice walk: want = 2 chunks, got = %v", got)
+	}
+	if got := walk(bobStore); len(got) != 1 || !got[Sum(shared)] {
+		t.Errorf("bob walk: want = 1 chunk, got = %v", got)
+	}
+
+	// Deleting a chunk of a tenant keep the copy of the other tenant
+	if err := bobStore.Delete(Sum(shared)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aliceStore.Get(Sum(shared)); err != nil {
+		t.Error(err)
+	}
+	if err := bobStore.Delete(Sum(shared)); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+}
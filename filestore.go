@@ -0,0 +1,149 @@
+package fastcdc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore is a ChunkStore keeping each chunk in its own file on the local filesystem.
+// To keep the directories small, chunks are spread in 256 fan-out directories named
+// after the first byte of their digest. Chunks are written atomically, a chunk is either
+// completely stored or not stored at all.
+type FileStore struct {
+	root string
+}
+
+// NewFileStore return a FileStore rooted at the given directory. The
+// directory is created if it does not exist.
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{root: root}, nil
+}
+
+// Put store the chunk in a temporary file and rename it to its final
+// location once the content is synced to the disk.
+func (s *FileStore) Put(digest Digest, chunk []byte) error {
+	path := s.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+}
+
+// Replace store the chunk like Put, renaming the temporary file over the stored chunk if any.
+func (s *FileStore) Replace(digest Digest, chunk []byte) error {
+	path := s.path(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(chunk)
+		return err
+	})
+}
+
+// Get return the chunk with the given digest.
+func (s *FileStore) Get(digest Digest) ([]byte, error) {
+	chunk, err := ioutil.ReadFile(s.path(digest))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return chunk, err
+}
+
+// Has report whether the chunk with the given digest is stored.
+func (s *FileStore) Has(digest Digest) (bool, error) {
+	_, err := os.Stat(s.path(digest))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// Delete remove the chunk with the given digest.
+func (s *FileStore) Delete(digest Digest) error {
+	err := os.Remove(s.path(digest))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return err
+}
+
+// Walk call fn with the digest of every stored chunk, in increasing digest order.
+func (s *FileStore) Walk(fn func(digest Digest) error) error {
+	dirs, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	// Directories and files are sorted by name, which
+	// is also the order of the digests.
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.root, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			digest, err := ParseDigest(file.Name())
+			if err != nil {
+				// Skip the temporary files
+				continue
+			}
+			if err := fn(digest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(digest Digest) string {
+	name := digest.String()
+	return filepath.Join(s.root, name[:2], name)
+}
+
+// writeFileAtomic call write with a temporary file in the directory
+// of path and rename it to path once synced to the disk.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fail once renamed
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,300 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var (
+	ErrUnsupportedStore  = errors.New("chunk store does not support walking")
+	ErrCollectionRunning = errors.New("garbage collection already running")
+	ErrInvalidCheckpoint = errors.New("invalid garbage collection checkpoint")
+)
+
+// Repacker is implemented by the chunk stores able to reclaim the space of deleted chunks,
+// like the PackStore.
+type Repacker interface {
+	Repack(threshold float64) (int, error)
+}
+
+// GCReport summarize a garbage collection.
+type GCReport struct {
+	Manifests     uint // Number of manifests marked
+	LiveChunks    uint // Number of distinct chunks referenced by the manifests
+	Scanned       uint // Number of stored chunks visited by the sweep
+	Deleted       uint // Number of deleted chunks
+	Protected     uint // Number of unreferenced chunks kept because written during the collection
+	RepackedPacks int  // Number of compacted packs
+	Resumed       bool // Whether the collection resumed from a checkpoint
+}
+
+// checkpointInterval is the number of chunks swept between two checkpoints.
+const checkpointInterval = 1024
+
+const (
+	gcPhaseSweep  = "sweep"
+	gcPhaseRepack = "repack"
+)
+
+type gcCheckpoint struct {
+	Phase  string `json:"phase"`
+	Cursor Digest `json:"cursor"`
+}
+
+// Collector is a mark-and-sweep garbage collector deleting the chunks not referenced by any
+// manifest. The chunks and manifests written through the Collector during a collection are
+// never collected, so writers can keep running while a collection is in progress.
+//
+// The collection progress is saved in a checkpoint file, an interrupted collection is
+// resumed by the next call to Collect.
+type Collector struct {
+	store      ChunkStore
+	walker     ChunkWalker
+	manifests  ManifestStore
+	checkpoint string
+
+	mu        sync.Mutex
+	running   bool
+	protected map[Digest]struct{}
+}
+
+// NewCollector return a Collector for the given stores. The chunk store must implement
+// ChunkWalker. If checkpoint is empty, interrupted collections start over.
+func NewCollector(store ChunkStore, manifests ManifestStore, checkpoint string) (*Collector, error) {
+	walker, ok := store.(ChunkWalker)
+	if !ok {
+		return nil, ErrUnsupportedStore
+	}
+	return &Collector{
+		store:      store,
+		walker:     walker,
+		manifests:  manifests,
+		checkpoint: checkpoint,
+	}, nil
+}
+
+// protect keep the chunk from being collected by the running collection.
+// Must be called with c.mu held.
+func (c *Collector) protect(digest Digest) {
+	if c.running {
+		c.protected[digest] = struct{}{}
+	}
+}
+
+// Put store the chunk. The chunk is not collected by the running collection.
+func (c *Collector) Put(digest Digest, chunk []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protect(digest)
+	return c.store.Put(digest, chunk)
+}
+
+// Get return the chunk with the given digest.
+func (c *Collector) Get(digest Digest) ([]byte, error) {
+	return c.store.Get(digest)
+}
+
+// Has report whether the chunk is stored. A stored chunk is not collected by the
+// running collection, so callers skipping the upload of stored chunks are safe.
+func (c *Collector) Has(digest Digest) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ok, err := c.store.Has(digest)
+	if ok {
+		c.protect(digest)
+	}
+	return ok, err
+}
+
+// Delete remove the chunk with the given digest.
+func (c *Collector) Delete(digest Digest) error {
+	return c.store.Delete(digest)
+}
+
+// PutManifest store the manifest after checking that all its chunks are stored,
+// otherwise it return ErrChunkNotFound and the chunks must be stored again.
+func (c *Collector) PutManifest(name string, manifest *Manifest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, chunk := range manifest.Chunks {
+		ok, err := c.store.Has(chunk.Digest)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s: %w", chunk.Digest, ErrChunkNotFound)
+		}
+		c.protect(chunk.Digest)
+	}
+	return c.manifests.PutManifest(name, manifest)
+}
+
+// GetManifest return the manifest with the given name.
+func (c *Collector) GetManifest(name string) (*Manifest, error) {
+	return c.manifests.GetManifest(name)
+}
+
+// DeleteManifest remove the manifest, its chunks are collected by the
+// next collection if no other manifest reference them.
+func (c *Collector) DeleteManifest(name string) error {
+	return c.manifests.DeleteManifest(name)
+}
+
+// WalkManifests call fn with every stored manifest in name order.
+func (c *Collector) WalkManifests(fn func(name string, manifest *Manifest) error) error {
+	return c.manifests.WalkManifests(fn)
+}
+
+// Collect delete every chunk not referenced by a manifest. If the chunk store implements
+// Repacker and repackThreshold is positive, the packs with at least this fraction of
+// deleted bytes are then compacted.
+func (c *Collector) Collect(ctx context.Context, repackThreshold float64) (GCReport, error) {
+	var report GCReport
+
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return report, ErrCollectionRunning
+	}
+	c.running = true
+	c.protected = make(map[Digest]struct{})
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.running = false
+		c.protected = nil
+		c.mu.Unlock()
+	}()
+
+	checkpoint, err := c.loadCheckpoint()
+	if err != nil {
+		return report, err
+	}
+	report.Resumed = checkpoint != nil
+
+	// Mark, always done again on resume since the manifests may have changed
+	live := make(map[Digest]struct{})
+	err = c.manifests.WalkManifests(func(name string, manifest *Manifest) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Manifests++
+		for _, chunk := range manifest.Chunks {
+			live[chunk.Digest] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.LiveChunks = uint(len(live))
+
+	// Sweep
+	if checkpoint == nil || checkpoint.Phase == gcPhaseSweep {
+		if err := c.sweep(ctx, live, checkpoint, &report); err != nil {
+			return report, err
+		}
+	}
+
+	// Repack
+	if repacker, ok := c.store.(Repacker); ok && repackThreshold > 0 {
+		if err := c.saveCheckpoint(gcCheckpoint{Phase: gcPhaseRepack}); err != nil {
+			return report, err
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.RepackedPacks, err = repacker.Repack(repackThreshold)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, c.removeCheckpoint()
+}
+
+func (c *Collector) sweep(ctx context.Context, live map[Digest]struct{}, checkpoint *gcCheckpoint, report *GCReport) error {
+	resume := checkpoint != nil
+	err := c.walker.Walk(func(digest Digest) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Chunks up to the cursor were swept before the interruption
+		if resume && bytes.Compare(digest[:], checkpoint.Cursor[:]) <= 0 {
+			return nil
+		}
+		report.Scanned++
+
+		if _, ok := live[digest]; !ok {
+			c.mu.Lock()
+			if _, ok := c.protected[digest]; ok {
+				report.Protected++
+			} else {
+				err := c.store.Delete(digest)
+				if err != nil && !errors.Is(err, ErrChunkNotFound) {
+					c.mu.Unlock()
+					return err
+				}
+				if err == nil {
+					report.Deleted++
+				}
+			}
+			c.mu.Unlock()
+		}
+
+		if report.Scanned%checkpointInterval == 0 {
+			return c.saveCheckpoint(gcCheckpoint{Phase: gcPhaseSweep, Cursor: digest})
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *Collector) loadCheckpoint() (*gcCheckpoint, error) {
+	if c.checkpoint == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.checkpoint)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var checkpoint gcCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidCheckpoint)
+	}
+	if checkpoint.Phase != gcPhaseSweep && checkpoint.Phase != gcPhaseRepack {
+		return nil, fmt.Errorf("unknown phase %q: %w", checkpoint.Phase, ErrInvalidCheckpoint)
+	}
+	return &checkpoint, nil
+}
+
+func (c *Collector) saveCheckpoint(checkpoint gcCheckpoint) error {
+	if c.checkpoint == "" {
+		return nil
+	}
+	return writeFileAtomic(c.checkpoint, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(checkpoint)
+	})
+}
+
+func (c *Collector) removeCheckpoint() error {
+	if c.checkpoint == "" {
+		return nil
+	}
+	err := os.Remove(c.checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
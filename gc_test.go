@@ -0,0 +1,248 @@
+package fastcdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollector(t *testing.T) {
+	chunks, err := NewFileStore(filepath.Join(t.TempDir(), "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector, err := NewCollector(chunks, NewMemoryManifestStore(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, collector)
+	if err := collector.PutManifest("sekien", manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unreferenced chunks
+	for i := 0; i < 10; i++ {
+		chunk := randomData(i, 1024)
+		if err := collector.Put(Sum(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := collector.Collect(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GCReport{Manifests: 1, LiveChunks: 6, Scanned: 16, Deleted: 10}
+	if report != want {
+		t.Errorf("want = %+v, got = %+v", want, report)
+	}
+	for _, chunk := range manifest.Chunks {
+		if ok, _ := chunks.Has(chunk.Digest); !ok {
+			t.Errorf("live chunk %s deleted", chunk.Digest)
+		}
+	}
+
+	// Chunks of deleted manifests are collected
+	if err := collector.DeleteManifest("sekien"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = collector.Collect(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 6 {
+		t.Errorf("deleted: want = 6, got = %d", report.Deleted)
+	}
+
+	// A manifest can't reference collected chunks
+	if err := collector.PutManifest("sekien", manifest); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+
+	if _, err := NewCollector(struct{ ChunkStore }{chunks}, NewMemoryManifestStore(), ""); !errors.Is(err, ErrUnsupportedStore) {
+		t.Errorf("want = %s, got = %s", ErrUnsupportedStore, err)
+	}
+}
+
+func TestCollectorConcurrentWrites(t *testing.T) {
+	chunks := NewMemoryStore()
+	collector, err := NewCollector(chunks, NewMemoryManifestStore(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := randomData(1, 1024)
+	if err := chunks.Put(Sum(stored), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks written or found during the collection are kept until their manifest is stored
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	written := randomData(2, 1024)
+	var walked bool
+	collector.walker = walkerFunc(func(fn func(digest Digest) error) error {
+		if !walked {
+			walked = true
+			if err := collector.Put(Sum(written), written); err != nil {
+				return err
+			}
+			if _, err := collector.Has(Sum(stored)); err != nil {
+				return err
+			}
+		}
+		return chunks.Walk(fn)
+	})
+
+	report, err := collector.Collect(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Protected != 2 || report.Deleted != 0 {
+		t.Errorf("want = 2 protected and 0 deleted, got = %+v", report)
+	}
+	if chunks.Len() != 2 {
+		t.Errorf("chunks: want = 2, got = %d", chunks.Len())
+	}
+
+	// Protection only last for one collection
+	if _, err := collector.Collect(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if chunks.Len() != 0 {
+		t.Errorf("chunks: want = 0, got = %d", chunks.Len())
+	}
+}
+
+type walkerFunc func(fn func(digest Digest) error) error
+
+func (w walkerFunc) Walk(fn func(digest Digest) error) error {
+	return w(fn)
+}
+
+func TestCollectorResume(t *testing.T) {
+	chunks := NewMemoryStore()
+	checkpoint := filepath.Join(t.TempDir(), "gc.json")
+	collector, err := NewCollector(chunks, NewMemoryManifestStore(), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digests := make([]Digest, 0)
+	for i := 0; i < 3*checkpointInterval; i++ {
+		chunk := randomData(i, 16)
+		if err := chunks.Put(Sum(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, Sum(chunk))
+	}
+
+	// Interrupt the sweep after the first checkpoint
+	errInterrupted := errors.New("interrupted")
+	visited := 0
+	collector.walker = walkerFunc(func(fn func(digest Digest) error) error {
+		return chunks.Walk(func(digest Digest) error {
+			if visited == checkpointInterval+10 {
+				return errInterrupted
+			}
+			visited++
+			return fn(digest)
+		})
+	})
+	if _, err := collector.Collect(context.Background(), 0); !errors.Is(err, errInterrupted) {
+		t.Fatalf("want = %s, got = %s", errInterrupted, err)
+	}
+
+	data, err := ioutil.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved gcCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Phase != gcPhaseSweep {
+		t.Errorf("phase: want = %s, got = %s", gcPhaseSweep, saved.Phase)
+	}
+
+	collector.walker = chunks
+	report, err := collector.Collect(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Resumed {
+		t.Error("collection not resumed")
+	}
+	if want := uint(2*checkpointInterval - 10); report.Scanned != want {
+		t.Errorf("scanned: want = %d, got = %d", want, report.Scanned)
+	}
+	if chunks.Len() != 0 {
+		t.Errorf("chunks: want = 0, got = %d", chunks.Len())
+	}
+	if _, err := ioutil.ReadFile(checkpoint); err == nil {
+		t.Error("checkpoint not removed")
+	}
+}
+
+func TestCollectorRepack(t *testing.T) {
+	root := t.TempDir()
+	chunks, err := NewPackStore(root, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chunks.Close()
+	collector, err := NewCollector(chunks, NewMemoryManifestStore(), filepath.Join(root, "gc.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &Manifest{}
+	for i := 0; i < 32; i++ {
+		chunk := randomData(i, 8192)
+		if err := collector.Put(Sum(chunk), chunk); err != nil {
+			t.Fatal(err)
+		}
+		// Keep one chunk out of four
+		if i%4 == 0 {
+			manifest.Chunks = append(manifest.Chunks, ManifestChunk{Offset: manifest.Size, Length: 8192, Digest: Sum(chunk)})
+			manifest.Size += 8192
+		}
+	}
+	if err := chunks.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := collector.PutManifest("sparse", manifest); err != nil {
+		t.Fatal(err)
+	}
+	if n := countPacks(t, root); n != 4 {
+		t.Fatalf("packs: want = 4, got = %d", n)
+	}
+
+	report, err := collector.Collect(context.Background(), 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 24 || report.RepackedPacks != 4 {
+		t.Errorf("want = 24 deleted and 4 repacked, got = %+v", report)
+	}
+	if n := countPacks(t, root); n != 1 {
+		t.Errorf("packs: want = 1, got = %d", n)
+	}
+	for _, chunk := range manifest.Chunks {
+		data, err := chunks.Get(chunk.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Sum(data) != chunk.Digest {
+			t.Errorf("chunk %s mismatch", chunk.Digest)
+		}
+	}
+}
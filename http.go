@@ -0,0 +1,415 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrUnexpectedStatus = errors.New("unexpected http status")
+
+var errDeleteUnsupported = errors.New("delete not supported")
+
+const (
+	chunksPath    = "/chunks/"
+	manifestsPath = "/manifests/"
+)
+
+// DefaultMaxManifestBodySize is the default limit of the size of a posted manifest.
+// Manifests are decoded in memory, and JSON manifests are several times larger than
+// binary ones, so the limit should be raised for files of millions of chunks.
+const DefaultMaxManifestBodySize = 64 * 1024 * 1024
+
+// MissingChunks is the response to a manifest upload.
+type MissingChunks struct {
+	Missing []Digest `json:"missing"`
+}
+
+// Handler expose a chunk store and a manifest store over HTTP:
+//
+//	HEAD /chunks/{digest}    report whether the chunk is stored (200 or 404)
+//	GET  /chunks/{digest}    download the chunk
+//	PUT  /chunks/{digest}    upload the chunk, which must match its digest
+//	POST /manifests/{name}   upload a manifest in any format, reply with the missing chunks
+//	GET  /manifests/{name}   download the manifest in the binary format
+//
+// A posted manifest is only stored when none of its chunks are missing, the missing
+// chunks must be uploaded and the manifest posted again.
+//
+// Request bodies are read in memory, so their size is limited: uploaded chunks can't
+// be larger than the max size of the configuration, and posted manifests than
+// DefaultMaxManifestBodySize, unless changed with the handler options.
+type Handler struct {
+	store           ChunkStore
+	manifests       ManifestStore
+	maxChunkSize    int64
+	maxManifestSize int64
+}
+
+// HandlerOption configure a Handler.
+type HandlerOption func(*Handler)
+
+// WithMaxChunkBodySize set the maximum size of an uploaded chunk.
+func WithMaxChunkBodySize(size uint) HandlerOption {
+	return func(h *Handler) {
+		h.maxChunkSize = int64(size)
+	}
+}
+
+// WithMaxManifestBodySize set the maximum size of a posted manifest.
+func WithMaxManifestBodySize(size uint) HandlerOption {
+	return func(h *Handler) {
+		h.maxManifestSize = int64(size)
+	}
+}
+
+// NewHandler return a handler for the stores, accepting chunks up to the max size of config.
+func NewHandler(config *Config, store ChunkStore, manifests ManifestStore, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		store:           store,
+		manifests:       manifests,
+		maxChunkSize:    int64(config.MaxSize()),
+		maxManifestSize: DefaultMaxManifestBodySize,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP implement http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, chunksPath):
+		digest, err := ParseDigest(strings.TrimPrefix(r.URL.Path, chunksPath))
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		h.serveChunk(w, r, digest)
+	case strings.HasPrefix(r.URL.Path, manifestsPath):
+		name := strings.TrimPrefix(r.URL.Path, manifestsPath)
+		if name == "" {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveManifest(w, r, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) serveChunk(w http.ResponseWriter, r *http.Request, digest Digest) {
+	switch r.Method {
+	case http.MethodHead:
+		ok, err := h.store.Has(digest)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		chunk, err := h.store.Get(digest)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(chunk)
+	case http.MethodPut:
+		chunk, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.maxChunkSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if Sum(chunk) != digest {
+			http.Error(w, "chunk digest mismatch", http.StatusBadRequest)
+			return
+		}
+		if err := h.store.Put(digest, chunk); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "HEAD, GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) serveManifest(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		manifest, err := h.manifests.GetManifest(name)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		data, err := manifest.MarshalBinary()
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.maxManifestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		manifest, err := DecodeManifest(bytes.NewReader(data))
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		response := MissingChunks{Missing: make([]Digest, 0)}
+		seen := make(map[Digest]struct{})
+		for _, chunk := range manifest.Chunks {
+			if _, ok := seen[chunk.Digest]; ok {
+				continue
+			}
+			seen[chunk.Digest] = struct{}{}
+			ok, err := h.store.Has(chunk.Digest)
+			if err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			if !ok {
+				response.Missing = append(response.Missing, chunk.Digest)
+			}
+		}
+
+		status := http.StatusOK
+		if len(response.Missing) == 0 {
+			if err := h.manifests.PutManifest(name, manifest); err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			status = http.StatusCreated
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrChunkNotFound), errors.Is(err, ErrManifestNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidDigest), errors.Is(err, ErrInvalidManifest), errors.Is(err, ErrUnsupportedManifestVersion):
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// Client upload and download files to and from a Handler.
+type Client struct {
+	baseURL string
+	client  *http.Client
+	config  *Config
+}
+
+// NewClient return a client for the handler served at baseURL, splitting the files with the
+// given options. If client is nil, http.DefaultClient is used.
+func NewClient(baseURL string, client *http.Client, opts ...Option) (*Client, error) {
+	config, err := NewConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+		config:  config,
+	}, nil
+}
+
+// Upload split the file and upload it under name, sending only the chunks the server
+// doesn't have. The manifest is stored once all the chunks are uploaded.
+func (c *Client) Upload(ctx context.Context, name string, file io.ReaderAt, size int64) (SyncStats, error) {
+	var stats SyncStats
+	chunker := c.config.NewChunker(ctx)
+	defer chunker.Release()
+
+	manifest := NewManifest(c.config)
+	fn := func(offset, length uint, chunk []byte) error {
+		return manifest.Add(offset, length, Sum(chunk))
+	}
+	if err := chunker.Split(io.NewSectionReader(file, 0, size), fn); err != nil {
+		return stats, err
+	}
+	if err := chunker.Finalize(fn); err != nil {
+		return stats, err
+	}
+	stats.Chunks = uint(len(manifest.Chunks))
+
+	chunks := make(map[Digest]ManifestChunk, len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		chunks[chunk.Digest] = chunk
+	}
+
+	missing, err := c.postManifest(ctx, name, manifest)
+	if err != nil || len(missing) == 0 {
+		return stats, err
+	}
+	for _, digest := range missing {
+		chunk, ok := chunks[digest]
+		if !ok {
+			return stats, fmt.Errorf("server requested unknown chunk %s: %w", digest, ErrUnexpectedStatus)
+		}
+		data := make([]byte, chunk.Length)
+		if _, err := file.ReadAt(data, int64(chunk.Offset)); err != nil && !(err == io.EOF && uint(len(data)) == chunk.Length) {
+			return stats, err
+		}
+		if Sum(data) != digest {
+			return stats, fmt.Errorf("chunk %s: file modified since split: %w", digest, ErrCorruptedChunk)
+		}
+		if err := c.putChunk(ctx, digest, data); err != nil {
+			return stats, err
+		}
+		stats.Sent++
+		stats.SentBytes += chunk.Length
+	}
+
+	missing, err = c.postManifest(ctx, name, manifest)
+	if err != nil {
+		return stats, err
+	}
+	if len(missing) > 0 {
+		return stats, fmt.Errorf("%d chunks still missing after upload: %w", len(missing), ErrChunkNotFound)
+	}
+	return stats, nil
+}
+
+// Download write the file stored under name to w. Every chunk is checked against its digest.
+func (c *Client) Download(ctx context.Context, name string, w io.Writer) error {
+	body, err := c.do(ctx, http.MethodGet, c.baseURL+manifestsPath+url.PathEscape(name), nil, ErrManifestNotFound, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	manifest := new(Manifest)
+	if err := manifest.UnmarshalBinary(body); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, NewManifestReader(manifest, c.Store(ctx), 0))
+	return err
+}
+
+// Has report whether the server has the chunk.
+func (c *Client) Has(ctx context.Context, digest Digest) (bool, error) {
+	_, err := c.do(ctx, http.MethodHead, c.chunkURL(digest), nil, ErrChunkNotFound, http.StatusOK)
+	if errors.Is(err, ErrChunkNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get download the chunk.
+func (c *Client) Get(ctx context.Context, digest Digest) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, c.chunkURL(digest), nil, ErrChunkNotFound, http.StatusOK)
+}
+
+// Store return a ChunkStore backed by the server, using ctx for all requests.
+// Deleting chunks is not supported.
+func (c *Client) Store(ctx context.Context) ChunkStore {
+	return &clientStore{ctx: ctx, client: c}
+}
+
+func (c *Client) putChunk(ctx context.Context, digest Digest, chunk []byte) error {
+	_, err := c.do(ctx, http.MethodPut, c.chunkURL(digest), chunk, ErrChunkNotFound, http.StatusNoContent)
+	return err
+}
+
+func (c *Client) postManifest(ctx context.Context, name string, manifest *Manifest) ([]Digest, error) {
+	data, err := manifest.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.do(ctx, http.MethodPost, c.baseURL+manifestsPath+url.PathEscape(name), data, ErrManifestNotFound, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	var response MissingChunks
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return response.Missing, nil
+}
+
+func (c *Client) chunkURL(digest Digest) string {
+	return c.baseURL + chunksPath + digest.String()
+}
+
+// do send the request and return the response body if the status is one of the
+// expected status. A 404 status is reported with the notFound error.
+func (c *Client) do(ctx context.Context, method, target string, body []byte, notFound error, expected ...int) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return data, nil
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s: %w", method, target, notFound)
+	}
+	return nil, fmt.Errorf("%s %s: %s: %s: %w", method, target, resp.Status, bytes.TrimSpace(data), ErrUnexpectedStatus)
+}
+
+// clientStore adapt a Client to the ChunkStore interface.
+type clientStore struct {
+	ctx    context.Context
+	client *Client
+}
+
+func (s *clientStore) Put(digest Digest, chunk []byte) error {
+	return s.client.putChunk(s.ctx, digest, chunk)
+}
+
+func (s *clientStore) Get(digest Digest) ([]byte, error) {
+	return s.client.Get(s.ctx, digest)
+}
+
+func (s *clientStore) Has(digest Digest) (bool, error) {
+	return s.client.Has(s.ctx, digest)
+}
+
+func (s *clientStore) Delete(digest Digest) error {
+	return fmt.Errorf("%s: %w", digest, errDeleteUnsupported)
+}
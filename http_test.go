@@ -0,0 +1,126 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPClient(t *testing.T) {
+	chunks := NewMemoryStore()
+	manifests := NewMemoryManifestStore()
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(config, chunks, manifests))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, server.Client(), With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	data := randomData(31, 1024*1024)
+	stats, err := client.Upload(ctx, "releases/v1.bin", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sent != stats.Chunks || stats.SentBytes != uint(len(data)) {
+		t.Errorf("want all %d chunks sent, got = %+v", stats.Chunks, stats)
+	}
+	if _, err := manifests.GetManifest("releases/v1.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the chunks around the edit are uploaded for a new version
+	edited := append(append(append([]byte(nil), data[:400000]...), "an edit"...), data[400000:]...)
+	stats, err = client.Upload(ctx, "releases/v2.bin", bytes.NewReader(edited), int64(len(edited)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sent == 0 || stats.SentBytes > 2*131072 {
+		t.Errorf("want a few chunks sent, got = %+v", stats)
+	}
+
+	output := new(bytes.Buffer)
+	if err := client.Download(ctx, "releases/v2.bin", output); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(edited, output.Bytes()) {
+		t.Error("data mismatch")
+	}
+	if err := client.Download(ctx, "releases/v3.bin", output); !errors.Is(err, ErrManifestNotFound) {
+		t.Errorf("want = %s, got = %s", ErrManifestNotFound, err)
+	}
+
+	digest := Sum([]byte("unknown"))
+	if ok, err := client.Has(ctx, digest); ok || err != nil {
+		t.Errorf("want = false, got = %t, %v", ok, err)
+	}
+	if _, err := client.Get(ctx, digest); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := NewMemoryStore()
+	handler := NewHandler(config, chunks, NewMemoryManifestStore(), WithMaxManifestBodySize(1024))
+	chunk := []byte("fastcdc chunk")
+	digest := Sum(chunk)
+	large := string(randomData(32, int(config.MaxSize())+1))
+	largeDigest := Sum([]byte(large))
+	manifest := NewManifest(config)
+	for i := 0; i < 100; i++ {
+		manifest.Add(uint(i), 1, digestOf(i))
+	}
+	largeManifest, err := manifest.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Status int
+	}{
+		{"head missing", http.MethodHead, chunksPath + digest.String(), "", http.StatusNotFound},
+		{"put mismatch", http.MethodPut, chunksPath + digest.String(), "other chunk", http.StatusBadRequest},
+		{"put", http.MethodPut, chunksPath + digest.String(), string(chunk), http.StatusNoContent},
+		{"head", http.MethodHead, chunksPath + digest.String(), "", http.StatusOK},
+		{"get", http.MethodGet, chunksPath + digest.String(), "", http.StatusOK},
+		{"invalid digest", http.MethodGet, chunksPath + "1234", "", http.StatusBadRequest},
+		{"delete", http.MethodDelete, chunksPath + digest.String(), "", http.StatusMethodNotAllowed},
+		{"put too large", http.MethodPut, chunksPath + largeDigest.String(), large, http.StatusRequestEntityTooLarge},
+		{"invalid manifest", http.MethodPost, manifestsPath + "broken", "FCDM\x01", http.StatusBadRequest},
+		{"manifest too large", http.MethodPost, manifestsPath + "large", string(largeManifest), http.StatusRequestEntityTooLarge},
+		{"missing manifest", http.MethodGet, manifestsPath + "missing", "", http.StatusNotFound},
+		{"unknown path", http.MethodGet, "/", "", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(tc.Body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tc.Status {
+				t.Errorf("want = %d, got = %d: %s", tc.Status, w.Code, w.Body)
+			}
+		})
+	}
+
+	if chunks.Len() != 1 {
+		t.Errorf("chunks: want = 1, got = %d", chunks.Len())
+	}
+}
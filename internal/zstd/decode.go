@@ -0,0 +1,746 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// fseTable decode the symbols of a normalized distribution.
+type fseTable struct {
+	log     uint8
+	entries []fseEntry
+}
+
+type fseEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+func newFSETable(norm []int16, log uint8) *fseTable {
+	size := 1 << log
+	next := make([]uint16, len(norm))
+	for s, n := range norm {
+		if n == -1 {
+			n = 1
+		}
+		next[s] = uint16(n)
+	}
+	t := &fseTable{log: log, entries: make([]fseEntry, size)}
+	for u, s := range spread(norm, log) {
+		x := next[s]
+		next[s]++
+		nb := log - uint8(bits.Len16(x)-1)
+		t.entries[u] = fseEntry{symbol: s, bits: nb, base: x<<nb - uint16(size)}
+	}
+	return t
+}
+
+// readFSETable read the normalized distribution of a FSE table and return the table
+// along with the number of bytes read.
+func readFSETable(src []byte, maxSymbol int, maxLog uint8) (*fseTable, int, error) {
+	var pos uint
+	peek := func(n uint) int {
+		var buf [4]byte
+		if i := int(pos >> 3); i < len(src) {
+			copy(buf[:], src[i:])
+		}
+		return int(binary.LittleEndian.Uint32(buf[:]) >> (pos & 7) & (1<<n - 1))
+	}
+
+	log := uint8(peek(4)) + 5
+	pos += 4
+	if log > maxLog {
+		return nil, 0, fmt.Errorf("table log %d: %w", log, ErrCorrupted)
+	}
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := uint(log) + 1
+	norm := make([]int16, 0, maxSymbol+1)
+	for remaining > 1 {
+		if len(norm) > maxSymbol {
+			return nil, 0, fmt.Errorf("table symbols: %w", ErrCorrupted)
+		}
+		max := 2*threshold - 1 - remaining
+		value := peek(nbBits - 1)
+		if value < max {
+			pos += nbBits - 1
+		} else {
+			value = peek(nbBits)
+			if value >= threshold {
+				value -= max
+			}
+			pos += nbBits
+		}
+		count := value - 1
+		norm = append(norm, int16(count))
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		if count == 0 {
+			for {
+				repeat := peek(2)
+				pos += 2
+				for i := 0; i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSymbol+1 {
+		return nil, 0, fmt.Errorf("table distribution: %w", ErrCorrupted)
+	}
+	n := int(pos+7) >> 3
+	if n > len(src) {
+		return nil, 0, errTruncated
+	}
+	return newFSETable(norm, log), n, nil
+}
+
+// bitReader read a bitstream backward, from its end mark.
+type bitReader struct {
+	src []byte
+	// Number of bits left to read, negative once more bits than available were read
+	pos int
+}
+
+func (r *bitReader) init(src []byte) error {
+	if len(src) == 0 || src[len(src)-1] == 0 {
+		return fmt.Errorf("missing bitstream end mark: %w", ErrCorrupted)
+	}
+	r.src = src
+	r.pos = (len(src)-1)*8 + bits.Len8(src[len(src)-1]) - 1
+	return nil
+}
+
+// peek return the next n bits, at most 56. The bits before the start of the stream are zeros.
+func (r *bitReader) peek(n uint) uint64 {
+	if n == 0 || r.pos <= 0 {
+		return 0
+	}
+	start := r.pos - int(n)
+	if start < 0 {
+		return r.load(0, uint(r.pos)) << uint(-start)
+	}
+	return r.load(start, n)
+}
+
+func (r *bitReader) load(start int, n uint) uint64 {
+	i := start >> 3
+	var v uint64
+	if i+8 <= len(r.src) {
+		v = binary.LittleEndian.Uint64(r.src[i:])
+	} else {
+		var buf [8]byte
+		copy(buf[:], r.src[i:])
+		v = binary.LittleEndian.Uint64(buf[:])
+	}
+	return v >> uint(start&7) & (1<<n - 1)
+}
+
+func (r *bitReader) read(n uint) uint64 {
+	v := r.peek(n)
+	r.pos -= int(n)
+	return v
+}
+
+// huffmanTable decode the literals prefix codes.
+type huffmanTable struct {
+	maxBits uint8
+	entries []huffmanEntry
+}
+
+type huffmanEntry struct {
+	symbol uint8
+	bits   uint8
+}
+
+// readHuffmanTable read the description of a Huffman table and return the table along
+// with the number of bytes read.
+func readHuffmanTable(src []byte) (*huffmanTable, int, error) {
+	if len(src) == 0 {
+		return nil, 0, errTruncated
+	}
+	var weights []uint8
+	n := 1
+	if header := int(src[0]); header < 128 {
+		// Weights compressed with a FSE table, decoded with two interleaved states
+		n += header
+		if n > len(src) {
+			return nil, 0, errTruncated
+		}
+		table, read, err := readFSETable(src[1:n], maxHuffmanBits+1, 6)
+		if err != nil {
+			return nil, 0, err
+		}
+		var br bitReader
+		if err := br.init(src[1+read : n]); err != nil {
+			return nil, 0, err
+		}
+		states := [2]uint64{br.read(uint(table.log)), br.read(uint(table.log))}
+		for i := 0; ; i ^= 1 {
+			if len(weights) > 255 {
+				return nil, 0, fmt.Errorf("too many huffman weights: %w", ErrCorrupted)
+			}
+			e := table.entries[states[i]]
+			weights = append(weights, e.symbol)
+			states[i] = uint64(e.base) + br.read(uint(e.bits))
+			if br.pos < 0 {
+				weights = append(weights, table.entries[states[i^1]].symbol)
+				break
+			}
+		}
+	} else {
+		// Weights stored as 4 bits values
+		count := header - 127
+		n += (count + 1) / 2
+		if n > len(src) {
+			return nil, 0, errTruncated
+		}
+		for i := 0; i < count; i++ {
+			weights = append(weights, src[1+i/2]>>(4*uint(1-i%2))&0x0F)
+		}
+	}
+	if len(weights) > 255 {
+		return nil, 0, fmt.Errorf("too many huffman weights: %w", ErrCorrupted)
+	}
+
+	// The weight of the last symbol complete the sum of the weights to a power of 2
+	var total uint32
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return nil, 0, fmt.Errorf("huffman weight %d: %w", w, ErrCorrupted)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, fmt.Errorf("empty huffman table: %w", ErrCorrupted)
+	}
+	maxBits := uint8(bits.Len32(total))
+	left := uint32(1)<<maxBits - total
+	if maxBits > maxHuffmanBits || left&(left-1) != 0 {
+		return nil, 0, fmt.Errorf("invalid huffman weights: %w", ErrCorrupted)
+	}
+	weights = append(weights, uint8(bits.Len32(left)))
+
+	// Longest codes first, each filling 2^(weight-1) entries
+	t := &huffmanTable{maxBits: maxBits, entries: make([]huffmanEntry, 1<<maxBits)}
+	pos := 0
+	for w := uint8(1); w <= maxBits; w++ {
+		for s, sw := range weights {
+			if sw != w {
+				continue
+			}
+			entry := huffmanEntry{symbol: uint8(s), bits: maxBits + 1 - w}
+			for i := 0; i < 1<<(w-1); i++ {
+				t.entries[pos] = entry
+				pos++
+			}
+		}
+	}
+	return t, n, nil
+}
+
+// decode append the n symbols of the stream to dst.
+func (t *huffmanTable) decode(dst, src []byte, n int) ([]byte, error) {
+	var br bitReader
+	if err := br.init(src); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		e := t.entries[br.peek(uint(t.maxBits))]
+		dst = append(dst, e.symbol)
+		br.pos -= int(e.bits)
+	}
+	if br.pos != 0 {
+		return nil, fmt.Errorf("huffman stream size mismatch: %w", ErrCorrupted)
+	}
+	return dst, nil
+}
+
+// Decode return the content of the concatenated zstd frames, which must not be larger than maxSize.
+func Decode(src []byte, maxSize int) ([]byte, error) {
+	d := &decoder{maxSize: maxSize}
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, errTruncated
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		src = src[4:]
+
+		if magic&0xFFFFFFF0 == skippableMagic {
+			if len(src) < 4 {
+				return nil, errTruncated
+			}
+			size := uint64(binary.LittleEndian.Uint32(src))
+			if uint64(len(src)-4) < size {
+				return nil, errTruncated
+			}
+			src = src[4+size:]
+			continue
+		}
+		if magic != frameMagic {
+			return nil, fmt.Errorf("invalid magic %#x: %w", magic, ErrCorrupted)
+		}
+
+		var err error
+		if src, err = d.decodeFrame(src); err != nil {
+			return nil, err
+		}
+	}
+	if d.out == nil {
+		d.out = []byte{}
+	}
+	return d.out, nil
+}
+
+type decoder struct {
+	out     []byte
+	maxSize int
+
+	// State of the current frame
+	start    int
+	offsets  [3]int
+	huffman  *huffmanTable
+	ll       *fseTable
+	of       *fseTable
+	ml       *fseTable
+	literals []byte
+}
+
+// decodeFrame append the content of the frame to the output and return the bytes after the frame.
+func (d *decoder) decodeFrame(src []byte) ([]byte, error) {
+	d.start = len(d.out)
+	d.offsets = [3]int{1, 4, 8}
+	d.huffman, d.ll, d.of, d.ml = nil, nil, nil, nil
+
+	if len(src) < 1 {
+		return nil, errTruncated
+	}
+	descriptor := src[0]
+	src = src[1:]
+	fcsFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	checksum := descriptor&0x04 != 0
+	dictIDSize := [4]int{0, 1, 2, 4}[descriptor&0x03]
+	if descriptor&0x08 != 0 {
+		return nil, fmt.Errorf("reserved frame header bit: %w", ErrCorrupted)
+	}
+
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	if !singleSegment {
+		// The window size is not needed, the whole output being kept
+		if len(src) < 1 {
+			return nil, errTruncated
+		}
+		src = src[1:]
+	}
+	headerSize := dictIDSize + fcsSize
+	if len(src) < headerSize {
+		return nil, errTruncated
+	}
+	var dictID uint64
+	for i := dictIDSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint64(src[i])
+	}
+	if dictID != 0 {
+		return nil, fmt.Errorf("dictionary %d: %w", dictID, ErrUnsupported)
+	}
+	contentSize := int64(-1)
+	if fcsSize > 0 {
+		var fcs uint64
+		for i := fcsSize - 1; i >= 0; i-- {
+			fcs = fcs<<8 | uint64(src[dictIDSize+i])
+		}
+		if fcsSize == 2 {
+			fcs += 256
+		}
+		if fcs > uint64(d.maxSize-len(d.out)) {
+			return nil, fmt.Errorf("frame of %d bytes exceed %d bytes: %w", fcs, d.maxSize, ErrCorrupted)
+		}
+		contentSize = int64(fcs)
+	}
+	src = src[headerSize:]
+
+	for {
+		if len(src) < 3 {
+			return nil, errTruncated
+		}
+		header := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		src = src[3:]
+		last := header&1 != 0
+		size := int(header >> 3)
+		if size > maxBlockSize {
+			return nil, fmt.Errorf("block of %d bytes: %w", size, ErrCorrupted)
+		}
+
+		switch (header >> 1) & 0x03 {
+		case blockRaw:
+			if len(src) < size {
+				return nil, errTruncated
+			}
+			if err := d.grow(size); err != nil {
+				return nil, err
+			}
+			d.out = append(d.out, src[:size]...)
+			src = src[size:]
+		case blockRLE:
+			if len(src) < 1 {
+				return nil, errTruncated
+			}
+			if err := d.grow(size); err != nil {
+				return nil, err
+			}
+			for i := 0; i < size; i++ {
+				d.out = append(d.out, src[0])
+			}
+			src = src[1:]
+		case blockCompressed:
+			if len(src) < size {
+				return nil, errTruncated
+			}
+			if err := d.decodeBlock(src[:size]); err != nil {
+				return nil, err
+			}
+			src = src[size:]
+		default:
+			return nil, fmt.Errorf("reserved block type: %w", ErrCorrupted)
+		}
+
+		if last {
+			break
+		}
+	}
+	if contentSize >= 0 && int64(len(d.out)-d.start) != contentSize {
+		return nil, fmt.Errorf("frame content size mismatch: %w", ErrCorrupted)
+	}
+
+	if checksum {
+		// The chunk digest is checked instead of the xxhash64 checksum
+		if len(src) < 4 {
+			return nil, errTruncated
+		}
+		src = src[4:]
+	}
+	return src, nil
+}
+
+// grow check that n more bytes can be decoded.
+func (d *decoder) grow(n int) error {
+	if n > d.maxSize-len(d.out) {
+		return fmt.Errorf("frames exceed %d bytes: %w", d.maxSize, ErrCorrupted)
+	}
+	return nil
+}
+
+// decodeBlock decode a compressed block: its literals, then the sequences copying them along with matches.
+func (d *decoder) decodeBlock(block []byte) error {
+	n, err := d.readLiterals(block)
+	if err != nil {
+		return err
+	}
+	block = block[n:]
+
+	if len(block) < 1 {
+		return errTruncated
+	}
+	count := int(block[0])
+	switch {
+	case count < 128:
+		block = block[1:]
+	case count < 255:
+		if len(block) < 2 {
+			return errTruncated
+		}
+		count = (count-128)<<8 | int(block[1])
+		block = block[2:]
+	default:
+		if len(block) < 3 {
+			return errTruncated
+		}
+		count = int(block[1]) | int(block[2])<<8 + 0x7F00
+		block = block[3:]
+	}
+	if count == 0 {
+		if len(block) != 0 {
+			return fmt.Errorf("block with trailing bytes: %w", ErrCorrupted)
+		}
+		if err := d.grow(len(d.literals)); err != nil {
+			return err
+		}
+		d.out = append(d.out, d.literals...)
+		return nil
+	}
+
+	if len(block) < 1 {
+		return errTruncated
+	}
+	modes := block[0]
+	block = block[1:]
+	if modes&0x03 != 0 {
+		return fmt.Errorf("reserved sequences modes: %w", ErrCorrupted)
+	}
+	tables := []struct {
+		table      **fseTable
+		mode       byte
+		predefined *fseTable
+		maxSymbol  int
+		maxLog     uint8
+	}{
+		{&d.ll, modes >> 6, literalsLengthTable, len(literalsLengthCodes) - 1, 9},
+		{&d.of, modes >> 4 & 0x03, offsetTable, 31, 8},
+		{&d.ml, modes >> 2 & 0x03, matchLengthTable, len(matchLengthCodes) - 1, 9},
+	}
+	for _, t := range tables {
+		switch t.mode {
+		case modePredefined:
+			*t.table = t.predefined
+		case modeRLE:
+			if len(block) < 1 {
+				return errTruncated
+			}
+			if int(block[0]) > t.maxSymbol {
+				return fmt.Errorf("sequence code %d: %w", block[0], ErrCorrupted)
+			}
+			*t.table = &fseTable{entries: []fseEntry{{symbol: block[0]}}}
+			block = block[1:]
+		case modeCompressed:
+			table, n, err := readFSETable(block, t.maxSymbol, t.maxLog)
+			if err != nil {
+				return err
+			}
+			*t.table = table
+			block = block[n:]
+		case modeRepeat:
+			if *t.table == nil {
+				return fmt.Errorf("repeat mode without a previous table: %w", ErrCorrupted)
+			}
+		}
+	}
+	return d.decodeSequences(block, count)
+}
+
+// readLiterals read the literals section of the block and return its size.
+func (d *decoder) readLiterals(block []byte) (int, error) {
+	if len(block) < 1 {
+		return 0, errTruncated
+	}
+	typ := block[0] & 0x03
+	format := block[0] >> 2 & 0x03
+
+	if typ == literalsRaw || typ == literalsRLE {
+		var header, size int
+		switch format {
+		case 0, 2:
+			header, size = 1, int(block[0]>>3)
+		case 1:
+			if len(block) < 2 {
+				return 0, errTruncated
+			}
+			header, size = 2, int(block[0]>>4)|int(block[1])<<4
+		case 3:
+			if len(block) < 3 {
+				return 0, errTruncated
+			}
+			header, size = 3, int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12
+		}
+		if size > maxBlockSize {
+			return 0, fmt.Errorf("literals of %d bytes: %w", size, ErrCorrupted)
+		}
+		if typ == literalsRaw {
+			if len(block) < header+size {
+				return 0, errTruncated
+			}
+			d.literals = block[header : header+size]
+			return header + size, nil
+		}
+		if len(block) < header+1 {
+			return 0, errTruncated
+		}
+		literals := make([]byte, size)
+		for i := range literals {
+			literals[i] = block[header]
+		}
+		d.literals = literals
+		return header + 1, nil
+	}
+
+	header, streams, sizeBits := 3, 4, uint(10)
+	switch format {
+	case 0:
+		streams = 1
+	case 2:
+		header, sizeBits = 4, 14
+	case 3:
+		header, sizeBits = 5, 18
+	}
+	if len(block) < header {
+		return 0, errTruncated
+	}
+	var h uint64
+	for i := header - 1; i >= 0; i-- {
+		h = h<<8 | uint64(block[i])
+	}
+	mask := uint64(1)<<sizeBits - 1
+	regenerated := int(h >> 4 & mask)
+	compressed := int(h >> (4 + sizeBits) & mask)
+	if regenerated > maxBlockSize {
+		return 0, fmt.Errorf("literals of %d bytes: %w", regenerated, ErrCorrupted)
+	}
+	if len(block) < header+compressed {
+		return 0, errTruncated
+	}
+	data := block[header : header+compressed]
+	if typ == literalsCompressed {
+		table, n, err := readHuffmanTable(data)
+		if err != nil {
+			return 0, err
+		}
+		d.huffman = table
+		data = data[n:]
+	} else if d.huffman == nil {
+		return 0, fmt.Errorf("treeless literals without a previous table: %w", ErrCorrupted)
+	}
+
+	literals := make([]byte, 0, regenerated)
+	var err error
+	if streams == 1 {
+		if literals, err = d.huffman.decode(literals, data, regenerated); err != nil {
+			return 0, err
+		}
+		d.literals = literals
+		return header + compressed, nil
+	}
+
+	// Four streams, the size of the first three being given by a jump table
+	if len(data) < 6 {
+		return 0, errTruncated
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(data)),
+		int(binary.LittleEndian.Uint16(data[2:])),
+		int(binary.LittleEndian.Uint16(data[4:])),
+	}
+	data = data[6:]
+	sizes[3] = len(data) - sizes[0] - sizes[1] - sizes[2]
+	per := (regenerated + 3) / 4
+	if sizes[3] < 0 || regenerated < 3*per {
+		return 0, fmt.Errorf("invalid literals jump table: %w", ErrCorrupted)
+	}
+	for i, size := range sizes {
+		n := per
+		if i == 3 {
+			n = regenerated - 3*per
+		}
+		if literals, err = d.huffman.decode(literals, data[:size], n); err != nil {
+			return 0, err
+		}
+		data = data[size:]
+	}
+	d.literals = literals
+	return header + compressed, nil
+}
+
+// decodeSequences decode and execute the count sequences of the bitstream.
+func (d *decoder) decodeSequences(src []byte, count int) error {
+	var br bitReader
+	if err := br.init(src); err != nil {
+		return err
+	}
+	ll := br.read(uint(d.ll.log))
+	of := br.read(uint(d.of.log))
+	ml := br.read(uint(d.ml.log))
+
+	literals := d.literals
+	for i := 0; i < count; i++ {
+		llEntry, ofEntry, mlEntry := d.ll.entries[ll], d.of.entries[of], d.ml.entries[ml]
+		if ofEntry.symbol > 31 {
+			return fmt.Errorf("offset code %d: %w", ofEntry.symbol, ErrCorrupted)
+		}
+		offsetValue := int(1)<<ofEntry.symbol + int(br.read(uint(ofEntry.symbol)))
+		mlCode := matchLengthCodes[mlEntry.symbol]
+		matchLength := int(mlCode.base) + int(br.read(uint(mlCode.bits)))
+		llCode := literalsLengthCodes[llEntry.symbol]
+		literalsLength := int(llCode.base) + int(br.read(uint(llCode.bits)))
+		offset, err := d.offset(offsetValue, literalsLength)
+		if err != nil {
+			return err
+		}
+		if i < count-1 {
+			ll = uint64(llEntry.base) + br.read(uint(llEntry.bits))
+			ml = uint64(mlEntry.base) + br.read(uint(mlEntry.bits))
+			of = uint64(ofEntry.base) + br.read(uint(ofEntry.bits))
+		}
+
+		if literalsLength > len(literals) {
+			return fmt.Errorf("sequence past the literals: %w", ErrCorrupted)
+		}
+		if err := d.grow(literalsLength + matchLength); err != nil {
+			return err
+		}
+		d.out = append(d.out, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+		if offset > len(d.out)-d.start {
+			return fmt.Errorf("match offset %d before the frame: %w", offset, ErrCorrupted)
+		}
+		// Matches can overlap their own output
+		from := len(d.out) - offset
+		for j := 0; j < matchLength; j++ {
+			d.out = append(d.out, d.out[from+j])
+		}
+	}
+	if br.pos != 0 {
+		return fmt.Errorf("sequences stream size mismatch: %w", ErrCorrupted)
+	}
+	if err := d.grow(len(literals)); err != nil {
+		return err
+	}
+	d.out = append(d.out, literals...)
+	return nil
+}
+
+// offset return the offset of a match and update the repeated offsets.
+func (d *decoder) offset(value, literalsLength int) (int, error) {
+	if value > 3 {
+		offset := value - 3
+		d.offsets = [3]int{offset, d.offsets[0], d.offsets[1]}
+		return offset, nil
+	}
+	if literalsLength == 0 {
+		value++
+	}
+	var offset int
+	switch value {
+	case 1:
+		return d.offsets[0], nil
+	case 2:
+		offset = d.offsets[1]
+		d.offsets[1] = d.offsets[0]
+	case 3:
+		offset = d.offsets[2]
+		d.offsets[2], d.offsets[1] = d.offsets[1], d.offsets[0]
+	case 4:
+		offset = d.offsets[0] - 1
+		if offset == 0 {
+			return 0, fmt.Errorf("null repeated offset: %w", ErrCorrupted)
+		}
+		d.offsets[2], d.offsets[1] = d.offsets[1], d.offsets[0]
+	}
+	d.offsets[0] = offset
+	return offset, nil
+}
@@ -0,0 +1,118 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// words return the text compressed by the reference zstd encoder in fixtures/words.txt.zst.
+func words() []byte {
+	words := []string{"chunk", "content", "defined", "fast", "gear", "hash", "boundary", "deduplication", "store", "manifest", "digest", "rolling", "window", "mask", "normalized", "level", "stream", "buffer"}
+	r := rand.New(rand.NewSource(44))
+	var text []byte
+	for len(text) < 300000 {
+		text = append(text, words[r.Intn(len(words))]...)
+		if r.Intn(12) == 0 {
+			text = append(text, ".\n"...)
+		} else {
+			text = append(text, ' ')
+		}
+	}
+	return text
+}
+
+func TestReference(t *testing.T) {
+	sekien, err := ioutil.ReadFile("../../fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Compressed by zstd -19, with huffman coded literals and FSE coded sequences
+	tests := map[string][]byte{
+		"../../fixtures/words.txt.zst":          words(),
+		"../../fixtures/SekienAkashita.jpg.zst": sekien,
+	}
+	for path, want := range tests {
+		t.Run(path, func(t *testing.T) {
+			frame, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(frame, len(want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Error("data mismatch")
+			}
+
+			// Every truncation is detected
+			for _, n := range []int{len(frame) / 3, len(frame) / 2, len(frame) - 1} {
+				if _, err := Decode(frame[:n], len(want)); !errors.Is(err, ErrCorrupted) {
+					t.Errorf("truncated at %d: want = %s, got = %v", n, ErrCorrupted, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := map[string]struct {
+		Frame []byte
+		Want  []byte
+		Err   error
+	}{
+		// zstd --no-check of "abc", a raw block with a window descriptor
+		"zstd raw": {
+			Frame: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x58, 0x19, 0x00, 0x00, 0x61, 0x62, 0x63},
+			Want:  []byte("abc"),
+		},
+		"rle with checksum": {
+			Frame: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x24, 0x05, 0x2b, 0x00, 0x00, 0x7a, 0x01, 0x02, 0x03, 0x04},
+			Want:  []byte("zzzzz"),
+		},
+		"skippable frame": {
+			Frame: append([]byte{0x50, 0x2a, 0x4d, 0x18, 0x02, 0x00, 0x00, 0x00, 0xff, 0xff}, Encode([]byte("abc"))...),
+			Want:  []byte("abc"),
+		},
+		// zstd of 1000 zero bytes, a compressed block
+		"zstd compressed": {
+			Frame: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x58, 0x4d, 0x00, 0x00, 0x10, 0x00, 0x00, 0x01, 0x00, 0xe3, 0x2b, 0x80, 0x05, 0x5a, 0x07, 0x44, 0x79},
+			Want:  make([]byte, 1000),
+		},
+		"dictionary": {
+			Frame: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x01, 0x58, 0x2a, 0x19, 0x00, 0x00, 0x61, 0x62, 0x63},
+			Err:   ErrUnsupported,
+		},
+		"content size mismatch": {
+			Frame: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x04, 0x19, 0x00, 0x00, 0x61, 0x62, 0x63},
+			Err:   ErrCorrupted,
+		},
+		"too large": {
+			Frame: Encode(make([]byte, 2000)),
+			Err:   ErrCorrupted,
+		},
+		"truncated": {
+			Frame: Encode([]byte("fastcdc"))[:15],
+			Err:   ErrCorrupted,
+		},
+		"invalid magic": {
+			Frame: []byte("not a zstd frame"),
+			Err:   ErrCorrupted,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Decode(tc.Frame, 1000)
+			if !errors.Is(err, tc.Err) {
+				t.Fatalf("want = %v, got = %v", tc.Err, err)
+			}
+			if !bytes.Equal(tc.Want, got) {
+				t.Errorf("want = %q, got = %q", tc.Want, got)
+			}
+		})
+	}
+}
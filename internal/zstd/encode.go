@@ -0,0 +1,274 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Encode return a single zstd frame holding data.
+func Encode(data []byte) []byte {
+	frame := make([]byte, 0, 4+1+8+3+len(data)/2)
+	frame = appendUint32LE(frame, frameMagic)
+	// Single segment with an 8 bytes frame content size
+	frame = append(frame, 0xE0)
+	frame = appendUint64LE(frame, uint64(len(data)))
+
+	e := newEncoder(len(data))
+	for start := 0; ; start += maxBlockSize {
+		end := start + maxBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		frame = e.appendBlock(frame, data, start, end)
+		if end == len(data) {
+			return frame
+		}
+	}
+}
+
+// sequence is a run of literals followed by a match.
+type sequence struct {
+	literals uint32
+	match    uint32
+	offset   uint32
+}
+
+type encoder struct {
+	// Position + 1 of the last occurrence of each hash of minMatch bytes
+	table    []int32
+	shift    uint
+	literals []byte
+	seqs     []sequence
+	body     []byte
+}
+
+func newEncoder(size int) *encoder {
+	log := bits.Len(uint(size))
+	if log < 8 {
+		log = 8
+	} else if log > 17 {
+		log = 17
+	}
+	return &encoder{
+		table: make([]int32, 1<<log),
+		shift: uint(32 - log),
+	}
+}
+
+// appendBlock append the block of data from start to end, compressed if it's smaller.
+func (e *encoder) appendBlock(frame, data []byte, start, end int) []byte {
+	last := uint32(0)
+	if end == len(data) {
+		last = 1
+	}
+	if body := e.compress(data, start, end); body != nil && len(body) < end-start {
+		header := uint32(len(body))<<3 | blockCompressed<<1 | last
+		frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+		return append(frame, body...)
+	}
+	header := uint32(end-start)<<3 | blockRaw<<1 | last
+	frame = append(frame, byte(header), byte(header>>8), byte(header>>16))
+	return append(frame, data[start:end]...)
+}
+
+// compress return the content of a compressed block, or nil if no match is found.
+// Matches can start anywhere before the block, but must end within the block.
+func (e *encoder) compress(data []byte, start, end int) []byte {
+	e.literals = e.literals[:0]
+	e.seqs = e.seqs[:0]
+	anchor := start
+	for i := start; i+minMatch <= end; {
+		v := binary.LittleEndian.Uint32(data[i:])
+		h := (v * 2654435761) >> e.shift
+		candidate := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > maxOffset || binary.LittleEndian.Uint32(data[candidate:]) != v {
+			// Skip faster through incompressible data
+			i += 1 + (i-anchor)>>6
+			continue
+		}
+		length := minMatch
+		for i+length < end && data[candidate+length] == data[i+length] {
+			length++
+		}
+		e.literals = append(e.literals, data[anchor:i]...)
+		e.seqs = append(e.seqs, sequence{literals: uint32(i - anchor), match: uint32(length), offset: uint32(i - candidate)})
+		i += length
+		anchor = i
+	}
+	if len(e.seqs) == 0 {
+		return nil
+	}
+	e.literals = append(e.literals, data[anchor:end]...)
+
+	// Raw literals section
+	body := e.body[:0]
+	switch n := uint32(len(e.literals)); {
+	case n < 32:
+		body = append(body, byte(n<<3|literalsRaw))
+	case n < 4096:
+		body = append(body, byte(n<<4|1<<2|literalsRaw), byte(n>>4))
+	default:
+		body = append(body, byte(n<<4|3<<2|literalsRaw), byte(n>>4), byte(n>>12))
+	}
+	body = append(body, e.literals...)
+
+	// Sequences section, using the predefined tables
+	switch n := len(e.seqs); {
+	case n < 128:
+		body = append(body, byte(n))
+	case n < 0x7F00:
+		body = append(body, byte(n>>8+128), byte(n))
+	default:
+		body = append(body, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	body = append(body, modePredefined<<6|modePredefined<<4|modePredefined<<2)
+	body = e.appendSequences(body)
+	e.body = body
+	return body
+}
+
+// appendSequences append the bitstream of the sequences. The decoder read the bitstream
+// backward, so the sequences are encoded from the last to the first.
+func (e *encoder) appendSequences(dst []byte) []byte {
+	w := bitWriter{dst: dst}
+	n := len(e.seqs)
+	ll, ml, of := sequenceCodes(e.seqs[n-1])
+	llState := literalsLengthEncoder.init(ll.code)
+	mlState := matchLengthEncoder.init(ml.code)
+	ofState := offsetEncoder.init(of.code)
+	w.add(ll.extra, ll.bits)
+	w.add(ml.extra, ml.bits)
+	w.add(of.extra, of.bits)
+	for i := n - 2; i >= 0; i-- {
+		ll, ml, of := sequenceCodes(e.seqs[i])
+		ofState = offsetEncoder.encode(&w, ofState, of.code)
+		mlState = matchLengthEncoder.encode(&w, mlState, ml.code)
+		llState = literalsLengthEncoder.encode(&w, llState, ll.code)
+		w.add(ll.extra, ll.bits)
+		w.add(ml.extra, ml.bits)
+		w.add(of.extra, of.bits)
+	}
+	w.add(uint64(mlState), uint(matchLengthEncoder.log))
+	w.add(uint64(ofState), uint(offsetEncoder.log))
+	w.add(uint64(llState), uint(literalsLengthEncoder.log))
+	return w.close()
+}
+
+// symbol is a code along with its additional bits.
+type symbol struct {
+	code  uint8
+	extra uint64
+	bits  uint
+}
+
+// sequenceCodes return the literals length, match length and offset codes of the sequence.
+// Offsets are always encoded as new offsets, never as repeated offsets.
+func sequenceCodes(seq sequence) (ll, ml, of symbol) {
+	ll = lengthSymbol(literalsLengthCodes[:], seq.literals)
+	ml = lengthSymbol(matchLengthCodes[:], seq.match)
+	value := seq.offset + 3
+	code := uint8(bits.Len32(value) - 1)
+	of = symbol{code: code, extra: uint64(value - 1<<code), bits: uint(code)}
+	return ll, ml, of
+}
+
+func lengthSymbol(codes []lengthCode, length uint32) symbol {
+	code := len(codes) - 1
+	for codes[code].base > length {
+		code--
+	}
+	return symbol{code: uint8(code), extra: uint64(length - codes[code].base), bits: uint(codes[code].bits)}
+}
+
+// bitWriter write a bitstream, least significant bits first.
+type bitWriter struct {
+	dst   []byte
+	bits  uint64
+	count uint
+}
+
+// add write the n low bits of v, n being at most 32.
+func (w *bitWriter) add(v uint64, n uint) {
+	w.bits |= (v & (1<<n - 1)) << w.count
+	w.count += n
+	for w.count >= 8 {
+		w.dst = append(w.dst, byte(w.bits))
+		w.bits >>= 8
+		w.count -= 8
+	}
+}
+
+// close write the end mark of the bitstream and return it.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.count > 0 {
+		w.dst = append(w.dst, byte(w.bits))
+	}
+	return w.dst
+}
+
+// fseEncoder encode the symbols of a normalized distribution.
+type fseEncoder struct {
+	log     uint8
+	states  []uint16
+	symbols []fseTransform
+}
+
+type fseTransform struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+func newFSEEncoder(norm []int16, log uint8) *fseEncoder {
+	size := 1 << log
+	e := &fseEncoder{
+		log:     log,
+		states:  make([]uint16, size),
+		symbols: make([]fseTransform, len(norm)),
+	}
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			n = 1
+		}
+		cumul[s+1] = cumul[s] + int(n)
+	}
+	for u, s := range spread(norm, log) {
+		e.states[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, n := range norm {
+		switch n {
+		case 0:
+			// Never encoded
+		case -1, 1:
+			e.symbols[s] = fseTransform{deltaFindState: total - 1, deltaNbBits: uint32(log)<<16 - uint32(size)}
+			total++
+		default:
+			maxBitsOut := uint32(log) - uint32(bits.Len16(uint16(n-1))-1)
+			minStatePlus := uint32(n) << maxBitsOut
+			e.symbols[s] = fseTransform{deltaFindState: total - int32(n), deltaNbBits: maxBitsOut<<16 - minStatePlus}
+			total += int32(n)
+		}
+	}
+	return e
+}
+
+// init return the initial state of the encoder for the last symbol of the stream.
+func (e *fseEncoder) init(symbol uint8) uint32 {
+	t := e.symbols[symbol]
+	nbBitsOut := (t.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - t.deltaNbBits
+	return uint32(e.states[int32(value>>nbBitsOut)+t.deltaFindState])
+}
+
+// encode write the bits of the state and return the state of the symbol.
+func (e *fseEncoder) encode(w *bitWriter, state uint32, symbol uint8) uint32 {
+	t := e.symbols[symbol]
+	nbBitsOut := (state + t.deltaNbBits) >> 16
+	w.add(uint64(state), uint(nbBitsOut))
+	return uint32(e.states[int32(state>>nbBitsOut)+t.deltaFindState])
+}
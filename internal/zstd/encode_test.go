@@ -0,0 +1,57 @@
+package zstd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(seed))).Read(data)
+	return data
+}
+
+func TestEncode(t *testing.T) {
+	sekien, err := ioutil.ReadFile("../../fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := words()
+	// More than 32512 sequences per block, of a single 4 bytes token each
+	vocabulary := randomData(47, 1024)
+	var tokens []byte
+	for _, i := range randomData(48, 75_000) {
+		tokens = append(tokens, vocabulary[int(i)*4:int(i)*4+4]...)
+	}
+	tests := map[string]struct {
+		Data []byte
+		// Maximum size of the frame
+		Max int
+	}{
+		"empty":      {nil, 16},
+		"small":      {[]byte("fastcdc"), 7 + 16},
+		"zeros":      {make([]byte, 1_000_000), 200},
+		"words":      {text, len(text) / 2},
+		"jpeg":       {sekien, len(sekien) + 16},
+		"random":     {randomData(45, 300_000), 300_000 + 16 + 3*3},
+		"tokens":     {tokens, len(tokens) * 3 / 4},
+		"repetition": {append(append(randomData(46, 200_000), text[:50_000]...), randomData(46, 200_000)...), 300_000},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			frame := Encode(tc.Data)
+			if len(frame) > tc.Max {
+				t.Errorf("frame size: want <= %d, got = %d", tc.Max, len(frame))
+			}
+			got, err := Decode(frame, len(tc.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.Data, got) {
+				t.Error("data mismatch")
+			}
+		})
+	}
+}
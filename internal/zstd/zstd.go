@@ -0,0 +1,138 @@
+// Package zstd implements the zstd compression format described in RFC 8878, as used by
+// the casync chunk stores.
+//
+// All the frames are decoded, except those requiring a dictionary, which are reported with
+// ErrUnsupported. The encoder look for matches with a greedy LZ77 match finder and encode the
+// sequences with the predefined FSE tables, while the literals are stored as is. It compress
+// less than the reference encoder, but its frames are readable by any zstd decoder.
+package zstd
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrCorrupted is returned when a frame is malformed or larger than allowed.
+	ErrCorrupted = errors.New("corrupted zstd frame")
+	// ErrUnsupported is returned when a frame requires a dictionary.
+	ErrUnsupported = errors.New("unsupported zstd frame")
+)
+
+const (
+	frameMagic     = 0xFD2FB528
+	skippableMagic = 0x184D2A50
+	maxBlockSize   = 128 * 1024
+
+	blockRaw        = 0
+	blockRLE        = 1
+	blockCompressed = 2
+
+	literalsRaw        = 0
+	literalsRLE        = 1
+	literalsCompressed = 2
+	literalsTreeless   = 3
+
+	modePredefined = 0
+	modeRLE        = 1
+	modeCompressed = 2
+	modeRepeat     = 3
+)
+
+const (
+	// minMatch is the length of the shortest match looked for by the encoder.
+	minMatch = 4
+	// maxOffset bound the offset of the matches, the largest offset code of the
+	// predefined table being 28.
+	maxOffset = 1<<29 - 4
+	// maxHuffmanBits is the maximum length of the literals prefix codes.
+	maxHuffmanBits = 11
+)
+
+// lengthCode is the baseline and the number of additional bits of a length code.
+type lengthCode struct {
+	base uint32
+	bits uint8
+}
+
+var literalsLengthCodes = [36]lengthCode{
+	{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0},
+	{8, 0}, {9, 0}, {10, 0}, {11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0},
+	{16, 1}, {18, 1}, {20, 1}, {22, 1}, {24, 2}, {28, 2}, {32, 3}, {40, 3},
+	{48, 4}, {64, 6}, {128, 7}, {256, 8}, {512, 9}, {1024, 10}, {2048, 11}, {4096, 12},
+	{8192, 13}, {16384, 14}, {32768, 15}, {65536, 16},
+}
+
+var matchLengthCodes = [53]lengthCode{
+	{3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0}, {8, 0}, {9, 0}, {10, 0},
+	{11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0}, {16, 0}, {17, 0}, {18, 0},
+	{19, 0}, {20, 0}, {21, 0}, {22, 0}, {23, 0}, {24, 0}, {25, 0}, {26, 0},
+	{27, 0}, {28, 0}, {29, 0}, {30, 0}, {31, 0}, {32, 0}, {33, 0}, {34, 0},
+	{35, 1}, {37, 1}, {39, 1}, {41, 1}, {43, 2}, {47, 2}, {51, 3}, {59, 3},
+	{67, 4}, {83, 4}, {99, 5}, {131, 7}, {259, 8}, {515, 9}, {1027, 10}, {2051, 11},
+	{4099, 12}, {8195, 13}, {16387, 14}, {32771, 15}, {65539, 16},
+}
+
+// Predefined distributions of the sequences codes.
+var (
+	literalsLengthNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchLengthNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+var (
+	literalsLengthTable = newFSETable(literalsLengthNorm, 6)
+	matchLengthTable    = newFSETable(matchLengthNorm, 6)
+	offsetTable         = newFSETable(offsetNorm, 5)
+
+	literalsLengthEncoder = newFSEEncoder(literalsLengthNorm, 6)
+	matchLengthEncoder    = newFSEEncoder(matchLengthNorm, 6)
+	offsetEncoder         = newFSEEncoder(offsetNorm, 5)
+)
+
+var errTruncated = fmt.Errorf("truncated frame: %w", ErrCorrupted)
+
+// spread return the symbol of each state of a FSE table, the symbols with a
+// "less than 1" probability being at the end of the table.
+func spread(norm []int16, log uint8) []uint8 {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			symbols[high] = uint8(s)
+			high--
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	return symbols
+}
+
+func appendUint32LE(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64LE(buf []byte, v uint64) []byte {
+	return appendUint32LE(appendUint32LE(buf, uint32(v)), uint32(v>>32))
+}
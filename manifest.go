@@ -0,0 +1,677 @@
+package fastcdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// ManifestVersion is the latest manifest format version.
+	ManifestVersion uint = 2
+	// ManifestAlgorithm is the chunking algorithm recorded in the manifest.
+	ManifestAlgorithm = "fastcdc"
+)
+
+var (
+	ErrInvalidManifest            = errors.New("invalid manifest")
+	ErrUnsupportedManifestVersion = errors.New("unsupported manifest version")
+	ErrIncompatibleManifest       = errors.New("incompatible manifest")
+)
+
+// manifestMagic start every binary manifest.
+var manifestMagic = []byte("FCDM")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ManifestFormat is the encoding format of a manifest.
+type ManifestFormat int
+
+const (
+	// ManifestBinary is the compact binary encoding.
+	ManifestBinary ManifestFormat = iota
+	// ManifestJSON is the JSON encoding.
+	ManifestJSON
+)
+
+// ManifestHeader describe the chunker parameters which produced a manifest.
+type ManifestHeader struct {
+	Version   uint   `json:"version"`
+	Algorithm string `json:"algorithm"`
+	MinSize   uint   `json:"min_size"`
+	AvgSize   uint   `json:"avg_size"`
+	MaxSize   uint   `json:"max_size"`
+}
+
+// NewManifestHeader return the header of a manifest produced with the configuration.
+func NewManifestHeader(config *Config) ManifestHeader {
+	return ManifestHeader{
+		Version:   ManifestVersion,
+		Algorithm: ManifestAlgorithm,
+		MinSize:   config.MinSize(),
+		AvgSize:   config.AvgSize(),
+		MaxSize:   config.MaxSize(),
+	}
+}
+
+// Compatible return an error if the chunks of the manifest can't be reused by a
+// chunker with the given configuration, since it would produce different chunks.
+func (h ManifestHeader) Compatible(config *Config) error {
+	if h.Algorithm != ManifestAlgorithm {
+		return fmt.Errorf("manifest algorithm %q is not %q: %w", h.Algorithm, ManifestAlgorithm, ErrIncompatibleManifest)
+	}
+	if h.MinSize != config.MinSize() || h.AvgSize != config.AvgSize() || h.MaxSize != config.MaxSize() {
+		return fmt.Errorf(
+			"manifest chunks size %d/%d/%d differ from %d/%d/%d: %w",
+			h.MinSize, h.AvgSize, h.MaxSize, config.MinSize(), config.AvgSize(), config.MaxSize(), ErrIncompatibleManifest,
+		)
+	}
+	return nil
+}
+
+// ManifestChunk is the position and digest of a chunk in a file. Key is the wrapped
+// encryption key of the chunk, only set for encrypted chunks (since version 2).
+type ManifestChunk struct {
+	Offset uint   `json:"offset"`
+	Length uint   `json:"length"`
+	Digest Digest `json:"digest"`
+	Key    []byte `json:"key,omitempty"`
+}
+
+// Manifest is the recipe of a file, the ordered list of chunks the file is made of.
+type Manifest struct {
+	ManifestHeader
+	Size   uint            `json:"size"`
+	Chunks []ManifestChunk `json:"chunks"`
+}
+
+// NewManifest return an empty manifest for the configuration.
+func NewManifest(config *Config) *Manifest {
+	return &Manifest{
+		ManifestHeader: NewManifestHeader(config),
+		Chunks:         make([]ManifestChunk, 0),
+	}
+}
+
+// Add append a chunk to the manifest.
+func (m *Manifest) Add(offset, length uint, digest Digest) error {
+	m.add(ManifestChunk{Offset: offset, Length: length, Digest: digest})
+	return nil
+}
+
+func (m *Manifest) add(chunk ManifestChunk) {
+	m.Chunks = append(m.Chunks, chunk)
+	if end := chunk.Offset + chunk.Length; end > m.Size {
+		m.Size = end
+	}
+}
+
+// BuildManifest split r with a new chunker session of the configuration and return
+// its manifest. If store is not nil, every chunk is also stored.
+func BuildManifest(ctx context.Context, config *Config, r io.Reader, store ChunkStore) (*Manifest, error) {
+	manifest := NewManifest(config)
+	chunker := config.NewChunker(ctx)
+	defer chunker.Release()
+
+	var fn ChunkFn
+	if store != nil {
+		fn = StoreChunkFn(store, manifest.Add)
+	} else {
+		fn = func(offset, length uint, chunk []byte) error {
+			return manifest.Add(offset, length, Sum(chunk))
+		}
+	}
+
+	if err := chunker.Split(r, fn); err != nil {
+		return nil, err
+	}
+	if err := chunker.Finalize(fn); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Encode write the manifest to w in the given format.
+func (m *Manifest) Encode(w io.Writer, format ManifestFormat) error {
+	enc, err := newManifestEncoder(w, m.ManifestHeader, format)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range m.Chunks {
+		if err := enc.Encode(chunk); err != nil {
+			return err
+		}
+	}
+	// Keep the recorded size, even if it doesn't match the chunks
+	enc.setSize(m.Size)
+	return enc.Close()
+}
+
+// DecodeManifest read a manifest in any format from r.
+func DecodeManifest(r io.Reader) (*Manifest, error) {
+	dec, err := NewManifestDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		ManifestHeader: dec.Header(),
+		Chunks:         make([]ManifestChunk, 0),
+	}
+	for {
+		chunk, err := dec.Decode()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		manifest.Chunks = append(manifest.Chunks, chunk)
+	}
+	manifest.Size = dec.Size()
+	return manifest, nil
+}
+
+// MarshalBinary encode the manifest in the binary format.
+func (m *Manifest) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := m.Encode(buf, ManifestBinary); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decode a manifest in the binary format.
+func (m *Manifest) UnmarshalBinary(data []byte) error {
+	manifest, err := DecodeManifest(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*m = *manifest
+	return nil
+}
+
+// ManifestEncoder write a manifest chunk by chunk, without keeping the chunks in memory.
+type ManifestEncoder interface {
+	// Encode write the next chunk of the manifest.
+	Encode(chunk ManifestChunk) error
+	// Close write the end of the manifest. It doesn't close the underlying writer.
+	Close() error
+}
+
+// ManifestDecoder read a manifest chunk by chunk, without keeping the chunks in memory.
+type ManifestDecoder interface {
+	// Header return the manifest header.
+	Header() ManifestHeader
+	// Decode return the next chunk of the manifest or io.EOF at the end of the manifest.
+	Decode() (ManifestChunk, error)
+	// Size return the file size recorded in the manifest. It's only
+	// valid once Decode returned io.EOF.
+	Size() uint
+}
+
+// NewManifestEncoder write the header to w and return an encoder for the chunks. The header
+// version is always set to the latest version. The recorded file size is the end of the last chunk.
+func NewManifestEncoder(w io.Writer, header ManifestHeader, format ManifestFormat) (ManifestEncoder, error) {
+	return newManifestEncoder(w, header, format)
+}
+
+type manifestEncoder interface {
+	ManifestEncoder
+	setSize(size uint)
+}
+
+func newManifestEncoder(w io.Writer, header ManifestHeader, format ManifestFormat) (manifestEncoder, error) {
+	header.Version = ManifestVersion
+	switch format {
+	case ManifestBinary:
+		return newBinaryManifestEncoder(w, header)
+	case ManifestJSON:
+		return newJSONManifestEncoder(w, header)
+	default:
+		return nil, fmt.Errorf("unknown manifest format %d", format)
+	}
+}
+
+// NewManifestDecoder read the manifest header from r and return a decoder for the chunks.
+// The format is detected from the first bytes of the manifest.
+func NewManifestDecoder(r io.Reader) (ManifestDecoder, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(manifestMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(magic, manifestMagic) {
+		return newBinaryManifestDecoder(br)
+	}
+	return newJSONManifestDecoder(br)
+}
+
+func checkManifestVersion(version uint) error {
+	if version == 0 || version > ManifestVersion {
+		return fmt.Errorf("version %d: %w", version, ErrUnsupportedManifestVersion)
+	}
+	return nil
+}
+
+// Binary manifest layout, all integers are varint encoded:
+//
+//	magic "FCDM" | version | algorithm length | algorithm | min size | avg size | max size
+//	for each chunk: length (> 0) | offset - end of the previous chunk (signed) | digest | key length | key
+//	length 0 | size | chunks count | crc32-c of all the preceding bytes (4 bytes, big endian)
+//
+// Offsets are stored relative to the end of the previous chunk, which
+// makes them 1 byte long while still allowing to represent any offset.
+// The key length and key are absent from version 1 manifests.
+type binaryManifestEncoder struct {
+	w     io.Writer
+	crc   hash.Hash32
+	buf   []byte
+	end   uint
+	size  uint
+	count uint
+}
+
+func newBinaryManifestEncoder(w io.Writer, header ManifestHeader) (*binaryManifestEncoder, error) {
+	e := &binaryManifestEncoder{
+		w:   w,
+		crc: crc32.New(crcTable),
+		buf: make([]byte, 0, 64),
+	}
+	e.buf = append(e.buf, manifestMagic...)
+	e.buf = appendUvarint(e.buf, header.Version)
+	e.buf = appendUvarint(e.buf, uint(len(header.Algorithm)))
+	e.buf = append(e.buf, header.Algorithm...)
+	e.buf = appendUvarint(e.buf, header.MinSize)
+	e.buf = appendUvarint(e.buf, header.AvgSize)
+	e.buf = appendUvarint(e.buf, header.MaxSize)
+	return e, e.flush()
+}
+
+func (e *binaryManifestEncoder) Encode(chunk ManifestChunk) error {
+	if chunk.Length == 0 {
+		return fmt.Errorf("chunk at offset %d has no length: %w", chunk.Offset, ErrInvalidManifest)
+	}
+	e.buf = appendUvarint(e.buf, chunk.Length)
+	e.buf = appendVarint(e.buf, int64(chunk.Offset)-int64(e.end))
+	e.buf = append(e.buf, chunk.Digest[:]...)
+	e.buf = appendUvarint(e.buf, uint(len(chunk.Key)))
+	e.buf = append(e.buf, chunk.Key...)
+	e.end = chunk.Offset + chunk.Length
+	if e.end > e.size {
+		e.size = e.end
+	}
+	e.count++
+	return e.flush()
+}
+
+func (e *binaryManifestEncoder) setSize(size uint) {
+	e.size = size
+}
+
+func (e *binaryManifestEncoder) Close() error {
+	e.buf = appendUvarint(e.buf, 0)
+	e.buf = appendUvarint(e.buf, e.size)
+	e.buf = appendUvarint(e.buf, e.count)
+	if err := e.flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], e.crc.Sum32())
+	_, err := e.w.Write(sum[:])
+	return err
+}
+
+func (e *binaryManifestEncoder) flush() error {
+	e.crc.Write(e.buf)
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+type binaryManifestDecoder struct {
+	r      *bufio.Reader
+	crc    hash.Hash32
+	header ManifestHeader
+	end    uint
+	size   uint
+	count  uint
+	done   bool
+}
+
+func newBinaryManifestDecoder(r *bufio.Reader) (*binaryManifestDecoder, error) {
+	d := &binaryManifestDecoder{
+		r:   r,
+		crc: crc32.New(crcTable),
+	}
+
+	magic := make([]byte, len(manifestMagic))
+	if err := d.read(magic); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if d.header.Version, err = d.readUvarint(); err != nil {
+		return nil, err
+	}
+	if err := checkManifestVersion(d.header.Version); err != nil {
+		return nil, err
+	}
+	n, err := d.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("algorithm name too long: %w", ErrInvalidManifest)
+	}
+	algorithm := make([]byte, n)
+	if err := d.read(algorithm); err != nil {
+		return nil, err
+	}
+	d.header.Algorithm = string(algorithm)
+	if d.header.MinSize, err = d.readUvarint(); err != nil {
+		return nil, err
+	}
+	if d.header.AvgSize, err = d.readUvarint(); err != nil {
+		return nil, err
+	}
+	if d.header.MaxSize, err = d.readUvarint(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *binaryManifestDecoder) Header() ManifestHeader {
+	return d.header
+}
+
+func (d *binaryManifestDecoder) Size() uint {
+	return d.size
+}
+
+func (d *binaryManifestDecoder) Decode() (ManifestChunk, error) {
+	if d.done {
+		return ManifestChunk{}, io.EOF
+	}
+
+	length, err := d.readUvarint()
+	if err != nil {
+		return ManifestChunk{}, err
+	}
+	if length == 0 {
+		return ManifestChunk{}, d.readTrailer()
+	}
+
+	delta, err := binary.ReadVarint(d)
+	if err != nil {
+		return ManifestChunk{}, unexpectedEOF(err)
+	}
+	offset := int64(d.end) + delta
+	if offset < 0 {
+		return ManifestChunk{}, fmt.Errorf("negative chunk offset: %w", ErrInvalidManifest)
+	}
+
+	chunk := ManifestChunk{Offset: uint(offset), Length: length}
+	if err := d.read(chunk.Digest[:]); err != nil {
+		return ManifestChunk{}, err
+	}
+	if d.header.Version >= 2 {
+		n, err := d.readUvarint()
+		if err != nil {
+			return ManifestChunk{}, err
+		}
+		if n > maxWrappedKeySize {
+			return ManifestChunk{}, fmt.Errorf("chunk key too long: %w", ErrInvalidManifest)
+		}
+		if n > 0 {
+			chunk.Key = make([]byte, n)
+			if err := d.read(chunk.Key); err != nil {
+				return ManifestChunk{}, err
+			}
+		}
+	}
+	d.end = chunk.Offset + chunk.Length
+	d.count++
+	return chunk, nil
+}
+
+func (d *binaryManifestDecoder) readTrailer() error {
+	var err error
+	if d.size, err = d.readUvarint(); err != nil {
+		return err
+	}
+	count, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	if count != d.count {
+		return fmt.Errorf("chunks count mismatch: %w", ErrInvalidManifest)
+	}
+	want := d.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch: %w", ErrInvalidManifest)
+	}
+	d.done = true
+	return io.EOF
+}
+
+// ReadByte implement io.ByteReader and keep track of the checksum.
+func (d *binaryManifestDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (d *binaryManifestDecoder) read(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	d.crc.Write(p)
+	return nil
+}
+
+func (d *binaryManifestDecoder) readUvarint() (uint, error) {
+	v, err := binary.ReadUvarint(d)
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return uint(v), nil
+}
+
+func appendUvarint(buf []byte, v uint) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(v))
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+// unexpectedEOF report a truncated manifest as an invalid manifest.
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated manifest: %w", ErrInvalidManifest)
+	}
+	return err
+}
+
+// JSON manifest layout, the header fields come first, followed by the chunks
+// and the file size. It's the same layout as the JSON encoding of a Manifest,
+// except for the size which can only be written once all chunks are known.
+type jsonManifestEncoder struct {
+	w     io.Writer
+	size  uint
+	first bool
+}
+
+func newJSONManifestEncoder(w io.Writer, header ManifestHeader) (*jsonManifestEncoder, error) {
+	buf, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	// Reopen the header object to append the chunks
+	buf = append(buf[:len(buf)-1], `,"chunks":[`...)
+	if _, err := w.Write(buf); err != nil {
+		return nil, err
+	}
+	return &jsonManifestEncoder{w: w, first: true}, nil
+}
+
+func (e *jsonManifestEncoder) Encode(chunk ManifestChunk) error {
+	buf, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	if !e.first {
+		buf = append([]byte{','}, buf...)
+	}
+	e.first = false
+	if end := chunk.Offset + chunk.Length; end > e.size {
+		e.size = end
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+func (e *jsonManifestEncoder) setSize(size uint) {
+	e.size = size
+}
+
+func (e *jsonManifestEncoder) Close() error {
+	_, err := fmt.Fprintf(e.w, "],\"size\":%d}\n", e.size)
+	return err
+}
+
+type jsonManifestDecoder struct {
+	dec    *json.Decoder
+	header ManifestHeader
+	size   uint
+	done   bool
+}
+
+func newJSONManifestDecoder(r io.Reader) (*jsonManifestDecoder, error) {
+	d := &jsonManifestDecoder{dec: json.NewDecoder(r)}
+	if err := d.expect(json.Delim('{')); err != nil {
+		return nil, err
+	}
+	// The header ends at the chunks array or at the end of the object
+	if err := d.readFields(); err != nil {
+		return nil, err
+	}
+	if err := checkManifestVersion(d.header.Version); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *jsonManifestDecoder) Header() ManifestHeader {
+	return d.header
+}
+
+func (d *jsonManifestDecoder) Size() uint {
+	return d.size
+}
+
+func (d *jsonManifestDecoder) Decode() (ManifestChunk, error) {
+	if d.done {
+		return ManifestChunk{}, io.EOF
+	}
+	if d.dec.More() {
+		var chunk ManifestChunk
+		if err := d.dec.Decode(&chunk); err != nil {
+			return ManifestChunk{}, d.invalid(err)
+		}
+		return chunk, nil
+	}
+	if err := d.expect(json.Delim(']')); err != nil {
+		return ManifestChunk{}, err
+	}
+	if err := d.readFields(); err != nil {
+		return ManifestChunk{}, err
+	}
+	return ManifestChunk{}, io.EOF
+}
+
+// readFields read the object fields until the chunks array or the end of the object.
+func (d *jsonManifestDecoder) readFields() error {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return d.invalid(err)
+		}
+		if tok == json.Delim('}') {
+			d.done = true
+			return nil
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v: %w", tok, ErrInvalidManifest)
+		}
+
+		var value interface{}
+		switch key {
+		case "version":
+			value = &d.header.Version
+		case "algorithm":
+			value = &d.header.Algorithm
+		case "min_size":
+			value = &d.header.MinSize
+		case "avg_size":
+			value = &d.header.AvgSize
+		case "max_size":
+			value = &d.header.MaxSize
+		case "size":
+			value = &d.size
+		case "chunks":
+			if err := d.expect(json.Delim('[')); err != nil {
+				return err
+			}
+			return nil
+		default:
+			// Skip unknown fields
+			value = new(json.RawMessage)
+		}
+		if err := d.dec.Decode(value); err != nil {
+			return d.invalid(err)
+		}
+	}
+}
+
+func (d *jsonManifestDecoder) expect(delim json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return d.invalid(err)
+	}
+	if tok != delim {
+		return fmt.Errorf("want %s, got %v: %w", delim, tok, ErrInvalidManifest)
+	}
+	return nil
+}
+
+func (d *jsonManifestDecoder) invalid(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated manifest: %w", ErrInvalidManifest)
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return fmt.Errorf("%s: %w", err, ErrInvalidManifest)
+	}
+	return err
+}
@@ -0,0 +1,282 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sekienManifest(t *testing.T, config *Config, store ChunkStore) *Manifest {
+	t.Helper()
+	file, err := os.Open("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	manifest, err := BuildManifest(context.Background(), config, file, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestBuildManifest(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	manifest := sekienManifest(t, config, store)
+
+	want := []ManifestChunk{
+		{Offset: 0, Length: 22366},
+		{Offset: 22366, Length: 8282},
+		{Offset: 30648, Length: 16303},
+		{Offset: 46951, Length: 18696},
+		{Offset: 65647, Length: 32768},
+		{Offset: 98415, Length: 11051},
+	}
+	if len(manifest.Chunks) != len(want) {
+		t.Fatalf("chunks length: want = %d, got = %d", len(want), len(manifest.Chunks))
+	}
+	for i, chunk := range manifest.Chunks {
+		if chunk.Offset != want[i].Offset || chunk.Length != want[i].Length {
+			t.Errorf("chunks[%d] : want offset = %d, got offset = %d, want length = %d, got length = %d", i, want[i].Offset, chunk.Offset, want[i].Length, chunk.Length)
+		}
+		if ok, _ := store.Has(chunk.Digest); !ok {
+			t.Errorf("chunks[%d] : not stored", i)
+		}
+	}
+	if manifest.Size != 109466 {
+		t.Errorf("size: want = 109466, got = %d", manifest.Size)
+	}
+	if err := manifest.Compatible(config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestManifestEncoding(t *testing.T) {
+	config, err := NewConfig(With32kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, nil)
+
+	// A manifest with a gap and an overlap must be encoded as is
+	broken := *manifest
+	broken.Chunks = append([]ManifestChunk(nil), manifest.Chunks...)
+	broken.Chunks[1].Offset += 10
+	broken.Chunks[2].Offset -= 100
+
+	// Wrapped keys of encrypted chunks
+	encrypted := *manifest
+	encrypted.Chunks = append([]ManifestChunk(nil), manifest.Chunks...)
+	for i := range encrypted.Chunks {
+		encrypted.Chunks[i].Key = bytes.Repeat([]byte{byte(i + 1)}, 60)
+	}
+
+	tests := map[string]struct {
+		Manifest *Manifest
+		Format   ManifestFormat
+	}{
+		"binary":        {manifest, ManifestBinary},
+		"json":          {manifest, ManifestJSON},
+		"binary broken": {&broken, ManifestBinary},
+		"json broken":   {&broken, ManifestJSON},
+		"binary keys":   {&encrypted, ManifestBinary},
+		"json keys":     {&encrypted, ManifestJSON},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := tc.Manifest.Encode(buf, tc.Format); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeManifest(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.Manifest, got) {
+				t.Errorf("want = %+v, got = %+v", tc.Manifest, got)
+			}
+		})
+	}
+
+	t.Run("json marshal", func(t *testing.T) {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeManifest(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(manifest, got) {
+			t.Errorf("want = %+v, got = %+v", manifest, got)
+		}
+	})
+
+	t.Run("binary marshal", func(t *testing.T) {
+		data, err := manifest.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// header + 3 chunks of 36 bytes + trailer
+		if len(data) > 3*36+64 {
+			t.Errorf("binary manifest too big: %d bytes", len(data))
+		}
+		got := new(Manifest)
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(manifest, got) {
+			t.Errorf("want = %+v, got = %+v", manifest, got)
+		}
+	})
+}
+
+func TestManifestDecoderStreaming(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, nil)
+
+	buf := new(bytes.Buffer)
+	if err := manifest.Encode(buf, ManifestJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := NewManifestDecoder(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is available before any chunk is decoded
+	if err := dec.Header().Compatible(config); err != nil {
+		t.Fatal(err)
+	}
+	for i := range manifest.Chunks {
+		chunk, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(chunk, manifest.Chunks[i]) {
+			t.Errorf("chunks[%d] : want = %+v, got = %+v", i, manifest.Chunks[i], chunk)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("want = EOF, got = %s", err)
+	}
+	if dec.Size() != manifest.Size {
+		t.Errorf("size: want = %d, got = %d", manifest.Size, dec.Size())
+	}
+}
+
+func TestManifestDecodeVersion1(t *testing.T) {
+	digest := Sum([]byte("fastcdc"))
+
+	// Version 1 chunks have no key
+	data := append([]byte(nil), manifestMagic...)
+	data = appendUvarint(data, 1)
+	data = appendUvarint(data, uint(len(ManifestAlgorithm)))
+	data = append(data, ManifestAlgorithm...)
+	data = appendUvarint(data, 8192)
+	data = appendUvarint(data, 16384)
+	data = appendUvarint(data, 65536)
+	data = appendUvarint(data, 7)
+	data = appendVarint(data, 0)
+	data = append(data, digest[:]...)
+	data = appendUvarint(data, 0)
+	data = appendUvarint(data, 7)
+	data = appendUvarint(data, 1)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, crcTable))
+	data = append(data, sum[:]...)
+
+	got, err := DecodeManifest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Manifest{
+		ManifestHeader: ManifestHeader{Version: 1, Algorithm: ManifestAlgorithm, MinSize: 8192, AvgSize: 16384, MaxSize: 65536},
+		Size:           7,
+		Chunks:         []ManifestChunk{{Offset: 0, Length: 7, Digest: digest}},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want = %+v, got = %+v", want, got)
+	}
+}
+
+func TestManifestDecodeErrors(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, nil)
+	data, err := manifest.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+
+	future := append([]byte(nil), data...)
+	future[len(manifestMagic)] = byte(ManifestVersion + 1)
+
+	tests := map[string]struct {
+		Data []byte
+		Want error
+	}{
+		"truncated binary":   {data[:len(data)-10], ErrInvalidManifest},
+		"corrupted binary":   {corrupted, ErrInvalidManifest},
+		"future binary":      {future, ErrUnsupportedManifestVersion},
+		"truncated json":     {[]byte(`{"version":1,"chunks":[{"offset":0,`), ErrInvalidManifest},
+		"invalid json":       {[]byte(`[]`), ErrInvalidManifest},
+		"future json":        {[]byte(`{"version":3,"chunks":[]}`), ErrUnsupportedManifestVersion},
+		"missing version":    {[]byte(`{"chunks":[]}`), ErrUnsupportedManifestVersion},
+		"invalid json value": {[]byte(`{"version":"1"}`), ErrInvalidManifest},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeManifest(bytes.NewReader(tc.Data))
+			if !errors.Is(err, tc.Want) {
+				t.Errorf("want = %s, got = %s", tc.Want, err)
+			}
+		})
+	}
+}
+
+func TestManifestCompatible(t *testing.T) {
+	config16k, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config32k, err := NewConfig(With32kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := NewManifestHeader(config16k)
+	if err := header.Compatible(config16k); err != nil {
+		t.Error(err)
+	}
+	if err := header.Compatible(config32k); !errors.Is(err, ErrIncompatibleManifest) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleManifest, err)
+	}
+	header.Algorithm = strings.ToUpper(header.Algorithm)
+	if err := header.Compatible(config16k); !errors.Is(err, ErrIncompatibleManifest) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleManifest, err)
+	}
+}
@@ -0,0 +1,196 @@
+package fastcdc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrManifestNotFound = errors.New("manifest not found")
+
+// ManifestStore keep the manifests of the files stored in a chunk store, by name.
+// The manifests are the roots of the chunks, a chunk not referenced by any manifest
+// can be garbage collected. Implementations must be safe for concurrent use.
+type ManifestStore interface {
+	// PutManifest store the manifest under the given name,
+	// replacing any manifest with the same name.
+	PutManifest(name string, manifest *Manifest) error
+	// GetManifest return the manifest with the given name
+	// or ErrManifestNotFound.
+	GetManifest(name string) (*Manifest, error)
+	// DeleteManifest remove the manifest with the given name
+	// or return ErrManifestNotFound.
+	DeleteManifest(name string) error
+	// WalkManifests call fn with every stored manifest in name order.
+	// If fn return an error, the walk stop and WalkManifests return the error.
+	WalkManifests(fn func(name string, manifest *Manifest) error) error
+}
+
+// RefCounts return the number of references to each chunk from the manifests.
+func RefCounts(manifests ManifestStore) (map[Digest]uint, error) {
+	refs := make(map[Digest]uint)
+	err := manifests.WalkManifests(func(name string, manifest *Manifest) error {
+		for _, chunk := range manifest.Chunks {
+			refs[chunk.Digest]++
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// FileManifestStore is a ManifestStore keeping each manifest in its own
+// file, in the binary format. Manifests are written atomically.
+type FileManifestStore struct {
+	root string
+}
+
+// NewFileManifestStore return a FileManifestStore rooted at the given
+// directory. The directory is created if it does not exist.
+func NewFileManifestStore(root string) (*FileManifestStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FileManifestStore{root: root}, nil
+}
+
+// PutManifest write the manifest to a file named after the escaped name.
+func (s *FileManifestStore) PutManifest(name string, manifest *Manifest) error {
+	return writeFileAtomic(s.path(name), func(w io.Writer) error {
+		return manifest.Encode(w, ManifestBinary)
+	})
+}
+
+// GetManifest read the manifest with the given name.
+func (s *FileManifestStore) GetManifest(name string) (*Manifest, error) {
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", name, ErrManifestNotFound)
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeManifest(file)
+}
+
+// DeleteManifest remove the manifest with the given name.
+func (s *FileManifestStore) DeleteManifest(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", name, ErrManifestNotFound)
+	}
+	return err
+}
+
+// WalkManifests call fn with every stored manifest in name order.
+func (s *FileManifestStore) WalkManifests(fn func(name string, manifest *Manifest) error) error {
+	files, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), manifestExt) {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(file.Name(), manifestExt))
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		manifest, err := s.GetManifest(name)
+		if err != nil {
+			// Deleted during the walk
+			if errors.Is(err, ErrManifestNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := fn(name, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const manifestExt = ".fcdm"
+
+func (s *FileManifestStore) path(name string) string {
+	return filepath.Join(s.root, url.PathEscape(name)+manifestExt)
+}
+
+// MemoryManifestStore is an in-memory ManifestStore, mostly useful for testing.
+type MemoryManifestStore struct {
+	mu        sync.RWMutex
+	manifests map[string]*Manifest
+}
+
+// NewMemoryManifestStore return an empty MemoryManifestStore.
+func NewMemoryManifestStore() *MemoryManifestStore {
+	return &MemoryManifestStore{
+		manifests: make(map[string]*Manifest),
+	}
+}
+
+// PutManifest store the manifest under the given name.
+func (s *MemoryManifestStore) PutManifest(name string, manifest *Manifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifests[name] = manifest
+	return nil
+}
+
+// GetManifest return the manifest with the given name.
+func (s *MemoryManifestStore) GetManifest(name string) (*Manifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	manifest, ok := s.manifests[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrManifestNotFound)
+	}
+	return manifest, nil
+}
+
+// DeleteManifest remove the manifest with the given name.
+func (s *MemoryManifestStore) DeleteManifest(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.manifests[name]; !ok {
+		return fmt.Errorf("%s: %w", name, ErrManifestNotFound)
+	}
+	delete(s.manifests, name)
+	return nil
+}
+
+// WalkManifests call fn with every stored manifest in name order.
+func (s *MemoryManifestStore) WalkManifests(fn func(name string, manifest *Manifest) error) error {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.manifests))
+	for name := range s.manifests {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		manifest, err := s.GetManifest(name)
+		if err != nil {
+			continue
+		}
+		if err := fn(name, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package fastcdc
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestManifestStore(t *testing.T) {
+	fileStore, err := NewFileManifestStore(filepath.Join(t.TempDir(), "manifests"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]ManifestStore{
+		"memory": NewMemoryManifestStore(),
+		"file":   fileStore,
+	}
+
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, NewMemoryStore())
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			names := []string{"backups/2020-01-01.tar", "a b", "sekien"}
+			for _, name := range names {
+				if err := store.PutManifest(name, manifest); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := store.GetManifest("backups/2020-01-01.tar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(manifest, got) {
+				t.Error("manifest mismatch")
+			}
+
+			walked := make([]string, 0)
+			err = store.WalkManifests(func(name string, manifest *Manifest) error {
+				walked = append(walked, name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"a b", "backups/2020-01-01.tar", "sekien"}
+			if !reflect.DeepEqual(want, walked) {
+				t.Errorf("want = %v, got = %v", want, walked)
+			}
+
+			refs, err := RefCounts(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(refs) != len(manifest.Chunks) {
+				t.Errorf("refs length: want = %d, got = %d", len(manifest.Chunks), len(refs))
+			}
+			if n := refs[manifest.Chunks[0].Digest]; n != 3 {
+				t.Errorf("refs: want = 3, got = %d", n)
+			}
+
+			if err := store.DeleteManifest("a b"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetManifest("a b"); !errors.Is(err, ErrManifestNotFound) {
+				t.Errorf("want = %s, got = %s", ErrManifestNotFound, err)
+			}
+			if err := store.DeleteManifest("a b"); !errors.Is(err, ErrManifestNotFound) {
+				t.Errorf("want = %s, got = %s", ErrManifestNotFound, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package fastcdc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryStore is an in-memory ChunkStore, mostly useful for testing.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	chunks map[Digest][]byte
+}
+
+// NewMemoryStore return an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		chunks: make(map[Digest][]byte),
+	}
+}
+
+// Put store a copy of the chunk.
+func (s *MemoryStore) Put(digest Digest, chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chunks[digest]; ok {
+		return nil
+	}
+	s.chunks[digest] = append([]byte(nil), chunk...)
+	return nil
+}
+
+// Replace store a copy of the chunk, replacing the stored chunk if any.
+func (s *MemoryStore) Replace(digest Digest, chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks[digest] = append([]byte(nil), chunk...)
+	return nil
+}
+
+// Get return a copy of the chunk with the given digest.
+func (s *MemoryStore) Get(digest Digest) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chunk, ok := s.chunks[digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return append([]byte(nil), chunk...), nil
+}
+
+// Has report whether the chunk with the given digest is stored.
+func (s *MemoryStore) Has(digest Digest) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.chunks[digest]
+	return ok, nil
+}
+
+// Delete remove the chunk with the given digest.
+func (s *MemoryStore) Delete(digest Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chunks[digest]; !ok {
+		return fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	delete(s.chunks, digest)
+	return nil
+}
+
+// Len return the number of stored chunks.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.chunks)
+}
+
+// Walk call fn with the digest of every stored chunk, in increasing digest order.
+func (s *MemoryStore) Walk(fn func(digest Digest) error) error {
+	s.mu.RLock()
+	digests := make([]Digest, 0, len(s.chunks))
+	for digest := range s.chunks {
+		digests = append(digests, digest)
+	}
+	s.mu.RUnlock()
+	return walkDigests(digests, fn)
+}
@@ -1,17 +1,22 @@
 package fastcdc
 
-type Option func(*config)
+type Option func(*options)
 
-type config struct {
+type options struct {
 	bufferSize uint
 	minSize    uint
 	avgSize    uint
 	maxSize    uint
 	stream     bool
+	lowLatency bool
+	readAhead  bool
+
+	progressFn       ProgressFn
+	progressInterval uint
 }
 
-func defaultConfig() *config {
-	return &config{
+func defaultOptions() *options {
+	return &options{
 		minSize: 32_768,
 		avgSize: 65_536,
 		maxSize: 131_072,
@@ -26,14 +31,14 @@ func defaultConfig() *config {
 // the same whatever the buffer size is set to.
 // Default is set to 2 * max size.
 func WithBufferSize(n uint) Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.bufferSize = n
 	}
 }
 
 // WithChunksSize set custom chunk size.
 func WithChunksSize(min, avg, max uint) Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.minSize = min
 		c.avgSize = avg
 		c.maxSize = max
@@ -42,7 +47,7 @@ func WithChunksSize(min, avg, max uint) Option {
 
 // With16kChunks set the 16kb average chunks size preset.
 func With16kChunks() Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.minSize = 8192
 		c.avgSize = 16_834
 		c.maxSize = 32_768
@@ -51,7 +56,7 @@ func With16kChunks() Option {
 
 // With32kChunks set the 32kb average chunks size preset.
 func With32kChunks() Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.minSize = 16384
 		c.avgSize = 32_768
 		c.maxSize = 65_536
@@ -63,7 +68,7 @@ func With32kChunks() Option {
 // for optimal end-to-end deduplication and compression.
 // https://www.usenix.org/system/files/conference/atc12/atc12-final293.pdf
 func With64kChunks() Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.minSize = 32_768
 		c.avgSize = 65_536
 		c.maxSize = 131_072
@@ -72,7 +77,45 @@ func With64kChunks() Option {
 
 // WithStreamMode set the chunker in stream mode.
 func WithStreamMode() Option {
-	return func(c *config) {
+	return func(c *options) {
 		c.stream = true
 	}
 }
+
+// WithLowLatencyMode set the chunker in low latency stream mode.
+// Instead of waiting for the internal buffer to be completely
+// filled, a chunk is emitted as soon as its boundary is decided,
+// that is when the cut-point is found or when max size bytes past
+// the chunk start are available. The chunks are the same as in
+// stream mode, which is implied by this option. It can't be
+// combined with WithReadAhead.
+func WithLowLatencyMode() Option {
+	return func(c *options) {
+		c.lowLatency = true
+	}
+}
+
+// WithProgress register a progress function called each time
+// interval bytes have been consumed from the readers, and once
+// more when the split is finalized. If interval is 0, the progress
+// function is called after every read.
+func WithProgress(interval uint, fn ProgressFn) Option {
+	return func(c *options) {
+		c.progressInterval = interval
+		c.progressFn = fn
+	}
+}
+
+// WithReadAhead enable the read-ahead double buffering. A background
+// goroutine fill a second buffer while the current one is being scanned,
+// which overlaps the I/O with the hashing. The internal buffer is always
+// completely filled before the scan, the chunks are the same as with the
+// synchronous chunker. It doubles the memory used by the chunker.
+// Since it waits for complete buffers, it can't be combined with
+// WithLowLatencyMode and the configuration is rejected with
+// ErrIncompatibleOptions.
+func WithReadAhead() Option {
+	return func(c *options) {
+		c.readAhead = true
+	}
+}
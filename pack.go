@@ -0,0 +1,225 @@
+package fastcdc
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+var ErrInvalidPack = errors.New("invalid pack")
+
+const (
+	packVersion     = 1
+	packEntrySize   = sha256.Size + 16
+	packTrailerSize = 16 + sha256.Size
+)
+
+// packMagic start every pack.
+var packMagic = []byte("FCPK")
+
+// PackEntry is the location of a chunk in a pack.
+type PackEntry struct {
+	Digest Digest
+	Offset uint
+	Length uint
+}
+
+// PackWriter append many chunks into a single pack. Packs are laid out as follow:
+//  magic "FCPK" | version (1 byte)
+//  chunks data
+//  index, for each chunk: digest (32 bytes) | offset | length
+//  entries count | index offset | sha256 checksum of all the preceding bytes (32 bytes)
+// All integers are 64 bits big endian. The checksum also identify the pack.
+type PackWriter struct {
+	w       io.Writer
+	hash    hash.Hash
+	size    uint
+	entries []PackEntry
+}
+
+// NewPackWriter write the pack header to w and return a PackWriter. Writes
+// are not buffered, a chunk can be read from the underlying file once added.
+func NewPackWriter(w io.Writer) (*PackWriter, error) {
+	p := &PackWriter{
+		w:       w,
+		hash:    sha256.New(),
+		entries: make([]PackEntry, 0),
+	}
+	if err := p.write(append(append([]byte(nil), packMagic...), packVersion)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Add append the chunk to the pack and return its location.
+func (p *PackWriter) Add(digest Digest, chunk []byte) (PackEntry, error) {
+	entry := PackEntry{Digest: digest, Offset: p.size, Length: uint(len(chunk))}
+	if err := p.write(chunk); err != nil {
+		return PackEntry{}, err
+	}
+	p.entries = append(p.entries, entry)
+	return entry, nil
+}
+
+// Size return the number of bytes written so far.
+func (p *PackWriter) Size() uint {
+	return p.size
+}
+
+// Entries return the chunks added so far.
+func (p *PackWriter) Entries() []PackEntry {
+	return p.entries
+}
+
+// Close write the index and the trailer and return the pack checksum.
+// It doesn't close the underlying writer.
+func (p *PackWriter) Close() (Digest, error) {
+	indexOffset := p.size
+	buf := make([]byte, 0, len(p.entries)*packEntrySize+16)
+	for _, entry := range p.entries {
+		buf = append(buf, entry.Digest[:]...)
+		buf = appendUint64(buf, uint64(entry.Offset))
+		buf = appendUint64(buf, uint64(entry.Length))
+	}
+	buf = appendUint64(buf, uint64(len(p.entries)))
+	buf = appendUint64(buf, uint64(indexOffset))
+	if err := p.write(buf); err != nil {
+		return Digest{}, err
+	}
+
+	var checksum Digest
+	copy(checksum[:], p.hash.Sum(nil))
+	if _, err := p.w.Write(checksum[:]); err != nil {
+		return Digest{}, err
+	}
+	return checksum, nil
+}
+
+func (p *PackWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.hash.Write(data[:n])
+	p.size += uint(n)
+	return err
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+// PackReader read the chunks of a pack.
+type PackReader struct {
+	r        io.ReaderAt
+	size     int64
+	checksum Digest
+	entries  []PackEntry
+	index    map[Digest]int
+}
+
+// NewPackReader read the pack index. The pack checksum is not verified, use Verify.
+func NewPackReader(r io.ReaderAt, size int64) (*PackReader, error) {
+	headerSize := int64(len(packMagic) + 1)
+	if size < headerSize+packTrailerSize {
+		return nil, fmt.Errorf("pack too small: %w", ErrInvalidPack)
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:len(packMagic)]) != string(packMagic) {
+		return nil, fmt.Errorf("bad magic: %w", ErrInvalidPack)
+	}
+	if header[len(packMagic)] != packVersion {
+		return nil, fmt.Errorf("unsupported version %d: %w", header[len(packMagic)], ErrInvalidPack)
+	}
+
+	trailer := make([]byte, packTrailerSize)
+	if _, err := r.ReadAt(trailer, size-packTrailerSize); err != nil {
+		return nil, err
+	}
+	count := binary.BigEndian.Uint64(trailer[0:8])
+	indexOffset := binary.BigEndian.Uint64(trailer[8:16])
+	indexEnd := uint64(size - packTrailerSize)
+	if indexOffset < uint64(headerSize) || indexOffset > indexEnd || (indexEnd-indexOffset)%packEntrySize != 0 || (indexEnd-indexOffset)/packEntrySize != count {
+		return nil, fmt.Errorf("bad index: %w", ErrInvalidPack)
+	}
+
+	p := &PackReader{
+		r:       r,
+		size:    size,
+		entries: make([]PackEntry, count),
+		index:   make(map[Digest]int, count),
+	}
+	copy(p.checksum[:], trailer[16:])
+
+	index := make([]byte, count*packEntrySize)
+	if _, err := r.ReadAt(index, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+	for i := range p.entries {
+		entry := index[i*packEntrySize : (i+1)*packEntrySize]
+		copy(p.entries[i].Digest[:], entry[:sha256.Size])
+		offset := binary.BigEndian.Uint64(entry[sha256.Size:])
+		length := binary.BigEndian.Uint64(entry[sha256.Size+8:])
+		// Offset + length may overflow with a corrupted index
+		if offset < uint64(headerSize) || offset > indexOffset || length > indexOffset-offset {
+			return nil, fmt.Errorf("chunk %s out of bounds: %w", p.entries[i].Digest, ErrInvalidPack)
+		}
+		p.entries[i].Offset = uint(offset)
+		p.entries[i].Length = uint(length)
+		p.index[p.entries[i].Digest] = i
+	}
+	return p, nil
+}
+
+// Checksum return the pack checksum recorded in the trailer.
+func (p *PackReader) Checksum() Digest {
+	return p.checksum
+}
+
+// Entries return the location of every chunk in the pack.
+func (p *PackReader) Entries() []PackEntry {
+	return p.entries
+}
+
+// Has report whether the chunk is in the pack.
+func (p *PackReader) Has(digest Digest) bool {
+	_, ok := p.index[digest]
+	return ok
+}
+
+// Get return the chunk with the given digest.
+func (p *PackReader) Get(digest Digest) ([]byte, error) {
+	i, ok := p.index[digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return readPackEntry(p.r, p.entries[i])
+}
+
+// Verify recompute the pack checksum and compare it with the trailer.
+func (p *PackReader) Verify() error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(p.r, 0, p.size-sha256.Size)); err != nil {
+		return err
+	}
+	var checksum Digest
+	copy(checksum[:], h.Sum(nil))
+	if checksum != p.checksum {
+		return fmt.Errorf("checksum mismatch: %w", ErrInvalidPack)
+	}
+	return nil
+}
+
+func readPackEntry(r io.ReaderAt, entry PackEntry) ([]byte, error) {
+	chunk := make([]byte, entry.Length)
+	if _, err := r.ReadAt(chunk, int64(entry.Offset)); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
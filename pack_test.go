@@ -0,0 +1,89 @@
+package fastcdc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := NewPackWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := make(map[Digest][]byte)
+	for i := 0; i < 100; i++ {
+		chunk := randomData(i, 1000+i)
+		digest := Sum(chunk)
+		chunks[digest] = chunk
+		if _, err := writer.Add(digest, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksum, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	reader, err := NewPackReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Checksum() != checksum {
+		t.Errorf("checksum: want = %s, got = %s", checksum, reader.Checksum())
+	}
+	if err := reader.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.Entries()) != len(chunks) {
+		t.Errorf("entries: want = %d, got = %d", len(chunks), len(reader.Entries()))
+	}
+	for digest, want := range chunks {
+		got, err := reader.Get(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("chunk %s mismatch", digest)
+		}
+	}
+	if _, err := reader.Get(Sum(nil)); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := append([]byte(nil), data...)
+		corrupted[100] ^= 0xff
+		reader, err := NewPackReader(bytes.NewReader(corrupted), int64(len(corrupted)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reader.Verify(); !errors.Is(err, ErrInvalidPack) {
+			t.Errorf("want = %s, got = %s", ErrInvalidPack, err)
+		}
+	})
+
+	t.Run("length overflow", func(t *testing.T) {
+		corrupted := append([]byte(nil), data...)
+		trailer := corrupted[len(corrupted)-packTrailerSize:]
+		indexOffset := binary.BigEndian.Uint64(trailer[8:16])
+		// Offset + length wraps around below the index offset
+		binary.BigEndian.PutUint64(corrupted[indexOffset+sha256.Size+8:], math.MaxUint64)
+		if _, err := NewPackReader(bytes.NewReader(corrupted), int64(len(corrupted))); !errors.Is(err, ErrInvalidPack) {
+			t.Errorf("want = %s, got = %v", ErrInvalidPack, err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := data[:len(data)-100]
+		if _, err := NewPackReader(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, ErrInvalidPack) {
+			t.Errorf("want = %s, got = %s", ErrInvalidPack, err)
+		}
+	})
+}
@@ -0,0 +1,518 @@
+package fastcdc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	packExt        = ".pack"
+	tombstonesFile = "tombstones"
+	// DefaultMaxPackSize is the default size at which a pack is rolled over.
+	DefaultMaxPackSize uint = 64 * 1024 * 1024
+)
+
+// packLocation is the location of a chunk in the packs of a PackStore.
+type packLocation struct {
+	pack  Digest
+	entry PackEntry
+}
+
+// packFile is a sealed pack of a PackStore.
+type packFile struct {
+	file    *os.File
+	entries []PackEntry
+}
+
+// PackStore is a ChunkStore aggregating the chunks into large pack files, which keeps the
+// number of files low with small chunks. New chunks are appended to the current pack until it
+// reach the maximum pack size, then the pack is sealed and a new one is started. A global index,
+// rebuilt from the pack indexes when the store is opened, map every digest to its pack.
+//
+// Chunks of the current pack are only durable once the pack is sealed, either on rollover or
+// when calling Flush or Close. Sealed packs are immutable, deleting a chunk records a tombstone
+// and the space is only reclaimed by a repack. PackStore is safe for concurrent use.
+type PackStore struct {
+	mu          sync.RWMutex
+	root        string
+	maxPackSize uint
+	index       map[Digest]packLocation
+	packs       map[Digest]*packFile
+	tombstones  *os.File
+	dead        map[[2]Digest]struct{}
+	current     *PackWriter
+	currentFile *os.File
+	pending     map[Digest]PackEntry
+}
+
+// NewPackStore open the pack store rooted at the given directory, creating it if needed.
+// Packs are rolled over once they reach maxPackSize, if 0 DefaultMaxPackSize is used.
+func NewPackStore(root string, maxPackSize uint) (*PackStore, error) {
+	if maxPackSize == 0 {
+		maxPackSize = DefaultMaxPackSize
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &PackStore{
+		root:        root,
+		maxPackSize: maxPackSize,
+		index:       make(map[Digest]packLocation),
+		packs:       make(map[Digest]*packFile),
+		pending:     make(map[Digest]PackEntry),
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load rebuild the global index from the packs and the tombstones.
+func (s *PackStore) load() error {
+	tombstones, err := s.readTombstones()
+	if err != nil {
+		return err
+	}
+	s.dead = tombstones
+
+	files, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		// Remove the packs which were never sealed
+		if strings.HasPrefix(name, ".tmp-") {
+			if err := os.Remove(filepath.Join(s.root, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, packExt) {
+			continue
+		}
+		id, err := ParseDigest(strings.TrimSuffix(name, packExt))
+		if err != nil {
+			continue
+		}
+		reader, err := s.openPack(id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range reader.Entries() {
+			if _, ok := tombstones[[2]Digest{entry.Digest, id}]; ok {
+				continue
+			}
+			s.index[entry.Digest] = packLocation{pack: id, entry: entry}
+		}
+	}
+
+	s.tombstones, err = os.OpenFile(filepath.Join(s.root, tombstonesFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// readTombstones read the deleted chunks. A tombstone is the digest of the
+// chunk followed by the pack checksum, so a chunk deleted from a pack and
+// stored again in another pack is not deleted when the store is opened.
+func (s *PackStore) readTombstones() (map[[2]Digest]struct{}, error) {
+	tombstones := make(map[[2]Digest]struct{})
+	file, err := os.Open(filepath.Join(s.root, tombstonesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tombstones, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var tombstone [2]Digest
+	for {
+		if _, err := io.ReadFull(r, tombstone[0][:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// Ignore a tombstone partially written before a crash
+				return tombstones, nil
+			}
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, tombstone[1][:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return tombstones, nil
+			}
+			return nil, err
+		}
+		tombstones[tombstone] = struct{}{}
+	}
+}
+
+func (s *PackStore) openPack(id Digest) (*PackReader, error) {
+	file, err := os.Open(s.packPath(id))
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	reader, err := NewPackReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("pack %s: %w", id, err)
+	}
+	s.packs[id] = &packFile{file: file, entries: reader.Entries()}
+	return reader, nil
+}
+
+// Put append the chunk to the current pack.
+func (s *PackStore) Put(digest Digest, chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[digest]; ok {
+		return nil
+	}
+	if _, ok := s.pending[digest]; ok {
+		return nil
+	}
+	return s.add(digest, chunk)
+}
+
+// add append the chunk to the current pack, starting a new pack if needed.
+func (s *PackStore) add(digest Digest, chunk []byte) error {
+	if s.current == nil {
+		file, err := ioutil.TempFile(s.root, ".tmp-")
+		if err != nil {
+			return err
+		}
+		writer, err := NewPackWriter(file)
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+		s.current = writer
+		s.currentFile = file
+	}
+
+	entry, err := s.current.Add(digest, chunk)
+	if err != nil {
+		return err
+	}
+	s.pending[digest] = entry
+
+	if s.current.Size() >= s.maxPackSize {
+		return s.seal()
+	}
+	return nil
+}
+
+// Get return the chunk with the given digest.
+func (s *PackStore) Get(digest Digest) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry, ok := s.pending[digest]; ok {
+		return readPackEntry(s.currentFile, entry)
+	}
+	location, ok := s.index[digest]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+	return readPackEntry(s.packs[location.pack].file, location.entry)
+}
+
+// Has report whether the chunk with the given digest is stored.
+func (s *PackStore) Has(digest Digest) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.pending[digest]; ok {
+		return true, nil
+	}
+	_, ok := s.index[digest]
+	return ok, nil
+}
+
+// Delete record a tombstone for the chunk. The space used by the
+// chunk in its pack is only reclaimed by a repack.
+func (s *PackStore) Delete(digest Digest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The current pack must be sealed to record the tombstone
+	if _, ok := s.pending[digest]; ok {
+		if err := s.seal(); err != nil {
+			return err
+		}
+	}
+
+	location, ok := s.index[digest]
+	if !ok {
+		return fmt.Errorf("%s: %w", digest, ErrChunkNotFound)
+	}
+
+	tombstone := make([]byte, 0, 2*len(digest))
+	tombstone = append(tombstone, digest[:]...)
+	tombstone = append(tombstone, location.pack[:]...)
+	if _, err := s.tombstones.Write(tombstone); err != nil {
+		return err
+	}
+	if err := s.tombstones.Sync(); err != nil {
+		return err
+	}
+	s.dead[[2]Digest{digest, location.pack}] = struct{}{}
+	delete(s.index, digest)
+	return nil
+}
+
+// Locate return the checksum of the pack containing the chunk. It
+// return false if the chunk is not stored or not yet in a sealed pack.
+func (s *PackStore) Locate(digest Digest) (Digest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	location, ok := s.index[digest]
+	return location.pack, ok
+}
+
+// Flush seal the current pack, making all the stored chunks durable.
+func (s *PackStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seal()
+}
+
+// Close seal the current pack and close the store.
+func (s *PackStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.seal()
+	for id, pack := range s.packs {
+		if cerr := pack.file.Close(); err == nil {
+			err = cerr
+		}
+		delete(s.packs, id)
+	}
+	if s.tombstones != nil {
+		if cerr := s.tombstones.Close(); err == nil {
+			err = cerr
+		}
+		s.tombstones = nil
+	}
+	return err
+}
+
+// seal write the index of the current pack, sync it to the disk and
+// rename it after its checksum. The pending chunks are then moved to
+// the global index.
+func (s *PackStore) seal() error {
+	if s.current == nil {
+		return nil
+	}
+	writer, file := s.current, s.currentFile
+
+	checksum, err := writer.Close()
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), s.packPath(checksum))
+	}
+	if err != nil {
+		return err
+	}
+
+	// The same content always produce the same pack
+	if previous, ok := s.packs[checksum]; ok {
+		previous.file.Close()
+	}
+	s.packs[checksum] = &packFile{file: file, entries: writer.Entries()}
+	revived := false
+	for digest, entry := range s.pending {
+		s.index[digest] = packLocation{pack: checksum, entry: entry}
+		// A chunk deleted then stored again can land in a pack with the
+		// same checksum, its tombstone would delete it on the next load.
+		if _, ok := s.dead[[2]Digest{digest, checksum}]; ok {
+			delete(s.dead, [2]Digest{digest, checksum})
+			revived = true
+		}
+	}
+	s.current = nil
+	s.currentFile = nil
+	s.pending = make(map[Digest]PackEntry)
+	if revived {
+		return s.writeTombstones()
+	}
+	return nil
+}
+
+// Walk call fn with the digest of every stored chunk, in increasing digest order.
+// The chunks stored during the walk may not be visited.
+func (s *PackStore) Walk(fn func(digest Digest) error) error {
+	s.mu.RLock()
+	digests := make([]Digest, 0, len(s.index)+len(s.pending))
+	for digest := range s.index {
+		digests = append(digests, digest)
+	}
+	for digest := range s.pending {
+		digests = append(digests, digest)
+	}
+	s.mu.RUnlock()
+	return walkDigests(digests, fn)
+}
+
+// Repack compact every sealed pack where the fraction of bytes used by deleted chunks is
+// at least threshold. The live chunks are copied to new packs which are sealed before the
+// old packs are removed, a crash at any point leaves at least one copy of every live chunk.
+// Repack return the number of compacted packs.
+func (s *PackStore) Repack(threshold float64) (int, error) {
+	s.mu.RLock()
+	ids := make([]Digest, 0, len(s.packs))
+	for id := range s.packs {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	compacted := make(map[Digest]*packFile)
+	for _, id := range ids {
+		// Only lock a pack at a time so new writes are not blocked for too long
+		pack, err := s.copyLive(id, threshold)
+		if err != nil {
+			return 0, err
+		}
+		if pack != nil {
+			compacted[id] = pack
+		}
+	}
+	if len(compacted) == 0 {
+		return 0, nil
+	}
+
+	if err := s.removePacks(compacted); err != nil {
+		return 0, err
+	}
+	return len(compacted), s.compactTombstones()
+}
+
+// copyLive copy the live chunks of the pack to the current pack if the pack
+// has enough dead bytes, and return the copied pack.
+func (s *PackStore) copyLive(id Digest, threshold float64) (*packFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pack, ok := s.packs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	var total, dead uint
+	live := make([]PackEntry, 0, len(pack.entries))
+	for _, entry := range pack.entries {
+		total += entry.Length
+		if location, ok := s.index[entry.Digest]; ok && location.pack == id {
+			live = append(live, entry)
+		} else {
+			dead += entry.Length
+		}
+	}
+	if total > 0 && float64(dead)/float64(total) < threshold {
+		return nil, nil
+	}
+
+	for _, entry := range live {
+		// Already copied from another pack
+		if _, ok := s.pending[entry.Digest]; ok {
+			continue
+		}
+		chunk, err := readPackEntry(pack.file, entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.add(entry.Digest, chunk); err != nil {
+			return nil, err
+		}
+	}
+	return pack, nil
+}
+
+// removePacks seal the current pack, making the copied chunks durable,
+// then remove the compacted packs.
+func (s *PackStore) removePacks(compacted map[Digest]*packFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.seal(); err != nil {
+		return err
+	}
+	for id, pack := range compacted {
+		// The pack may have been rewritten with the exact same content
+		if s.packs[id] != pack {
+			continue
+		}
+		if s.references(id, pack) {
+			continue
+		}
+		pack.file.Close()
+		delete(s.packs, id)
+		if err := os.Remove(s.packPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// references report whether a chunk of the global index is still located in the pack.
+func (s *PackStore) references(id Digest, pack *packFile) bool {
+	for _, entry := range pack.entries {
+		if location, ok := s.index[entry.Digest]; ok && location.pack == id {
+			return true
+		}
+	}
+	return false
+}
+
+// compactTombstones drop the tombstones of the removed packs.
+func (s *PackStore) compactTombstones() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for tombstone := range s.dead {
+		if _, ok := s.packs[tombstone[1]]; !ok {
+			delete(s.dead, tombstone)
+		}
+	}
+	return s.writeTombstones()
+}
+
+// writeTombstones atomically rewrite the tombstones file from the in-memory tombstones.
+func (s *PackStore) writeTombstones() error {
+	path := filepath.Join(s.root, tombstonesFile)
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for tombstone := range s.dead {
+			bw.Write(tombstone[0][:])
+			bw.Write(tombstone[1][:])
+		}
+		return bw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	s.tombstones.Close()
+	s.tombstones, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (s *PackStore) packPath(id Digest) string {
+	return filepath.Join(s.root, id.String()+packExt)
+}
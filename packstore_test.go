@@ -0,0 +1,181 @@
+package fastcdc
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func countPacks(t *testing.T, root string) int {
+	t.Helper()
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), packExt) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPackStore(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewPackStore(root, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := make(map[Digest][]byte)
+	for i := 0; i < 100; i++ {
+		chunk := randomData(i, 8192)
+		digest := Sum(chunk)
+		chunks[digest] = chunk
+		if err := store.Put(digest, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Chunks of the current pack are readable before the pack is sealed
+	for digest, want := range chunks {
+		got, err := store.Get(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("chunk %s mismatch", digest)
+		}
+	}
+
+	// 800kb of chunks rolled over in packs of 64kb
+	if n := countPacks(t, root); n != 12 {
+		t.Errorf("packs: want = 12, got = %d", n)
+	}
+
+	var deleted Digest
+	for digest := range chunks {
+		deleted = digest
+		break
+	}
+	if err := store.Delete(deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(deleted); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsealed pack are discarded when the store is opened
+	if err := ioutil.WriteFile(filepath.Join(root, ".tmp-123"), []byte("partial pack"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewPackStore(root, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { store.Close() }()
+
+	for digest, want := range chunks {
+		got, err := store.Get(digest)
+		if digest == deleted {
+			if !errors.Is(err, ErrChunkNotFound) {
+				t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("chunk %s mismatch", digest)
+		}
+		if _, ok := store.Locate(digest); !ok {
+			t.Errorf("chunk %s not in a sealed pack", digest)
+		}
+	}
+
+	// A deleted chunk can be stored again
+	if err := store.Put(deleted, chunks[deleted]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tombstone only apply to the previous pack
+	store, err = NewPackStore(root, 64*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Has(deleted); !ok {
+		t.Errorf("chunk %s not stored", deleted)
+	}
+}
+
+func TestPackStoreDeleteAndPutAgain(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewPackStore(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := randomData(80, 4096)
+	digest := Sum(chunk)
+
+	// Storing the chunk again produce a pack with the same checksum
+	// as the pack holding the tombstoned chunk
+	if err := store.Put(digest, chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(digest, chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewPackStore(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	got, err := store.Get(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chunk, got) {
+		t.Error("chunk mismatch")
+	}
+
+	// Deleting it again still survive a reopen
+	if err := store.Delete(digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewPackStore(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if ok, _ := store.Has(digest); ok {
+		t.Errorf("chunk %s not deleted", digest)
+	}
+}
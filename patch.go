@@ -0,0 +1,223 @@
+package fastcdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidPatch  = errors.New("invalid patch")
+	ErrPatchMismatch = errors.New("patched file digest mismatch")
+)
+
+// patchMagic start every patch.
+var patchMagic = []byte("FCDP")
+
+const patchVersion = 1
+
+// Patch instructions.
+const (
+	patchEnd    byte = 0
+	patchCopy   byte = 1
+	patchInsert byte = 2
+)
+
+// PatchStats summarize a patch.
+type PatchStats struct {
+	Copied   uint // Number of bytes copied from the old file
+	Inserted uint // Number of literal bytes in the patch
+}
+
+// CreatePatch write to w a patch rebuilding the file read from r from an older version of
+// the file described by old. The new file is split with a chunker session of config, each
+// chunk found in old is copied from the old file while other chunks are inserted as is.
+// The configuration must match the manifest, or CreatePatch fail with ErrIncompatibleManifest.
+//
+// Patch layout, all integers are uvarint encoded:
+//
+//	magic "FCDP" | version
+//	copy instruction: 1 | offset in the old file | length
+//	insert instruction: 2 | length | bytes
+//	end: 0 | new file size | sha256 of the new file (32 bytes)
+func CreatePatch(ctx context.Context, config *Config, old *Manifest, r io.Reader, w io.Writer) (PatchStats, error) {
+	var stats PatchStats
+	if err := old.Compatible(config); err != nil {
+		return stats, err
+	}
+
+	chunks := make(map[Digest]ManifestChunk, len(old.Chunks))
+	for _, chunk := range old.Chunks {
+		if _, ok := chunks[chunk.Digest]; !ok {
+			chunks[chunk.Digest] = chunk
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	buf := append([]byte(nil), patchMagic...)
+	buf = appendUvarint(buf, patchVersion)
+	if _, err := bw.Write(buf); err != nil {
+		return stats, err
+	}
+
+	// Copies of adjacent chunks of the old file are merged
+	var pending ByteRange
+	flushCopy := func() error {
+		if pending.Length == 0 {
+			return nil
+		}
+		buf = append(buf[:0], patchCopy)
+		buf = appendUvarint(buf, pending.Offset)
+		buf = appendUvarint(buf, pending.Length)
+		pending = ByteRange{}
+		_, err := bw.Write(buf)
+		return err
+	}
+
+	hash := sha256.New()
+	var size uint
+	fn := func(offset, length uint, chunk []byte) error {
+		hash.Write(chunk)
+		size = offset + length
+
+		if old, ok := chunks[Sum(chunk)]; ok {
+			stats.Copied += length
+			if pending.Length > 0 && pending.Offset+pending.Length == old.Offset {
+				pending.Length += old.Length
+				return nil
+			}
+			if err := flushCopy(); err != nil {
+				return err
+			}
+			pending = ByteRange{Offset: old.Offset, Length: old.Length}
+			return nil
+		}
+
+		stats.Inserted += length
+		if err := flushCopy(); err != nil {
+			return err
+		}
+		buf = append(buf[:0], patchInsert)
+		buf = appendUvarint(buf, length)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		_, err := bw.Write(chunk)
+		return err
+	}
+
+	chunker := config.NewChunker(ctx)
+	defer chunker.Release()
+	if err := chunker.Split(r, fn); err != nil {
+		return stats, err
+	}
+	if err := chunker.Finalize(fn); err != nil {
+		return stats, err
+	}
+	if err := flushCopy(); err != nil {
+		return stats, err
+	}
+
+	buf = append(buf[:0], patchEnd)
+	buf = appendUvarint(buf, size)
+	buf = hash.Sum(buf)
+	if _, err := bw.Write(buf); err != nil {
+		return stats, err
+	}
+	return stats, bw.Flush()
+}
+
+// ApplyPatch write to w the file rebuilt from the old file and the patch. The digest of the
+// rebuilt file is checked against the digest recorded in the patch, ApplyPatch return
+// ErrPatchMismatch if the patch was not created from the same version of the old file.
+// Since the output is written while the patch is applied, w should be discarded on error.
+func ApplyPatch(w io.Writer, old io.ReaderAt, patch io.Reader) error {
+	br := bufio.NewReader(patch)
+	magic := make([]byte, len(patchMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, patchMagic) {
+		return fmt.Errorf("missing magic: %w", ErrInvalidPatch)
+	}
+	version, err := readPatchUvarint(br)
+	if err != nil {
+		return err
+	}
+	if version != patchVersion {
+		return fmt.Errorf("unsupported version %d: %w", version, ErrInvalidPatch)
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	var size uint
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("truncated patch: %w", ErrInvalidPatch)
+		}
+
+		switch op {
+		case patchCopy:
+			offset, err := readPatchUvarint(br)
+			if err != nil {
+				return err
+			}
+			length, err := readPatchUvarint(br)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(out, io.NewSectionReader(old, int64(offset), int64(length)))
+			if err != nil {
+				return err
+			}
+			if uint(n) != length {
+				return fmt.Errorf("copy past the end of the old file: %w", ErrPatchMismatch)
+			}
+			size += length
+		case patchInsert:
+			length, err := readPatchUvarint(br)
+			if err != nil {
+				return err
+			}
+			n, err := io.CopyN(out, br, int64(length))
+			if err != nil {
+				if err == io.EOF {
+					return fmt.Errorf("truncated insert of %d bytes after %d bytes: %w", length, n, ErrInvalidPatch)
+				}
+				return err
+			}
+			size += length
+		case patchEnd:
+			want, err := readPatchUvarint(br)
+			if err != nil {
+				return err
+			}
+			var digest Digest
+			if _, err := io.ReadFull(br, digest[:]); err != nil {
+				return fmt.Errorf("truncated patch: %w", ErrInvalidPatch)
+			}
+			if want != size {
+				return fmt.Errorf("size %d differ from %d: %w", size, want, ErrPatchMismatch)
+			}
+			var got Digest
+			hash.Sum(got[:0])
+			if got != digest {
+				return ErrPatchMismatch
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown instruction %d: %w", op, ErrInvalidPatch)
+		}
+	}
+}
+
+func readPatchUvarint(r io.ByteReader) (uint, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("truncated patch: %w", ErrInvalidPatch)
+	}
+	return uint(v), nil
+}
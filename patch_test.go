@@ -0,0 +1,110 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := randomData(11, 1024*1024)
+	oldManifest, err := BuildManifest(context.Background(), config, bytes.NewReader(old), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	tests := map[string]struct {
+		New      []byte
+		MaxPatch int
+	}{
+		"identical": {old, 1024},
+		"insertion": {concat(old[:300000], []byte("inserted bytes"), old[300000:]), 3 * 64 * 1024},
+		"deletion":  {concat(old[:300000], old[310000:]), 3 * 64 * 1024},
+		"appended":  {concat(old, randomData(12, 5000)), 64*1024 + 5000},
+		"empty":     {[]byte{}, 64},
+		"unrelated": {randomData(13, 200000), 201000},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			patch := new(bytes.Buffer)
+			stats, err := CreatePatch(context.Background(), config, oldManifest, bytes.NewReader(tc.New), patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Copied+stats.Inserted != uint(len(tc.New)) {
+				t.Errorf("copied + inserted: want = %d, got = %d", len(tc.New), stats.Copied+stats.Inserted)
+			}
+			if patch.Len() > tc.MaxPatch {
+				t.Errorf("patch size: want <= %d, got = %d", tc.MaxPatch, patch.Len())
+			}
+
+			output := new(bytes.Buffer)
+			if err := ApplyPatch(output, bytes.NewReader(old), bytes.NewReader(patch.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.New, output.Bytes()) {
+				t.Error("patched file mismatch")
+			}
+		})
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := randomData(11, 256*1024)
+	oldManifest, err := BuildManifest(context.Background(), config, bytes.NewReader(old), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := append(append([]byte(nil), old[:100000]...), old[100100:]...)
+
+	patch := new(bytes.Buffer)
+	if _, err := CreatePatch(context.Background(), config, oldManifest, bytes.NewReader(edited), patch); err != nil {
+		t.Fatal(err)
+	}
+	data := patch.Bytes()
+
+	otherOld := append([]byte(nil), old...)
+	otherOld[0] ^= 0xff
+
+	tests := map[string]struct {
+		Old   []byte
+		Patch []byte
+		Want  error
+	}{
+		"other old file":  {otherOld, data, ErrPatchMismatch},
+		"truncated old":   {old[:1000], data, ErrPatchMismatch},
+		"truncated patch": {old, data[:len(data)-40], ErrInvalidPatch},
+		"missing magic":   {old, []byte("nope"), ErrInvalidPatch},
+		"unknown op":      {old, append(append([]byte(nil), data[:5]...), 42), ErrInvalidPatch},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ApplyPatch(new(bytes.Buffer), bytes.NewReader(tc.Old), bytes.NewReader(tc.Patch))
+			if !errors.Is(err, tc.Want) {
+				t.Errorf("want = %s, got = %s", tc.Want, err)
+			}
+		})
+	}
+
+	other, err := NewConfig(With32kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePatch(context.Background(), other, oldManifest, bytes.NewReader(edited), new(bytes.Buffer)); !errors.Is(err, ErrIncompatibleManifest) {
+		t.Errorf("want = %s, got = %s", ErrIncompatibleManifest, err)
+	}
+}
@@ -0,0 +1,125 @@
+package fastcdc
+
+import (
+	"context"
+	"io"
+)
+
+// readAheadBuffer is a buffer filled by the read-ahead goroutine.
+type readAheadBuffer struct {
+	data []byte
+	err  error
+}
+
+// readAhead is a reader filling a second buffer in a background goroutine while the
+// current one is being scanned, which overlaps the I/O with the hashing. Unlike most
+// reader, Read always fill p entirely unless the underlying reader return an error.
+// This guarantees that the chunker output doesn't depend on the underlying reads.
+type readAhead struct {
+	ctx      context.Context
+	ready    chan readAheadBuffer
+	free     chan []byte
+	done     chan struct{}
+	finished chan struct{}
+	current  readAheadBuffer
+	buf      []byte
+	err      error
+}
+
+// newReadAhead start reading ahead src using the given buffers.
+func newReadAhead(ctx context.Context, src io.Reader, buffers [][]byte) *readAhead {
+	r := &readAhead{
+		ctx:      ctx,
+		ready:    make(chan readAheadBuffer, len(buffers)),
+		free:     make(chan []byte, len(buffers)),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	for _, buf := range buffers {
+		r.free <- buf
+	}
+	go r.fill(src)
+	return r
+}
+
+// fill read src into the free buffers until an error occurs or
+// until the read-ahead is closed.
+func (r *readAhead) fill(src io.Reader) {
+	defer close(r.finished)
+	for {
+		var buf []byte
+		select {
+		case buf = <-r.free:
+		case <-r.done:
+			return
+		}
+
+		n, err := io.ReadFull(src, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		select {
+		case r.ready <- readAheadBuffer{data: buf[:n], err: err}:
+		case <-r.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read fill p with the buffers read ahead. The error of the underlying
+// reader is only returned once all the data read before are consumed.
+func (r *readAhead) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.current.data) == 0 {
+			if r.err != nil {
+				break
+			}
+			// Give back the drained buffer so the next part can be read ahead
+			if r.buf != nil {
+				r.free <- r.buf
+				r.buf = nil
+			}
+			select {
+			case r.current = <-r.ready:
+			case <-r.ctx.Done():
+				return n, r.ctx.Err()
+			}
+			r.buf = r.current.data[:cap(r.current.data)]
+			r.err = r.current.err
+		}
+		c := copy(p[n:], r.current.data)
+		r.current.data = r.current.data[c:]
+		n += c
+	}
+
+	if n == 0 && r.err != nil {
+		return 0, r.err
+	}
+	return n, nil
+}
+
+// close stop the read-ahead goroutine and wait until it returns, so the underlying reader
+// and the buffers are not used anymore once close returns. If the context is canceled, close
+// doesn't wait since the goroutine may be blocked on a read that never returns, and report
+// false as the buffers may still be in use.
+func (r *readAhead) close() bool {
+	close(r.done)
+	select {
+	case <-r.finished:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+// abort stop the read-ahead goroutine without waiting for it. The buffers
+// may still be in use by a pending read and must not be reused.
+func (r *readAhead) abort() {
+	close(r.done)
+}
@@ -0,0 +1,198 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
+func TestReadAheadRandomInput(t *testing.T) {
+	tests := []struct {
+		Name    string
+		MaxSize int
+		Opt     Option
+	}{
+		{"16kChunks", 32768, With16kChunks()},
+		{"32kChunks", 65_536, With32kChunks()},
+		{"64kChunks", 131_072, With64kChunks()},
+	}
+
+	seed := time.Now().UnixNano()
+	rand.Seed(seed)
+	t.Logf("seed, %d", seed)
+
+	type Chunk struct {
+		Offset uint
+		Length uint
+		Sum    [32]byte
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t *testing.T) {
+			max := 1 * 1024 * 1024 // max buffer size
+			min := tc.MaxSize      // min buffer size for the chunk size range, it's set to the max chunks size
+
+			// repeat test
+			for i := 0; i < 50; i++ {
+				rd := rand.Intn(8*1024*1024-1000+1) + 1000
+				data := make([]byte, rd)
+				rand.Read(data)
+				bufSize := uint(rand.Intn(max-min+1) + min)
+
+				split := func(data io.Reader, opts ...Option) []Chunk {
+					chunks := make([]Chunk, 0)
+					chunker, err := NewChunker(context.Background(), append(opts, tc.Opt, WithBufferSize(bufSize))...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					fn := func(offset, length uint, chunk []byte) error {
+						chunks = append(chunks, Chunk{offset, length, sha256.Sum256(chunk)})
+						return nil
+					}
+					if err := chunker.Split(data, fn); err != nil {
+						t.Fatal(err)
+					}
+					if err := chunker.Finalize(fn); err != nil {
+						t.Fatal(err)
+					}
+					return chunks
+				}
+
+				want := split(bytes.NewReader(data))
+				// The read-ahead output must not depend on the underlying reads.
+				got := split(iotest.HalfReader(bytes.NewReader(data)), WithReadAhead())
+				if !reflect.DeepEqual(want, got) {
+					t.Errorf("chunks mismatch: buffer length = %d, file size = %d", bufSize, rd)
+				}
+			}
+		})
+	}
+}
+
+func TestReadAheadStream(t *testing.T) {
+	data := randomData(155, 4*1024*1024)
+
+	split := func(opts ...Option) [][32]byte {
+		sums := make([][32]byte, 0)
+		chunker, err := NewChunker(context.Background(), append(opts, WithStreamMode(), With16kChunks())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn := func(offset, length uint, chunk []byte) error {
+			sums = append(sums, sha256.Sum256(chunk))
+			return nil
+		}
+		for i := 0; i < len(data); i += 100_000 {
+			end := i + 100_000
+			if end > len(data) {
+				end = len(data)
+			}
+			if err := chunker.Split(bytes.NewReader(data[i:end]), fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := chunker.Finalize(fn); err != nil {
+			t.Fatal(err)
+		}
+		return sums
+	}
+
+	want := split()
+	got := split(WithReadAhead())
+	if !reflect.DeepEqual(want, got) {
+		t.Error("chunks mismatch")
+	}
+}
+
+func TestReadAheadError(t *testing.T) {
+	errRead := errors.New("read error")
+	data := randomData(155, 1024*1024)
+
+	chunker, err := NewChunker(context.Background(), WithReadAhead(), With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var length uint
+	err = chunker.Split(io.MultiReader(bytes.NewReader(data), errReader{errRead}), func(offset, l uint, chunk []byte) error {
+		length += l
+		return nil
+	})
+	if !errors.Is(err, errRead) {
+		t.Errorf("want = %s, got = %s", errRead, err)
+	}
+	if length == 0 {
+		t.Error("the data read before the error must be chunked")
+	}
+}
+
+func TestReadAheadCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := blockingReader{unblock: make(chan struct{})}
+	defer close(reader.unblock)
+
+	chunker, err := NewChunker(ctx, WithReadAhead())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = chunker.Split(reader, func(offset, length uint, chunk []byte) error {
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want = %s, got = %s", context.Canceled, err)
+	}
+}
+
+func TestReadAheadCallbackError(t *testing.T) {
+	errChunk := errors.New("chunk error")
+	reader := blockingReader{unblock: make(chan struct{})}
+	defer close(reader.unblock)
+
+	chunker, err := NewChunker(context.Background(), WithReadAhead(), With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := randomData(156, 65_636)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- chunker.Split(io.MultiReader(bytes.NewReader(data), reader), func(offset, length uint, chunk []byte) error {
+			return errChunk
+		})
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errChunk) {
+			t.Errorf("want = %s, got = %s", errChunk, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("split must return the callback error without waiting for the reader")
+	}
+}
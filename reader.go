@@ -0,0 +1,177 @@
+package fastcdc
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var errNegativeOffset = errors.New("negative offset")
+
+// ManifestReader rebuild a file from its manifest and a chunk store. It implements
+// io.Reader, io.Seeker and io.ReaderAt and only fetch the chunks overlapping the requested
+// range. The most recently used chunks are kept in a small LRU cache. The chunks of the
+// manifest must be sorted by offset. ManifestReader is safe for concurrent use with ReadAt.
+type ManifestReader struct {
+	manifest *Manifest
+	store    ChunkStore
+	offset   int64
+	mu       sync.Mutex
+	cache    *chunkCache
+}
+
+// NewManifestReader return a reader of the file described by the manifest. Up to
+// cacheSize chunks are kept in memory, a cacheSize of 0 disable the cache.
+func NewManifestReader(manifest *Manifest, store ChunkStore, cacheSize int) *ManifestReader {
+	return &ManifestReader{
+		manifest: manifest,
+		store:    store,
+		cache:    newChunkCache(cacheSize),
+	}
+}
+
+// Size return the size of the file.
+func (r *ManifestReader) Size() int64 {
+	return int64(r.manifest.Size)
+}
+
+// Read implement io.Reader.
+func (r *ManifestReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	offset := r.offset
+	r.mu.Unlock()
+
+	n, err := r.ReadAt(p, offset)
+	r.mu.Lock()
+	r.offset = offset + int64(n)
+	r.mu.Unlock()
+	// Unlike ReadAt, Read must not return EOF along with data
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implement io.Seeker.
+func (r *ManifestReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// ReadAt implement io.ReaderAt.
+func (r *ManifestReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	chunks := r.manifest.Chunks
+	// Find the first chunk ending after the offset
+	i := sort.Search(len(chunks), func(i int) bool {
+		return int64(chunks[i].Offset+chunks[i].Length) > off
+	})
+
+	n := 0
+	for n < len(p) {
+		if off >= r.Size() || i >= len(chunks) {
+			return n, io.EOF
+		}
+		chunk := chunks[i]
+		if int64(chunk.Offset) > off {
+			return n, fmt.Errorf("no chunk at offset %d: %w", off, ErrInvalidManifest)
+		}
+
+		data, err := r.chunk(chunk)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-int64(chunk.Offset):])
+		n += c
+		off += int64(c)
+		i++
+	}
+	return n, nil
+}
+
+// chunk return the data of the chunk, from the cache if possible.
+func (r *ManifestReader) chunk(chunk ManifestChunk) ([]byte, error) {
+	r.mu.Lock()
+	data, ok := r.cache.get(chunk.Digest)
+	r.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := r.store.Get(chunk.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if uint(len(data)) != chunk.Length || Sum(data) != chunk.Digest {
+		return nil, fmt.Errorf("chunk %s at offset %d: %w", chunk.Digest, chunk.Offset, ErrCorruptedChunk)
+	}
+
+	r.mu.Lock()
+	r.cache.add(chunk.Digest, data)
+	r.mu.Unlock()
+	return data, nil
+}
+
+// chunkCache is a LRU cache of chunks. It is not safe for concurrent use.
+type chunkCache struct {
+	size  int
+	ll    *list.List
+	items map[Digest]*list.Element
+}
+
+type chunkCacheEntry struct {
+	digest Digest
+	data   []byte
+}
+
+func newChunkCache(size int) *chunkCache {
+	return &chunkCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[Digest]*list.Element),
+	}
+}
+
+func (c *chunkCache) get(digest Digest) ([]byte, bool) {
+	if e, ok := c.items[digest]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*chunkCacheEntry).data, true
+	}
+	return nil, false
+}
+
+func (c *chunkCache) add(digest Digest, data []byte) {
+	if c.size <= 0 {
+		return
+	}
+	if e, ok := c.items[digest]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[digest] = c.ll.PushFront(&chunkCacheEntry{digest: digest, data: data})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*chunkCacheEntry).digest)
+	}
+}
@@ -0,0 +1,142 @@
+package fastcdc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingStore count the chunks fetched from the underlying store.
+type countingStore struct {
+	ChunkStore
+	mu   sync.Mutex
+	gets int
+}
+
+func (s *countingStore) Get(digest Digest) ([]byte, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.ChunkStore.Get(digest)
+}
+
+func TestManifestReader(t *testing.T) {
+	data, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{ChunkStore: NewMemoryStore()}
+	manifest := sekienManifest(t, config, store)
+
+	t.Run("read all", func(t *testing.T) {
+		r := NewManifestReader(manifest, store, 0)
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, got) {
+			t.Error("data mismatch")
+		}
+	})
+
+	t.Run("read at", func(t *testing.T) {
+		seed := time.Now().UnixNano()
+		rand.Seed(seed)
+		t.Logf("seed, %d", seed)
+
+		r := NewManifestReader(manifest, store, 2)
+		for i := 0; i < 1000; i++ {
+			off := rand.Intn(len(data))
+			buf := make([]byte, rand.Intn(64*1024))
+			n, err := r.ReadAt(buf, int64(off))
+			if off+len(buf) > len(data) {
+				if err != io.EOF {
+					t.Fatalf("want = %s, got = %s", io.EOF, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data[off:off+n], buf[:n]) {
+				t.Fatalf("data mismatch at offset %d", off)
+			}
+		}
+	})
+
+	t.Run("fetch only overlapping chunks", func(t *testing.T) {
+		r := NewManifestReader(manifest, store, 4)
+		store.gets = 0
+		// The range overlap the chunks at offset 22366 and 30648
+		buf := make([]byte, 1000)
+		if _, err := r.ReadAt(buf, 30000); err != nil {
+			t.Fatal(err)
+		}
+		if store.gets != 2 {
+			t.Errorf("gets: want = 2, got = %d", store.gets)
+		}
+		// The chunks are now cached
+		if _, err := r.ReadAt(buf, 30100); err != nil {
+			t.Fatal(err)
+		}
+		if store.gets != 2 {
+			t.Errorf("gets: want = 2, got = %d", store.gets)
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		r := NewManifestReader(manifest, store, 1)
+		if _, err := r.Seek(-100, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data[len(data)-100:], got) {
+			t.Error("data mismatch")
+		}
+		if _, err := r.Seek(-int64(len(data))-1, io.SeekCurrent); err == nil {
+			t.Error("want error on negative offset")
+		}
+	})
+
+	t.Run("corrupted chunk", func(t *testing.T) {
+		corrupted := NewMemoryStore()
+		for _, chunk := range manifest.Chunks {
+			corrupted.Put(chunk.Digest, make([]byte, chunk.Length))
+		}
+		r := NewManifestReader(manifest, corrupted, 1)
+		if _, err := r.ReadAt(make([]byte, 10), 0); !errors.Is(err, ErrCorruptedChunk) {
+			t.Errorf("want = %s, got = %s", ErrCorruptedChunk, err)
+		}
+	})
+}
+
+func TestChunkCache(t *testing.T) {
+	cache := newChunkCache(2)
+	a, b, c := Sum([]byte("a")), Sum([]byte("b")), Sum([]byte("c"))
+	cache.add(a, []byte("a"))
+	cache.add(b, []byte("b"))
+	// a is now the most recently used
+	if _, ok := cache.get(a); !ok {
+		t.Error("a must be cached")
+	}
+	cache.add(c, []byte("c"))
+	if _, ok := cache.get(b); ok {
+		t.Error("b must be evicted")
+	}
+	if _, ok := cache.get(a); !ok {
+		t.Error("a must be cached")
+	}
+	if _, ok := cache.get(c); !ok {
+		t.Error("c must be cached")
+	}
+}
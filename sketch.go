@@ -0,0 +1,156 @@
+package fastcdc
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// SketchFeatures is the number of features sampled from a chunk.
+	SketchFeatures = 12
+	// SketchSuperFeatures is the number of super-features of a sketch, each
+	// one grouping SketchFeatures / SketchSuperFeatures features.
+	SketchSuperFeatures = 3
+)
+
+// Sketch is the resemblance sketch of a chunk, made of super-features. Chunks sharing a
+// super-feature are very likely to be similar, the more super-features they share, the
+// more similar they are.
+type Sketch [SketchSuperFeatures]uint64
+
+// sketchSampleMask select the positions where the features are sampled, about 1 in 8.
+// The positions are content defined, so they are the same in similar chunks.
+const sketchSampleMask = 0x7
+
+// featureTransforms are the linear transforms (m*x + a) selecting the features.
+var featureTransforms [SketchFeatures][2]uint64
+
+func init() {
+	// Fixed splitmix64 sequence, sketches must be stable across runs
+	state := uint64(0x66617374636463)
+	next := func() uint64 {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	for i := range featureTransforms {
+		featureTransforms[i] = [2]uint64{next() | 1, next()}
+	}
+}
+
+// NewSketch return the resemblance sketch of the chunk. The gear hash used by the chunker is
+// rolled over the chunk, and each feature is the maximum of a distinct transform of the
+// sampled hash values. Since the gear hash only depend on the last bytes, a small edit only change the
+// hash values around it, and most features are preserved.
+func NewSketch(chunk []byte) Sketch {
+	var features [SketchFeatures]uint64
+	var hash uint
+	for _, b := range chunk {
+		hash = (hash >> 1) + table[b]
+		if hash&sketchSampleMask != 0 {
+			continue
+		}
+		x := uint64(hash)
+		for i, t := range featureTransforms {
+			if v := t[0]*x + t[1]; v > features[i] {
+				features[i] = v
+			}
+		}
+	}
+
+	var sketch Sketch
+	var buf [8]byte
+	group := SketchFeatures / SketchSuperFeatures
+	for i := range sketch {
+		h := fnv.New64a()
+		for _, feature := range features[i*group : (i+1)*group] {
+			binary.LittleEndian.PutUint64(buf[:], feature)
+			h.Write(buf[:])
+		}
+		sketch[i] = h.Sum64()
+	}
+	return sketch
+}
+
+// Resemblance return the number of super-features shared by the sketches.
+func (s Sketch) Resemblance(other Sketch) int {
+	n := 0
+	for i := range s {
+		if s[i] == other[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// SimilarityIndex find a similar base chunk for a new chunk from their sketches. For each
+// super-feature value, the index keep the first chunk added with this value, so the base
+// of similar chunks stay the same. SimilarityIndex is safe for concurrent use.
+type SimilarityIndex struct {
+	mu      sync.RWMutex
+	buckets [SketchSuperFeatures]map[uint64]Digest
+}
+
+// NewSimilarityIndex return an empty index.
+func NewSimilarityIndex() *SimilarityIndex {
+	idx := &SimilarityIndex{}
+	for i := range idx.buckets {
+		idx.buckets[i] = make(map[uint64]Digest)
+	}
+	return idx
+}
+
+// Add add the chunk to the index.
+func (idx *SimilarityIndex) Add(digest Digest, sketch Sketch) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i, feature := range sketch {
+		if _, ok := idx.buckets[i][feature]; !ok {
+			idx.buckets[i][feature] = digest
+		}
+	}
+}
+
+// Remove remove the chunk from the index, typically once deleted from the store.
+func (idx *SimilarityIndex) Remove(digest Digest, sketch Sketch) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i, feature := range sketch {
+		if base, ok := idx.buckets[i][feature]; ok && base == digest {
+			delete(idx.buckets[i], feature)
+		}
+	}
+}
+
+// Find return the indexed chunk sharing the most super-features with the sketch, along with
+// the number of shared super-features. It return 0 if no indexed chunk is similar.
+func (idx *SimilarityIndex) Find(sketch Sketch) (Digest, int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var best Digest
+	bestScore := 0
+	var candidates [SketchSuperFeatures]Digest
+	found := 0
+	for i, feature := range sketch {
+		base, ok := idx.buckets[i][feature]
+		if !ok {
+			continue
+		}
+		candidates[found] = base
+		found++
+		score := 0
+		for _, candidate := range candidates[:found] {
+			if candidate == base {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = base, score
+		}
+	}
+	return best, bestScore
+}
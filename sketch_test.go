@@ -0,0 +1,67 @@
+package fastcdc
+
+import (
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	similar, total := 0, 0
+	for seed := 0; seed < 50; seed++ {
+		chunk := randomData(seed, 16*1024)
+		sketch := NewSketch(chunk)
+		if sketch != NewSketch(chunk) {
+			t.Fatal("sketch is not deterministic")
+		}
+
+		// A few bytes edited
+		edited := append([]byte(nil), chunk...)
+		edited[1000] ^= 0xff
+		edited[9000] ^= 0xff
+		total++
+		if sketch.Resemblance(NewSketch(edited)) > 0 {
+			similar++
+		}
+
+		if n := sketch.Resemblance(NewSketch(randomData(seed+1000, 16*1024))); n != 0 {
+			t.Errorf("unrelated chunks share %d super-features", n)
+		}
+	}
+	if similar < total*9/10 {
+		t.Errorf("similar: want >= %d, got = %d", total*9/10, similar)
+	}
+}
+
+func TestSimilarityIndex(t *testing.T) {
+	idx := NewSimilarityIndex()
+	chunks := make([][]byte, 100)
+	for i := range chunks {
+		chunks[i] = randomData(i, 8*1024)
+		idx.Add(Sum(chunks[i]), NewSketch(chunks[i]))
+	}
+
+	edited := append([]byte(nil), chunks[42]...)
+	copy(edited[4000:], "a small edit")
+	base, score := idx.Find(NewSketch(edited))
+	if score == 0 {
+		t.Fatal("no similar chunk found")
+	}
+	if base != Sum(chunks[42]) {
+		t.Errorf("want = %s, got = %s", Sum(chunks[42]), base)
+	}
+
+	// The first chunk added stay the base of its super-features
+	idx.Add(Sum(edited), NewSketch(edited))
+	if base, _ := idx.Find(NewSketch(chunks[42])); base != Sum(chunks[42]) {
+		t.Errorf("want = %s, got = %s", Sum(chunks[42]), base)
+	}
+
+	if _, score := idx.Find(NewSketch(randomData(1000, 8*1024))); score != 0 {
+		t.Errorf("score: want = 0, got = %d", score)
+	}
+
+	idx.Remove(Sum(chunks[42]), NewSketch(chunks[42]))
+	idx.Remove(Sum(edited), NewSketch(edited))
+	if _, score := idx.Find(NewSketch(edited)); score != 0 {
+		t.Errorf("score: want = 0, got = %d", score)
+	}
+}
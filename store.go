@@ -0,0 +1,121 @@
+package fastcdc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrChunkNotFound  = errors.New("chunk not found")
+	ErrInvalidDigest  = errors.New("invalid digest")
+	ErrCorruptedChunk = errors.New("corrupted chunk")
+)
+
+// Digest is the SHA-256 digest of a chunk. It is used as key to
+// address the chunks in a ChunkStore.
+type Digest [sha256.Size]byte
+
+// Sum return the digest of the chunk.
+func Sum(chunk []byte) Digest {
+	return sha256.Sum256(chunk)
+}
+
+// ParseDigest parse the hexadecimal representation of a digest.
+func ParseDigest(s string) (Digest, error) {
+	var d Digest
+	if hex.DecodedLen(len(s)) != len(d) {
+		return d, fmt.Errorf("digest %q must be %d bytes long: %w", s, len(d), ErrInvalidDigest)
+	}
+	if _, err := hex.Decode(d[:], []byte(s)); err != nil {
+		return d, fmt.Errorf("digest %q is not hexadecimal: %w", s, ErrInvalidDigest)
+	}
+	return d, nil
+}
+
+// String return the hexadecimal representation of the digest.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// ChunkStore is a content-addressed chunk storage. Chunks are keyed by their digest.
+// Implementations must be safe for concurrent use. Since chunks produced by the chunker
+// are only valid within the callback, Put must not retain the chunk.
+type ChunkStore interface {
+	// Put store the chunk with the given digest. Putting
+	// an already stored chunk is a no-op.
+	Put(digest Digest, chunk []byte) error
+	// Get return the chunk with the given digest or
+	// ErrChunkNotFound if the chunk is not stored.
+	Get(digest Digest) ([]byte, error)
+	// Has report whether the chunk with the given digest is stored.
+	Has(digest Digest) (bool, error)
+	// Delete remove the chunk with the given digest or
+	// return ErrChunkNotFound if the chunk is not stored.
+	Delete(digest Digest) error
+}
+
+// ChunkWalker is implemented by the chunk stores able to enumerate their chunks.
+type ChunkWalker interface {
+	// Walk call fn with the digest of every stored chunk, in increasing digest
+	// order. The chunks stored during the walk may not be visited. If fn return
+	// an error, the walk stop and Walk return the error.
+	Walk(fn func(digest Digest) error) error
+}
+
+// ChunkReplacer is implemented by the chunk stores able to replace a stored chunk.
+type ChunkReplacer interface {
+	// Replace atomically store the chunk with the given digest, replacing the stored
+	// chunk if any. Readers get either the previous or the new chunk.
+	Replace(digest Digest, chunk []byte) error
+}
+
+// walkDigests sort the digests and call fn with each of them.
+func walkDigests(digests []Digest, fn func(digest Digest) error) error {
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i][:], digests[j][:]) < 0
+	})
+	for _, digest := range digests {
+		if err := fn(digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoredChunkFn is called by the ChunkFn returned by StoreChunkFn
+// once a chunk is stored.
+type StoredChunkFn func(offset, length uint, digest Digest) error
+
+// StoreChunkFn return a ChunkFn storing every chunk found by the split function into
+// the store. If fn is not nil, it's called with the digest of each stored chunk.
+func StoreChunkFn(store ChunkStore, fn StoredChunkFn) ChunkFn {
+	return func(offset, length uint, chunk []byte) error {
+		digest := Sum(chunk)
+		if err := store.Put(digest, chunk); err != nil {
+			return err
+		}
+		if fn != nil {
+			return fn(offset, length, digest)
+		}
+		return nil
+	}
+}
+
+// MarshalText encode the digest in hexadecimal.
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decode an hexadecimal digest.
+func (d *Digest) UnmarshalText(text []byte) error {
+	digest, err := ParseDigest(string(text))
+	if err != nil {
+		return err
+	}
+	*d = digest
+	return nil
+}
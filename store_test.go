@@ -0,0 +1,151 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDigest(t *testing.T) {
+	want := Sum([]byte("fastcdc"))
+
+	got, err := ParseDigest(want.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("want = %s, got = %s", want, got)
+	}
+
+	for _, s := range []string{"", "abc", strings.Repeat("z", 64), want.String() + "00"} {
+		if _, err := ParseDigest(s); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("%q: want = %s, got = %s", s, ErrInvalidDigest, err)
+		}
+	}
+}
+
+func TestChunkStore(t *testing.T) {
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]ChunkStore{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+	}
+
+	data, err := ioutil.ReadFile("fixtures/SekienAkashita.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			chunker, err := NewChunker(context.Background(), With16kChunks())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digests := make([]Digest, 0)
+			fn := StoreChunkFn(store, func(offset, length uint, digest Digest) error {
+				digests = append(digests, digest)
+				return nil
+			})
+			if err := chunker.Split(bytes.NewReader(data), fn); err != nil {
+				t.Fatal(err)
+			}
+			if err := chunker.Finalize(fn); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(digests) != 6 {
+				t.Fatalf("digests length: want = 6, got = %d", len(digests))
+			}
+
+			output := make([]byte, 0, len(data))
+			for _, digest := range digests {
+				ok, err := store.Has(digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Errorf("chunk %s not stored", digest)
+				}
+				chunk, err := store.Get(digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if Sum(chunk) != digest {
+					t.Errorf("chunk %s: digest mismatch", digest)
+				}
+				output = append(output, chunk...)
+			}
+			if !reflect.DeepEqual(data, output) {
+				t.Error("data mismatch")
+			}
+
+			// Putting an already stored chunk is a no-op
+			chunk, _ := store.Get(digests[0])
+			if err := store.Put(digests[0], chunk); err != nil {
+				t.Fatal(err)
+			}
+
+			// Replacing a stored chunk overwrite it
+			replacer := store.(ChunkReplacer)
+			if err := replacer.Replace(digests[0], []byte("replaced")); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := store.Get(digests[0]); string(got) != "replaced" {
+				t.Errorf("want = replaced, got = %q", got)
+			}
+
+			if err := store.Delete(digests[0]); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := store.Has(digests[0]); ok {
+				t.Errorf("chunk %s not deleted", digests[0])
+			}
+			if _, err := store.Get(digests[0]); !errors.Is(err, ErrChunkNotFound) {
+				t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+			}
+			if err := store.Delete(digests[0]); !errors.Is(err, ErrChunkNotFound) {
+				t.Errorf("want = %s, got = %s", ErrChunkNotFound, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreLayout(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewFileStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := []byte("fastcdc")
+	digest := Sum(chunk)
+	if err := store.Put(digest, chunk); err != nil {
+		t.Fatal(err)
+	}
+
+	name := digest.String()
+	if _, err := os.Stat(filepath.Join(root, name[:2], name)); err != nil {
+		t.Fatal(err)
+	}
+
+	// No temporary file must remain after the write
+	files, err := ioutil.ReadDir(filepath.Join(root, name[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("files: want = 1, got = %d", len(files))
+	}
+}
@@ -0,0 +1,454 @@
+package fastcdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SyncVersion is the latest version of the sync protocol.
+const SyncVersion uint = 1
+
+const minSyncVersion uint = 1
+
+var (
+	ErrSyncProtocol           = errors.New("sync protocol error")
+	ErrUnsupportedSyncVersion = errors.New("unsupported sync protocol version")
+	ErrSyncRemote             = errors.New("remote sync error")
+)
+
+// syncMagic start the hello frame of both peers.
+var syncMagic = []byte("FCSY")
+
+// Frame types. Frames are laid out as: type (1 byte) | payload length (uvarint) | payload
+const (
+	frameHello    byte = 1 // magic | min version | max version, or the negotiated version in reply
+	frameManifest byte = 2 // name length | name | binary manifest
+	frameWant     byte = 3 // digests of missing chunks, an empty frame end the list
+	frameChunk    byte = 4 // digest | chunk
+	frameDone     byte = 5 // all chunks sent, or manifest stored in reply
+	frameError    byte = 6 // error message
+)
+
+const (
+	maxControlFrameSize  = 64 * 1024
+	maxManifestFrameSize = 256 * 1024 * 1024
+	wantBatchSize        = 1024
+)
+
+// SyncStats summarize a synchronization.
+type SyncStats struct {
+	Chunks    uint // Number of chunks of the file
+	Sent      uint // Number of chunks transferred
+	SentBytes uint // Number of bytes of the transferred chunks
+}
+
+// SyncSender send a file to a SyncReceiver. The file is split once when the sender is
+// created, then Send can be called again with a new connection after a disconnection.
+// Since the receiver only ask for the chunks it lacks, the chunks received before the
+// disconnection are not sent again.
+type SyncSender struct {
+	name     string
+	file     io.ReaderAt
+	manifest *Manifest
+	chunks   map[Digest]ManifestChunk
+}
+
+// NewSyncSender split the file with a chunker session of config and return a sender
+// storing it under name on the receiver side.
+func NewSyncSender(ctx context.Context, config *Config, name string, file io.ReaderAt, size int64) (*SyncSender, error) {
+	manifest, err := BuildManifest(ctx, config, io.NewSectionReader(file, 0, size), nil)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[Digest]ManifestChunk, len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		chunks[chunk.Digest] = chunk
+	}
+	return &SyncSender{
+		name:     name,
+		file:     file,
+		manifest: manifest,
+		chunks:   chunks,
+	}, nil
+}
+
+// Manifest return the manifest of the file.
+func (s *SyncSender) Manifest() *Manifest {
+	return s.manifest
+}
+
+// Send the file over rw: the manifest is sent first, then only the chunks missing on the
+// receiver side. Send return once the receiver stored the manifest.
+func (s *SyncSender) Send(ctx context.Context, rw io.ReadWriter) (stats SyncStats, err error) {
+	stop := watchContext(ctx, rw)
+	conn := newSyncConn(rw)
+	// Errors are only reported while the receiver is waiting for chunks,
+	// reporting an error while the receiver is writing could deadlock.
+	report := false
+	defer func() {
+		stop()
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = ctxErr
+		}
+		if err != nil && report && ctx.Err() == nil {
+			conn.send(frameError, []byte(err.Error()))
+		}
+	}()
+
+	stats.Chunks = uint(len(s.manifest.Chunks))
+
+	// Version negotiation
+	hello := append([]byte(nil), syncMagic...)
+	hello = appendUvarint(hello, minSyncVersion)
+	hello = appendUvarint(hello, SyncVersion)
+	if err := conn.send(frameHello, hello); err != nil {
+		return stats, err
+	}
+	payload, err := conn.expect(frameHello, maxControlFrameSize)
+	if err != nil {
+		return stats, err
+	}
+	version, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return stats, fmt.Errorf("invalid hello: %w", ErrSyncProtocol)
+	}
+	if uint(version) < minSyncVersion || uint(version) > SyncVersion {
+		return stats, fmt.Errorf("version %d: %w", version, ErrUnsupportedSyncVersion)
+	}
+
+	// Manifest
+	data, err := s.manifest.MarshalBinary()
+	if err != nil {
+		return stats, err
+	}
+	header := appendUvarint(nil, uint(len(s.name)))
+	header = append(header, s.name...)
+	if err := conn.send(frameManifest, header, data); err != nil {
+		return stats, err
+	}
+
+	// Missing chunks
+	want := make([]Digest, 0)
+	for {
+		payload, err := conn.expect(frameWant, uint(wantBatchSize*len(Digest{})))
+		if err != nil {
+			return stats, err
+		}
+		if len(payload) == 0 {
+			break
+		}
+		if len(payload)%len(Digest{}) != 0 {
+			return stats, fmt.Errorf("invalid want list: %w", ErrSyncProtocol)
+		}
+		for i := 0; i < len(payload); i += len(Digest{}) {
+			var digest Digest
+			copy(digest[:], payload[i:])
+			want = append(want, digest)
+		}
+	}
+
+	report = true
+	for _, digest := range want {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		chunk, ok := s.chunks[digest]
+		if !ok {
+			return stats, fmt.Errorf("unknown chunk %s requested: %w", digest, ErrSyncProtocol)
+		}
+		data := make([]byte, chunk.Length)
+		if _, err := s.file.ReadAt(data, int64(chunk.Offset)); err != nil && !(err == io.EOF && uint(len(data)) == chunk.Length) {
+			return stats, err
+		}
+		if Sum(data) != digest {
+			return stats, fmt.Errorf("chunk %s: file modified since split: %w", digest, ErrCorruptedChunk)
+		}
+		if err := conn.write(frameChunk, digest[:], data); err != nil {
+			return stats, err
+		}
+		stats.Sent++
+		stats.SentBytes += chunk.Length
+	}
+
+	if err := conn.write(frameDone); err != nil {
+		return stats, err
+	}
+	report = false
+	if err := conn.w.Flush(); err != nil {
+		return stats, err
+	}
+	_, err = conn.expect(frameDone, 0)
+	return stats, err
+}
+
+// SyncReceiver receive files from SyncSenders, storing their chunks and manifests.
+// A receiver can serve many connections concurrently if its stores are safe for
+// concurrent use.
+type SyncReceiver struct {
+	maxSize   uint
+	store     ChunkStore
+	manifests ManifestStore
+}
+
+// NewSyncReceiver return a receiver storing the files into the stores. Files with
+// chunks larger than the max size of config are rejected.
+func NewSyncReceiver(config *Config, store ChunkStore, manifests ManifestStore) *SyncReceiver {
+	return &SyncReceiver{
+		maxSize:   config.MaxSize(),
+		store:     store,
+		manifests: manifests,
+	}
+}
+
+// Receive a file over rw and return its name. Chunks are stored as soon as they are received,
+// so an interrupted transfer is resumed by the next Receive. The manifest is only stored
+// once all its chunks are stored. On error, the sender may still be writing, rw should
+// be closed.
+func (r *SyncReceiver) Receive(ctx context.Context, rw io.ReadWriter) (name string, stats SyncStats, err error) {
+	stop := watchContext(ctx, rw)
+	conn := newSyncConn(rw)
+	// Errors are only reported to the sender when it's waiting for a reply,
+	// reporting an error while the sender is writing could deadlock.
+	report := false
+	defer func() {
+		stop()
+		if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+			err = ctxErr
+		}
+		if err != nil && report && ctx.Err() == nil {
+			conn.send(frameError, []byte(err.Error()))
+		}
+	}()
+
+	// Version negotiation
+	payload, err := conn.expect(frameHello, maxControlFrameSize)
+	if err != nil {
+		return "", stats, err
+	}
+	report = true
+	if !bytes.HasPrefix(payload, syncMagic) {
+		return "", stats, fmt.Errorf("invalid hello: %w", ErrSyncProtocol)
+	}
+	br := bytes.NewReader(payload[len(syncMagic):])
+	minVersion, err1 := binary.ReadUvarint(br)
+	maxVersion, err2 := binary.ReadUvarint(br)
+	if err1 != nil || err2 != nil {
+		return "", stats, fmt.Errorf("invalid hello: %w", ErrSyncProtocol)
+	}
+	version := SyncVersion
+	if uint(maxVersion) < version {
+		version = uint(maxVersion)
+	}
+	if version < uint(minVersion) || version < minSyncVersion {
+		return "", stats, fmt.Errorf("versions %d to %d: %w", minVersion, maxVersion, ErrUnsupportedSyncVersion)
+	}
+	if err := conn.send(frameHello, appendUvarint(nil, version)); err != nil {
+		return "", stats, err
+	}
+
+	// Manifest
+	report = false
+	payload, err = conn.expect(frameManifest, maxManifestFrameSize)
+	if err != nil {
+		return "", stats, err
+	}
+	report = true
+	n, read := binary.Uvarint(payload)
+	if read <= 0 || uint64(len(payload)-read) < n {
+		return "", stats, fmt.Errorf("invalid manifest frame: %w", ErrSyncProtocol)
+	}
+	name = string(payload[read : read+int(n)])
+	manifest := new(Manifest)
+	if err := manifest.UnmarshalBinary(payload[read+int(n):]); err != nil {
+		return name, stats, err
+	}
+	stats.Chunks = uint(len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		if chunk.Length > r.maxSize {
+			return name, stats, fmt.Errorf("chunk %s of %d bytes exceed %d bytes: %w", chunk.Digest, chunk.Length, r.maxSize, ErrSyncProtocol)
+		}
+	}
+
+	// Missing chunks, along with their expected length
+	missing := make(map[Digest]uint)
+	batch := make([]byte, 0, wantBatchSize*len(Digest{}))
+	for _, chunk := range manifest.Chunks {
+		if _, ok := missing[chunk.Digest]; ok {
+			continue
+		}
+		ok, err := r.store.Has(chunk.Digest)
+		if err != nil {
+			return name, stats, err
+		}
+		if ok {
+			continue
+		}
+		missing[chunk.Digest] = chunk.Length
+		batch = append(batch, chunk.Digest[:]...)
+		if len(batch) == cap(batch) {
+			if err := conn.write(frameWant, batch); err != nil {
+				return name, stats, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := conn.write(frameWant, batch); err != nil {
+			return name, stats, err
+		}
+	}
+	if err := conn.send(frameWant); err != nil {
+		return name, stats, err
+	}
+
+	report = false
+	for {
+		typ, payload, err := conn.read(uint(len(Digest{})) + r.maxSize)
+		if err != nil {
+			return name, stats, err
+		}
+		if typ == frameDone {
+			break
+		}
+		if typ != frameChunk || len(payload) < len(Digest{}) {
+			return name, stats, fmt.Errorf("unexpected frame %d: %w", typ, ErrSyncProtocol)
+		}
+		var digest Digest
+		copy(digest[:], payload)
+		chunk := payload[len(digest):]
+		length, ok := missing[digest]
+		if !ok {
+			return name, stats, fmt.Errorf("unexpected chunk %s: %w", digest, ErrSyncProtocol)
+		}
+		if uint(len(chunk)) != length {
+			return name, stats, fmt.Errorf("chunk %s: want %d bytes, got %d: %w", digest, length, len(chunk), ErrSyncProtocol)
+		}
+		if Sum(chunk) != digest {
+			return name, stats, fmt.Errorf("chunk %s: %w", digest, ErrCorruptedChunk)
+		}
+		if err := r.store.Put(digest, chunk); err != nil {
+			return name, stats, err
+		}
+		delete(missing, digest)
+		stats.Sent++
+		stats.SentBytes += uint(len(chunk))
+	}
+	report = true
+	if len(missing) > 0 {
+		return name, stats, fmt.Errorf("%d chunks not sent: %w", len(missing), ErrSyncProtocol)
+	}
+
+	if err := r.manifests.PutManifest(name, manifest); err != nil {
+		return name, stats, err
+	}
+	return name, stats, conn.send(frameDone)
+}
+
+type syncConn struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newSyncConn(rw io.ReadWriter) *syncConn {
+	return &syncConn{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+}
+
+// write buffer a frame made of the concatenation of the parts.
+func (c *syncConn) write(typ byte, parts ...[]byte) error {
+	var length uint
+	for _, part := range parts {
+		length += uint(len(part))
+	}
+	header := appendUvarint([]byte{typ}, length)
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if _, err := c.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send write the frame and flush the buffered frames.
+func (c *syncConn) send(typ byte, parts ...[]byte) error {
+	if err := c.write(typ, parts...); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// read return the next frame. Error frames are returned as ErrSyncRemote.
+func (c *syncConn) read(maxSize uint) (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, unexpectedFrameEOF(err)
+	}
+	if typ == frameError && maxSize < maxControlFrameSize {
+		maxSize = maxControlFrameSize
+	}
+	if length > uint64(maxSize) {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceed %d bytes: %w", length, maxSize, ErrSyncProtocol)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, unexpectedFrameEOF(err)
+	}
+	if typ == frameError {
+		return 0, nil, fmt.Errorf("%s: %w", payload, ErrSyncRemote)
+	}
+	return typ, payload, nil
+}
+
+// expect return the payload of the next frame, which must be of the given type.
+func (c *syncConn) expect(typ byte, maxSize uint) ([]byte, error) {
+	got, payload, err := c.read(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if got != typ {
+		return nil, fmt.Errorf("want frame %d, got %d: %w", typ, got, ErrSyncProtocol)
+	}
+	return payload, nil
+}
+
+func unexpectedFrameEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// watchContext interrupt the pending reads and writes on rw when the context is done, if rw
+// support deadlines like net.Conn. The returned function must be called to stop watching.
+func watchContext(ctx context.Context, rw io.ReadWriter) func() {
+	conn, ok := rw.(interface{ SetDeadline(t time.Time) error })
+	if !ok || ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,272 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type syncResult struct {
+	name  string
+	stats SyncStats
+	err   error
+}
+
+// syncPipe run a receiver and a sender on both ends of a pipe.
+func syncPipe(t *testing.T, sender *SyncSender, receiver *SyncReceiver, wrap func(net.Conn) net.Conn) (SyncStats, error, syncResult) {
+	t.Helper()
+	client, server := net.Pipe()
+	results := make(chan syncResult, 1)
+	go func() {
+		name, stats, err := receiver.Receive(context.Background(), server)
+		server.Close()
+		results <- syncResult{name, stats, err}
+	}()
+
+	conn := net.Conn(client)
+	if wrap != nil {
+		conn = wrap(client)
+	}
+	stats, err := sender.Send(context.Background(), conn)
+	client.Close()
+	return stats, err, <-results
+}
+
+// failingConn close the connection once limit bytes are written.
+type failingConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	if len(p) > c.limit {
+		n, _ := c.Conn.Write(p[:c.limit])
+		c.limit -= n
+		c.Conn.Close()
+		return n, io.ErrClosedPipe
+	}
+	n, err := c.Conn.Write(p)
+	c.limit -= n
+	return n, err
+}
+
+func TestSync(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(21, 1024*1024)
+	sender, err := NewSyncSender(context.Background(), config, "random.bin", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := NewMemoryStore()
+	manifests := NewMemoryManifestStore()
+	receiver := NewSyncReceiver(config, chunks, manifests)
+
+	// The transfer is interrupted after 300kb
+	stats, err, result := syncPipe(t, sender, receiver, func(conn net.Conn) net.Conn {
+		return &failingConn{Conn: conn, limit: 300 * 1024}
+	})
+	if err == nil || result.err == nil {
+		t.Fatalf("want errors, got = %v and %v", err, result.err)
+	}
+	if _, err := manifests.GetManifest("random.bin"); !errors.Is(err, ErrManifestNotFound) {
+		t.Errorf("want = %s, got = %s", ErrManifestNotFound, err)
+	}
+	received := uint(chunks.Len())
+	if received == 0 {
+		t.Fatal("no chunk received before the interruption")
+	}
+
+	// Only the remaining chunks are sent on resume
+	stats, err, result = syncPipe(t, sender, receiver, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.name != "random.bin" {
+		t.Errorf("name: want = random.bin, got = %s", result.name)
+	}
+	if stats.Chunks != uint(len(sender.Manifest().Chunks)) {
+		t.Errorf("chunks: want = %d, got = %d", len(sender.Manifest().Chunks), stats.Chunks)
+	}
+	if stats.Sent+received != stats.Chunks {
+		t.Errorf("sent: want = %d, got = %d", stats.Chunks-received, stats.Sent)
+	}
+	if stats != result.stats {
+		t.Errorf("receiver stats: want = %+v, got = %+v", stats, result.stats)
+	}
+
+	manifest, err := manifests.GetManifest("random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(NewManifestReader(manifest, chunks, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, output) {
+		t.Error("data mismatch")
+	}
+
+	// Nothing is sent once synced
+	stats, err, result = syncPipe(t, sender, receiver, nil)
+	if err != nil || result.err != nil {
+		t.Fatal(err, result.err)
+	}
+	if stats.Sent != 0 {
+		t.Errorf("sent: want = 0, got = %d", stats.Sent)
+	}
+}
+
+func TestSyncVersionNegotiation(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := NewSyncReceiver(config, NewMemoryStore(), NewMemoryManifestStore())
+
+	// A sender only speaking future versions
+	client, server := net.Pipe()
+	defer client.Close()
+	results := make(chan error, 1)
+	go func() {
+		_, _, err := receiver.Receive(context.Background(), server)
+		server.Close()
+		results <- err
+	}()
+	conn := newSyncConn(client)
+	hello := append(append([]byte(nil), syncMagic...), appendUvarint(appendUvarint(nil, SyncVersion+1), SyncVersion+2)...)
+	if err := conn.send(frameHello, hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.expect(frameHello, maxControlFrameSize); !errors.Is(err, ErrSyncRemote) {
+		t.Errorf("want = %s, got = %s", ErrSyncRemote, err)
+	}
+	if err := <-results; !errors.Is(err, ErrUnsupportedSyncVersion) {
+		t.Errorf("want = %s, got = %s", ErrUnsupportedSyncVersion, err)
+	}
+
+	// A receiver replying with a future version
+	data := randomData(22, 1000)
+	sender, err := NewSyncSender(context.Background(), config, "small", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server = net.Pipe()
+	defer client.Close()
+	go func() {
+		conn := newSyncConn(server)
+		conn.expect(frameHello, maxControlFrameSize)
+		conn.send(frameHello, appendUvarint(nil, SyncVersion+1))
+		server.Close()
+	}()
+	if _, err := sender.Send(context.Background(), client); !errors.Is(err, ErrUnsupportedSyncVersion) {
+		t.Errorf("want = %s, got = %s", ErrUnsupportedSyncVersion, err)
+	}
+}
+
+func TestSyncUntrustedSender(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks larger than the local max size are rejected, whatever the manifest claim
+	large, err := NewConfig(With64kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(24, 1024*1024)
+	sender, err := NewSyncSender(context.Background(), large, "large.bin", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := NewMemoryStore()
+	receiver := NewSyncReceiver(config, chunks, NewMemoryManifestStore())
+	_, err, result := syncPipe(t, sender, receiver, nil)
+	if !errors.Is(result.err, ErrSyncProtocol) {
+		t.Errorf("want = %s, got = %s", ErrSyncProtocol, result.err)
+	}
+	if !errors.Is(err, ErrSyncRemote) {
+		t.Errorf("want = %s, got = %s", ErrSyncRemote, err)
+	}
+	if chunks.Len() != 0 {
+		t.Errorf("chunks: want = 0, got = %d", chunks.Len())
+	}
+
+	// Chunks of another length than their manifest entry are rejected
+	chunk := randomData(25, 1000)
+	manifest := NewManifest(config)
+	manifest.Add(0, 1001, Sum(chunk))
+	encoded, err := manifest.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	results := make(chan error, 1)
+	go func() {
+		_, _, err := receiver.Receive(context.Background(), server)
+		server.Close()
+		results <- err
+	}()
+	conn := newSyncConn(client)
+	hello := append(append([]byte(nil), syncMagic...), appendUvarint(appendUvarint(nil, minSyncVersion), SyncVersion)...)
+	if err := conn.send(frameHello, hello); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.expect(frameHello, maxControlFrameSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(frameManifest, appendUvarint(nil, 4), []byte("evil"), encoded); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		payload, err := conn.expect(frameWant, maxControlFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(payload) == 0 {
+			break
+		}
+	}
+	digest := Sum(chunk)
+	conn.send(frameChunk, digest[:], chunk)
+	if err := <-results; !errors.Is(err, ErrSyncProtocol) {
+		t.Errorf("want = %s, got = %s", ErrSyncProtocol, err)
+	}
+	if chunks.Len() != 0 {
+		t.Errorf("chunks: want = 0, got = %d", chunks.Len())
+	}
+}
+
+func TestSyncCanceledContext(t *testing.T) {
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomData(23, 1000)
+	sender, err := NewSyncSender(context.Background(), config, "small", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody is reading on the other end
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sender.Send(ctx, client); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want = %s, got = %s", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,226 @@
+package fastcdc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	ErrNoTuneSample      = errors.New("no tune sample")
+	ErrNoTuneCandidate   = errors.New("no tune candidate")
+	ErrInvalidTuneWeight = errors.New("invalid tune weight")
+)
+
+// TuneSample is a sample of the data to chunk, along with edited versions of it,
+// such as the later revisions of a file. The edited versions let the tuner measure
+// how well a configuration deduplicate the content shared between the versions.
+type TuneSample struct {
+	Data   []byte
+	Edited [][]byte
+}
+
+// TuneWeights are the weights of the criteria in the score of a configuration.
+// The weights must not be negative. The score is a weighted mean, so only the
+// relative weights matter.
+type TuneWeights struct {
+	// Dedup favor the configurations saving the most bytes.
+	Dedup float64
+	// Chunks favor the configurations emitting the fewest chunks,
+	// which have the smallest index and per-chunk overhead.
+	Chunks float64
+	// Variance favor the configurations with the most regular chunk sizes.
+	Variance float64
+	// Throughput favor the fastest configurations.
+	Throughput float64
+}
+
+// DefaultTuneWeights favor the deduplication over the other criteria.
+var DefaultTuneWeights = TuneWeights{Dedup: 4, Chunks: 1, Variance: 1, Throughput: 1}
+
+// TuneResult is the evaluation of a candidate configuration.
+type TuneResult struct {
+	MinSize uint
+	AvgSize uint
+	MaxSize uint
+	// TotalBytes is the number of bytes of the samples and their edited versions.
+	TotalBytes uint
+	// UniqueBytes is the number of bytes in distinct chunks.
+	UniqueBytes uint
+	// Ratio is the deduplication ratio, total bytes / unique bytes.
+	Ratio float64
+	// Chunks is the number of chunks.
+	Chunks uint
+	// MeanSize is the mean chunk size.
+	MeanSize float64
+	// SizeCV is the coefficient of variation of the chunk
+	// sizes, the standard deviation over the mean.
+	SizeCV float64
+	// Throughput is the chunking speed in bytes per second, the digests are
+	// not accounted. It's measured with a single pass and is noisy on small samples.
+	Throughput float64
+	// Score is the weighted score of the configuration, between 0 and 1.
+	Score float64
+}
+
+// Option return the option setting the chunks size of the result.
+func (r TuneResult) Option() Option {
+	return WithChunksSize(r.MinSize, r.AvgSize, r.MaxSize)
+}
+
+// TuneCandidates return the default candidates of the tuner, average chunks size
+// from 1kb to 256kb by power of two, each with a min/max spread of 2 and of 4.
+func TuneCandidates() []*Config {
+	candidates := make([]*Config, 0, 18)
+	for avg := uint(1024); avg <= 262_144; avg *= 2 {
+		for _, spread := range []uint{2, 4} {
+			config, err := NewConfig(WithChunksSize(avg/spread, avg, avg*spread))
+			if err != nil {
+				panic(err)
+			}
+			candidates = append(candidates, config)
+		}
+	}
+	return candidates
+}
+
+// Tune run the samples through every candidate configuration and return their evaluation,
+// by decreasing score. The first result is the recommended configuration. If candidates is
+// nil, the default candidates are used. If all the weights are zero, the default weights
+// are used. Each criterion is scored relatively to the best candidate, the score is only
+// meaningful to compare the candidates of the same run.
+func Tune(ctx context.Context, samples []TuneSample, candidates []*Config, weights TuneWeights) ([]TuneResult, error) {
+	if len(samples) == 0 {
+		return nil, ErrNoTuneSample
+	}
+	if candidates == nil {
+		candidates = TuneCandidates()
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoTuneCandidate
+	}
+	if weights.Dedup < 0 || weights.Chunks < 0 || weights.Variance < 0 || weights.Throughput < 0 {
+		return nil, fmt.Errorf("weights must not be negative: %w", ErrInvalidTuneWeight)
+	}
+	sum := weights.Dedup + weights.Chunks + weights.Variance + weights.Throughput
+	if sum == 0 {
+		weights = DefaultTuneWeights
+		sum = weights.Dedup + weights.Chunks + weights.Variance + weights.Throughput
+	}
+
+	results := make([]TuneResult, len(candidates))
+	for i, config := range candidates {
+		result, err := evaluate(ctx, config, samples)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+
+	// Each criterion is scored by the ratio to the best candidate
+	best := results[0]
+	for _, r := range results[1:] {
+		best.UniqueBytes = minUint(best.UniqueBytes, r.UniqueBytes)
+		best.Chunks = minUint(best.Chunks, r.Chunks)
+		best.SizeCV = math.Min(best.SizeCV, r.SizeCV)
+		best.Throughput = math.Max(best.Throughput, r.Throughput)
+	}
+	for i := range results {
+		r := &results[i]
+		r.Score = (weights.Dedup*relative(float64(best.UniqueBytes), float64(r.UniqueBytes)) +
+			weights.Chunks*relative(float64(best.Chunks), float64(r.Chunks)) +
+			weights.Variance*(1+best.SizeCV)/(1+r.SizeCV) +
+			weights.Throughput*relative(r.Throughput, best.Throughput)) / sum
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// evaluate chunk the samples and their edited versions with the configuration.
+func evaluate(ctx context.Context, config *Config, samples []TuneSample) (TuneResult, error) {
+	result := TuneResult{
+		MinSize: config.MinSize(),
+		AvgSize: config.AvgSize(),
+		MaxSize: config.MaxSize(),
+	}
+	chunker := config.NewChunker(ctx)
+	defer chunker.Release()
+
+	seen := make(map[Digest]struct{})
+	var lengths []uint
+	var elapsed time.Duration
+	var sum, squares float64
+	fn := func(offset, length uint, chunk []byte) error {
+		lengths = append(lengths, length)
+		return nil
+	}
+
+	inputs := make([][]byte, 0, len(samples))
+	for _, sample := range samples {
+		inputs = append(inputs, sample.Data)
+		inputs = append(inputs, sample.Edited...)
+	}
+	for _, input := range inputs {
+		if len(input) == 0 {
+			continue
+		}
+		// Only the chunking is timed, the digests are computed afterward
+		lengths = lengths[:0]
+		start := time.Now()
+		if err := chunker.Split(bytes.NewReader(input), fn); err != nil {
+			return result, err
+		}
+		if err := chunker.Finalize(fn); err != nil {
+			return result, err
+		}
+		elapsed += time.Since(start)
+
+		var offset uint
+		for _, length := range lengths {
+			digest := Sum(input[offset : offset+length])
+			offset += length
+			if _, ok := seen[digest]; !ok {
+				seen[digest] = struct{}{}
+				result.UniqueBytes += length
+			}
+			sum += float64(length)
+			squares += float64(length) * float64(length)
+		}
+		result.TotalBytes += uint(len(input))
+		result.Chunks += uint(len(lengths))
+	}
+
+	result.Ratio = dedupRatio(result.TotalBytes, result.UniqueBytes)
+	if result.Chunks > 0 {
+		n := float64(result.Chunks)
+		result.MeanSize = sum / n
+		variance := squares/n - result.MeanSize*result.MeanSize
+		result.SizeCV = math.Sqrt(math.Max(variance, 0)) / result.MeanSize
+	}
+	if elapsed > 0 {
+		result.Throughput = float64(result.TotalBytes) / elapsed.Seconds()
+	}
+	return result, nil
+}
+
+// relative return x / y, or 1 if y is 0.
+func relative(x, y float64) float64 {
+	if y == 0 {
+		return 1
+	}
+	return x / y
+}
+
+func minUint(x, y uint) uint {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,114 @@
+package fastcdc
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTune(t *testing.T) {
+	// Revisions of a file with a few small edits
+	data := randomData(71, 1<<20)
+	edited := make([][]byte, 4)
+	for i := range edited {
+		at := (i + 1) * len(data) / 5
+		edit := append([]byte(nil), data[:at]...)
+		edit = append(edit, "some inserted bytes"...)
+		edited[i] = append(edit, data[at+100:]...)
+	}
+	samples := []TuneSample{{Data: data, Edited: edited}}
+
+	candidates := make([]*Config, 0, 3)
+	for _, opt := range []Option{With16kChunks(), WithChunksSize(1024, 2048, 8192), With64kChunks()} {
+		config, err := NewConfig(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		candidates = append(candidates, config)
+	}
+
+	tests := map[string]struct {
+		Weights TuneWeights
+		WantAvg uint
+	}{
+		"dedup":   {TuneWeights{Dedup: 1}, 2048},
+		"chunks":  {TuneWeights{Chunks: 1}, 65_536},
+		"default": {TuneWeights{}, 0},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			results, err := Tune(context.Background(), samples, candidates, tc.Weights)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(candidates) {
+				t.Fatalf("results: want = %d, got = %d", len(candidates), len(results))
+			}
+			if tc.WantAvg != 0 && results[0].AvgSize != tc.WantAvg {
+				t.Errorf("recommended avg size: want = %d, got = %d", tc.WantAvg, results[0].AvgSize)
+			}
+			for i, r := range results {
+				if i > 0 && r.Score > results[i-1].Score {
+					t.Errorf("results[%d]: not sorted by score", i)
+				}
+				if r.Score < 0 || r.Score > 1 {
+					t.Errorf("results[%d] score: want in [0, 1], got = %f", i, r.Score)
+				}
+				if r.TotalBytes != 5*uint(len(data))-4*81 {
+					t.Errorf("results[%d] total bytes: want = %d, got = %d", i, 5*len(data)-4*81, r.TotalBytes)
+				}
+				if r.Ratio < 3 {
+					t.Errorf("results[%d] ratio: want >= 3, got = %f", i, r.Ratio)
+				}
+				if r.MeanSize < float64(r.MinSize) || r.MeanSize > float64(r.MaxSize) {
+					t.Errorf("results[%d] mean size: want in [%d, %d], got = %f", i, r.MinSize, r.MaxSize, r.MeanSize)
+				}
+				if r.Throughput <= 0 {
+					t.Errorf("results[%d] throughput: want > 0, got = %f", i, r.Throughput)
+				}
+			}
+
+			// The recommended parameters are usable as is
+			if _, err := NewConfig(results[0].Option()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("default candidates", func(t *testing.T) {
+		results, err := Tune(context.Background(), []TuneSample{{Data: data[:256*1024]}}, nil, TuneWeights{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != len(TuneCandidates()) {
+			t.Errorf("results: want = %d, got = %d", len(TuneCandidates()), len(results))
+		}
+	})
+
+	errs := map[string]struct {
+		Samples    []TuneSample
+		Candidates []*Config
+		Weights    TuneWeights
+		Want       error
+	}{
+		"no sample":       {nil, candidates, TuneWeights{}, ErrNoTuneSample},
+		"no candidate":    {samples, []*Config{}, TuneWeights{}, ErrNoTuneCandidate},
+		"negative weight": {samples, candidates, TuneWeights{Dedup: 1, Chunks: -1}, ErrInvalidTuneWeight},
+	}
+	for name, tc := range errs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Tune(context.Background(), tc.Samples, tc.Candidates, tc.Weights); !errors.Is(err, tc.Want) {
+				t.Errorf("want = %s, got = %v", tc.Want, err)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := Tune(ctx, samples, candidates, TuneWeights{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("want = %s, got = %v", context.Canceled, err)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package fastcdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ManifestProblem is the kind of inconsistency found in a manifest.
+type ManifestProblem int
+
+const (
+	// MissingChunk is a chunk referenced by the manifest but not stored.
+	MissingChunk ManifestProblem = iota
+	// CorruptedChunk is a chunk referenced by the manifest which doesn't match its digest.
+	CorruptedChunk
+	// LengthMismatch is a stored chunk with a different length than recorded in the manifest.
+	LengthMismatch
+	// OffsetGap is a chunk starting after the end of the previous chunk.
+	OffsetGap
+	// OffsetOverlap is a chunk starting before the end of the previous chunk.
+	OffsetOverlap
+	// SizeMismatch is a manifest whose chunks lengths doesn't sum to the recorded file size.
+	SizeMismatch
+)
+
+func (p ManifestProblem) String() string {
+	switch p {
+	case MissingChunk:
+		return "missing chunk"
+	case CorruptedChunk:
+		return "corrupted chunk"
+	case LengthMismatch:
+		return "length mismatch"
+	case OffsetGap:
+		return "offset gap"
+	case OffsetOverlap:
+		return "offset overlap"
+	case SizeMismatch:
+		return "size mismatch"
+	default:
+		return fmt.Sprintf("ManifestProblem(%d)", int(p))
+	}
+}
+
+// ManifestIssue describe an inconsistency found in a manifest. Index is the position of the
+// chunk in the manifest, or -1 for SizeMismatch.
+type ManifestIssue struct {
+	Name    string
+	Problem ManifestProblem
+	Index   int
+	Offset  uint
+	Digest  Digest
+}
+
+func (i ManifestIssue) String() string {
+	if i.Index < 0 {
+		return fmt.Sprintf("%s: %s", i.Name, i.Problem)
+	}
+	return fmt.Sprintf("%s: %s: chunk %d at offset %d (%s)", i.Name, i.Problem, i.Index, i.Offset, i.Digest)
+}
+
+// VerifyReport is the result of a verification.
+type VerifyReport struct {
+	Chunks      uint            // Number of stored chunks verified
+	Bytes       uint            // Number of bytes of the verified chunks
+	Corrupted   []Digest        // Chunks which doesn't match their digest, or can't be read
+	Quarantined []Digest        // Corrupted chunks moved to the quarantine store
+	Manifests   uint            // Number of manifests verified
+	Issues      []ManifestIssue // Inconsistencies found in the manifests
+}
+
+// OK report whether no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Corrupted) == 0 && len(r.Issues) == 0
+}
+
+// Verifier check the integrity of a chunk store and of the manifests referencing its chunks.
+type Verifier struct {
+	store      ChunkStore
+	walker     ChunkWalker
+	manifests  ManifestStore
+	quarantine ChunkStore
+}
+
+// NewVerifier return a verifier for the stores. The chunk store must implement ChunkWalker.
+// If manifests is nil, only the chunks are verified. If quarantine is not nil, corrupted
+// chunks are moved from the chunk store to the quarantine store, still keyed by their
+// expected digest.
+func NewVerifier(store ChunkStore, manifests ManifestStore, quarantine ChunkStore) (*Verifier, error) {
+	walker, ok := store.(ChunkWalker)
+	if !ok {
+		return nil, ErrUnsupportedStore
+	}
+	return &Verifier{
+		store:      store,
+		walker:     walker,
+		manifests:  manifests,
+		quarantine: quarantine,
+	}, nil
+}
+
+// Verify re-hash every stored chunk against its digest, then check that the chunks of every
+// manifest are stored with the recorded length, and that they cover the file without gaps
+// or overlaps. Problems are recorded in the report, an error is only returned if the
+// verification could not complete.
+func (v *Verifier) Verify(ctx context.Context) (*VerifyReport, error) {
+	report := &VerifyReport{
+		Corrupted:   make([]Digest, 0),
+		Quarantined: make([]Digest, 0),
+		Issues:      make([]ManifestIssue, 0),
+	}
+
+	lengths := make(map[Digest]uint)
+	corrupted := make(map[Digest]struct{})
+	err := v.walker.Walk(func(digest Digest) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, err := v.store.Get(digest)
+		if err != nil {
+			// Deleted during the walk
+			if errors.Is(err, ErrChunkNotFound) {
+				return nil
+			}
+			if !errors.Is(err, ErrCorruptedChunk) {
+				return err
+			}
+		}
+		report.Chunks++
+		report.Bytes += uint(len(chunk))
+		if err == nil && Sum(chunk) == digest {
+			lengths[digest] = uint(len(chunk))
+			return nil
+		}
+
+		corrupted[digest] = struct{}{}
+		report.Corrupted = append(report.Corrupted, digest)
+		if v.quarantine == nil || chunk == nil {
+			return nil
+		}
+		if err := v.quarantine.Put(digest, chunk); err != nil {
+			return err
+		}
+		if err := v.store.Delete(digest); err != nil {
+			return err
+		}
+		report.Quarantined = append(report.Quarantined, digest)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if v.manifests == nil {
+		return report, nil
+	}
+	err = v.manifests.WalkManifests(func(name string, manifest *Manifest) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Manifests++
+		return v.verifyManifest(name, manifest, lengths, corrupted, report)
+	})
+	return report, err
+}
+
+func (v *Verifier) verifyManifest(name string, manifest *Manifest, lengths map[Digest]uint, corrupted map[Digest]struct{}, report *VerifyReport) error {
+	issue := func(problem ManifestProblem, i int) {
+		chunk := manifest.Chunks[i]
+		report.Issues = append(report.Issues, ManifestIssue{
+			Name:    name,
+			Problem: problem,
+			Index:   i,
+			Offset:  chunk.Offset,
+			Digest:  chunk.Digest,
+		})
+	}
+
+	var end, total uint
+	for i, chunk := range manifest.Chunks {
+		switch {
+		case chunk.Offset > end:
+			issue(OffsetGap, i)
+		case chunk.Offset < end:
+			issue(OffsetOverlap, i)
+		}
+		end = chunk.Offset + chunk.Length
+		total += chunk.Length
+
+		if _, ok := corrupted[chunk.Digest]; ok {
+			issue(CorruptedChunk, i)
+			continue
+		}
+		length, ok := lengths[chunk.Digest]
+		if !ok {
+			// Stored after the chunks were verified
+			data, err := v.store.Get(chunk.Digest)
+			if err != nil {
+				if errors.Is(err, ErrChunkNotFound) {
+					issue(MissingChunk, i)
+					continue
+				}
+				if errors.Is(err, ErrCorruptedChunk) {
+					issue(CorruptedChunk, i)
+					continue
+				}
+				return err
+			}
+			if Sum(data) != chunk.Digest {
+				issue(CorruptedChunk, i)
+				continue
+			}
+			length = uint(len(data))
+		}
+		if length != chunk.Length {
+			issue(LengthMismatch, i)
+		}
+	}
+
+	if total != manifest.Size {
+		report.Issues = append(report.Issues, ManifestIssue{Name: name, Problem: SizeMismatch, Index: -1})
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package fastcdc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "chunks")
+	chunks, err := NewFileStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := NewMemoryManifestStore()
+
+	config, err := NewConfig(With16kChunks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := sekienManifest(t, config, chunks)
+	if err := manifests.PutManifest("sekien", manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewVerifier(chunks, manifests, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := verifier.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("unexpected problems: %+v", report)
+	}
+	if report.Chunks != 6 || report.Bytes != manifest.Size || report.Manifests != 1 {
+		t.Errorf("want = 6 chunks of %d bytes and 1 manifest, got = %+v", manifest.Size, report)
+	}
+
+	// Corrupt a chunk on disk and delete another
+	corrupted, missing := manifest.Chunks[1].Digest, manifest.Chunks[4].Digest
+	path := filepath.Join(root, corrupted.String()[:2], corrupted.String())
+	if err := ioutil.WriteFile(path, []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := chunks.Delete(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manifest with a gap, an overlap, a wrong length and a wrong size
+	broken := &Manifest{
+		ManifestHeader: manifest.ManifestHeader,
+		Size:           manifest.Size,
+		Chunks:         append([]ManifestChunk(nil), manifest.Chunks[:4]...),
+	}
+	broken.Chunks[0].Length--
+	broken.Chunks[2].Offset -= 10
+	if err := manifests.PutManifest("broken", broken); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantine := NewMemoryStore()
+	verifier, err = NewVerifier(chunks, manifests, quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = verifier.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("problems not reported")
+	}
+	if !reflect.DeepEqual([]Digest{corrupted}, report.Corrupted) {
+		t.Errorf("corrupted: want = %v, got = %v", []Digest{corrupted}, report.Corrupted)
+	}
+	if !reflect.DeepEqual([]Digest{corrupted}, report.Quarantined) {
+		t.Errorf("quarantined: want = %v, got = %v", []Digest{corrupted}, report.Quarantined)
+	}
+	if ok, _ := chunks.Has(corrupted); ok {
+		t.Error("corrupted chunk not removed from the store")
+	}
+	if data, _ := quarantine.Get(corrupted); string(data) != "bit rot" {
+		t.Errorf("quarantined chunk: want = bit rot, got = %q", data)
+	}
+
+	want := []struct {
+		Name    string
+		Problem ManifestProblem
+		Index   int
+	}{
+		{"broken", LengthMismatch, 0},
+		{"broken", OffsetGap, 1},
+		{"broken", CorruptedChunk, 1},
+		{"broken", OffsetOverlap, 2},
+		{"broken", OffsetGap, 3},
+		{"broken", SizeMismatch, -1},
+		{"sekien", CorruptedChunk, 1},
+		{"sekien", MissingChunk, 4},
+	}
+	if len(report.Issues) != len(want) {
+		t.Fatalf("issues: want = %d, got = %d: %v", len(want), len(report.Issues), report.Issues)
+	}
+	for i, issue := range report.Issues {
+		if issue.Name != want[i].Name || issue.Problem != want[i].Problem || issue.Index != want[i].Index {
+			t.Errorf("issues[%d]: want = %+v, got = %s", i, want[i], issue)
+		}
+	}
+
+	if _, err := NewVerifier(struct{ ChunkStore }{chunks}, nil, nil); !errors.Is(err, ErrUnsupportedStore) {
+		t.Errorf("want = %s, got = %s", ErrUnsupportedStore, err)
+	}
+}